@@ -0,0 +1,423 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// BridgeProtocolGraphite writes plaintext protocol of Graphite over TCP
+	BridgeProtocolGraphite = "graphite"
+	// BridgeProtocolStatsD writes StatsD protocol over UDP
+	BridgeProtocolStatsD = "statsd"
+	// BridgeMetricsPrefix is prefix of health metrics of bridge exporter
+	BridgeMetricsPrefix = "rk_bridge"
+	// BridgeTemplateDefault maps namespace, subsystem, name and labels sorted by name to dotted path
+	BridgeTemplateDefault = "{{.Namespace}}.{{.Subsystem}}.{{.Name}}{{range $k, $v := .Labels}}.{{$k}}.{{$v}}{{end}}"
+
+	bridgeMaxPacketSizeDefault = 1432
+)
+
+var (
+	bridgeInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+	bridgeEmptySegment = regexp.MustCompile(`\.{2,}`)
+)
+
+// BootConfigBridge is Graphite or StatsD bridge exporter config of prom entry.
+//
+// 1: Enabled: Enable bridge exporter.
+// 2: Protocol: One of graphite and statsd.
+// 3: Address: Graphite TCP endpoint or StatsD UDP endpoint, i.e. localhost:2003 or localhost:8125
+// 4: IntervalMs: Interval of gathering and writing metrics in milliseconds.
+// 5: Template: Go template which maps metric into dotted path, see BridgeTemplateDefault.
+// 6: Jitter: Fraction of interval which would be randomized in every cycle.
+// 7: TimeoutMs: Timeout of connecting and writing in milliseconds.
+// 8: MaxPacketSize: Maximum size of StatsD packet in bytes.
+type BootConfigBridge struct {
+	Enabled       bool    `yaml:"enabled" json:"enabled"`
+	Protocol      string  `yaml:"protocol" json:"protocol"`
+	Address       string  `yaml:"address" json:"address"`
+	IntervalMs    int64   `yaml:"intervalMs" json:"intervalMs"`
+	Template      string  `yaml:"template" json:"template"`
+	Jitter        float64 `yaml:"jitter" json:"jitter"`
+	TimeoutMs     int64   `yaml:"timeoutMs" json:"timeoutMs"`
+	MaxPacketSize int     `yaml:"maxPacketSize" json:"maxPacketSize"`
+}
+
+// BridgeMetric is data of naming template.
+//
+// Namespace, Subsystem and Name were split from metric name at the first two underscores,
+// i.e. rk_svc_requests_total would be split into rk, svc and requests_total,
+// Namespace and Subsystem would be empty and Name would be metric name if it contains less than two underscores.
+// Characters other than letters, digits, underscores and hyphens in label values were replaced with underscores.
+type BridgeMetric struct {
+	FullName  string
+	Namespace string
+	Subsystem string
+	Name      string
+	Labels    map[string]string
+}
+
+// BridgeExporter gathers metrics periodically and writes them to Graphite or StatsD endpoint.
+// Counters were written as deltas to StatsD since StatsD aggregates counters by itself, see DeltaCalculator.
+// thread safe
+//
+// 1: protocol:      graphite or statsd
+// 2: address:       graphite TCP endpoint or StatsD UDP endpoint
+// 3: template:      go template which maps metric into dotted path
+// 4: timeout:       timeout of connecting and writing
+// 5: maxPacketSize: maximum size of StatsD packet
+type BridgeExporter struct {
+	periodicExporter
+	Protocol      string             `json:"protocol" yaml:"protocol"`
+	Address       string             `json:"address" yaml:"address"`
+	Template      string             `json:"template" yaml:"template"`
+	Timeout       time.Duration      `json:"timeout" yaml:"timeout"`
+	MaxPacketSize int                `json:"maxPacketSize" yaml:"maxPacketSize"`
+	tmpl          *template.Template `json:"-" yaml:"-"`
+}
+
+// BridgeExporterOption is used while initializing bridge exporter via code
+type BridgeExporterOption func(*BridgeExporter)
+
+// WithProtocolBridge provides protocol, one of graphite and statsd
+func WithProtocolBridge(protocol string) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.Protocol = protocol
+	}
+}
+
+// WithAddressBridge provides Graphite TCP endpoint or StatsD UDP endpoint
+func WithAddressBridge(address string) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.Address = address
+	}
+}
+
+// WithIntervalMSBridge provides interval in milliseconds
+func WithIntervalMSBridge(intervalMs time.Duration) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.IntervalMs = intervalMs
+	}
+}
+
+// WithTemplateBridge provides go template which maps BridgeMetric into dotted path
+func WithTemplateBridge(tmpl string) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.Template = tmpl
+	}
+}
+
+// WithJitterBridge randomizes interval of every cycle, jitter should be in range of (0, 1]
+func WithJitterBridge(jitter float64) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.Jitter = jitter
+	}
+}
+
+// WithTimeoutBridge provides timeout of connecting and writing
+func WithTimeoutBridge(timeout time.Duration) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.Timeout = timeout
+	}
+}
+
+// WithMaxPacketSizeBridge provides maximum size of StatsD packet in bytes
+func WithMaxPacketSizeBridge(size int) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.MaxPacketSize = size
+	}
+}
+
+// WithGathererBridge provides gatherer, prometheus.DefaultGatherer would be used by default
+func WithGathererBridge(gatherer prometheus.Gatherer) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.gatherer = gatherer
+	}
+}
+
+// WithZapLoggerEntryBridge provides ZapLoggerEntry
+func WithZapLoggerEntryBridge(zapLoggerEntry *rkentry.ZapLoggerEntry) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.ZapLoggerEntry = zapLoggerEntry
+	}
+}
+
+// WithEventLoggerEntryBridge provides EventLoggerEntry
+func WithEventLoggerEntryBridge(eventLoggerEntry *rkentry.EventLoggerEntry) BridgeExporterOption {
+	return func(exporter *BridgeExporter) {
+		exporter.EventLoggerEntry = eventLoggerEntry
+	}
+}
+
+// NewBridgeExporter creates a new bridge exporter
+// 1: protocol:   should be one of graphite and statsd
+// 2: address:    should be a non empty address
+// 3: template:   should be a valid go template, BridgeTemplateDefault would be used if empty
+func NewBridgeExporter(opts ...BridgeExporterOption) (*BridgeExporter, error) {
+	exporter := &BridgeExporter{
+		periodicExporter: newPeriodicExporter(),
+		Template:         BridgeTemplateDefault,
+		Timeout:          rkentry.DefaultTimeout,
+		MaxPacketSize:    bridgeMaxPacketSizeDefault,
+	}
+
+	for i := range opts {
+		opts[i](exporter)
+	}
+
+	if exporter.Protocol != BridgeProtocolGraphite && exporter.Protocol != BridgeProtocolStatsD {
+		return nil, errors.New(fmt.Sprintf("invalid protocol:%s", exporter.Protocol))
+	}
+
+	if len(exporter.Address) < 1 {
+		return nil, errors.New("empty address")
+	}
+
+	if len(exporter.Template) < 1 {
+		exporter.Template = BridgeTemplateDefault
+	}
+
+	tmpl, err := template.New("bridge").Option("missingkey=zero").Parse(exporter.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid template")
+	}
+	exporter.tmpl = tmpl
+
+	if exporter.Timeout <= 0 {
+		exporter.Timeout = rkentry.DefaultTimeout
+	}
+
+	if exporter.MaxPacketSize < 1 {
+		exporter.MaxPacketSize = bridgeMaxPacketSizeDefault
+	}
+
+	// deltas of counters would be written to StatsD
+	if exporter.Protocol == BridgeProtocolStatsD {
+		exporter.delta = NewDeltaCalculator()
+	}
+
+	if err := exporter.init("bridge exporter", exporter.Protocol+"://"+exporter.Address, exporter, BridgeMetricsPrefix, prometheus.Labels{
+		"bridge_protocol": exporter.Protocol,
+		"bridge_address":  exporter.Address,
+	}); err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// encode metrics into lines of protocol, graphite accepts a stream of lines while StatsD accepts lines packed into packets.
+// Lines of a metric would be packed into the same packet unless they exceeded a packet, metric would be carried
+// by the packet containing its last line, so that deltas would be committed once it was delivered.
+func (exporter *BridgeExporter) encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error) {
+	if exporter.Protocol == BridgeProtocolGraphite {
+		lines, err := exporter.render(toTimeSeries(families, nil, now))
+		if err != nil || len(lines) < 1 {
+			return nil, err
+		}
+
+		return []*exportPayload{{body: []byte(strings.Join(lines, "\n") + "\n")}}, nil
+	}
+
+	packer := &linePacker{size: exporter.MaxPacketSize}
+	carried := make(map[int][]*dto.MetricFamily)
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			single := &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type, Metric: []*dto.Metric{metric}}
+
+			deltas := []*dto.MetricFamily{single}
+			if exporter.delta != nil {
+				deltas = exporter.delta.Delta(deltas)
+			}
+
+			lines, err := exporter.render(toTimeSeries(deltas, nil, now))
+			if err != nil {
+				return nil, err
+			}
+
+			if !packer.fits(len(strings.Join(lines, "\n"))) {
+				packer.flush()
+			}
+
+			for _, line := range lines {
+				packer.add(line)
+			}
+
+			index := len(packer.packets)
+			carried[index] = append(carried[index], single)
+		}
+	}
+	packer.flush()
+
+	res := make([]*exportPayload, 0, len(packer.packets))
+	for i, packet := range packer.packets {
+		res = append(res, &exportPayload{body: []byte(packet), families: carried[i]})
+	}
+
+	return res, nil
+}
+
+// send a payload to endpoint over TCP for graphite or UDP for StatsD
+func (exporter *BridgeExporter) send(ctx context.Context, payload []byte) error {
+	network := "tcp"
+	if exporter.Protocol == BridgeProtocolStatsD {
+		network = "udp"
+	}
+
+	dialer := &net.Dialer{Timeout: exporter.Timeout}
+	conn, err := dialer.DialContext(ctx, network, exporter.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(exporter.Timeout)); err != nil {
+		return err
+	}
+
+	_, err = conn.Write(payload)
+	return err
+}
+
+// render series into lines of protocol, NaN and Inf values would be skipped.
+// Values of cumulative series should be deltas already for StatsD, zero deltas would be skipped.
+func (exporter *BridgeExporter) render(series []*timeSeries) ([]string, error) {
+	res := make([]string, 0, len(series))
+
+	for _, ts := range series {
+		if math.IsNaN(ts.value) || math.IsInf(ts.value, 0) {
+			continue
+		}
+
+		path, err := exporter.path(ts)
+		if err != nil {
+			return nil, err
+		}
+
+		switch exporter.Protocol {
+		case BridgeProtocolGraphite:
+			res = append(res, fmt.Sprintf("%s %s %d", path, formatFloat(ts.value), ts.timestampMs/1000))
+		case BridgeProtocolStatsD:
+			if !ts.cumulative {
+				// signed gauge value would be read as a change by StatsD, reset to zero before setting negative value,
+				// both lines were rendered as one so that they would be packed into the same packet
+				line := fmt.Sprintf("%s:%s|g", path, formatFloat(ts.value))
+				if ts.value < 0 {
+					line = fmt.Sprintf("%s:0|g\n%s", path, line)
+				}
+				res = append(res, line)
+				continue
+			}
+
+			if ts.value != 0 {
+				res = append(res, fmt.Sprintf("%s:%s|c", path, formatFloat(ts.value)))
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// path renders dotted path of series with template
+func (exporter *BridgeExporter) path(ts *timeSeries) (string, error) {
+	metric := &BridgeMetric{
+		Labels: make(map[string]string, len(ts.labels)),
+	}
+
+	for _, pair := range ts.labels {
+		if pair.GetName() == model.MetricNameLabel {
+			metric.FullName = pair.GetValue()
+			continue
+		}
+
+		metric.Labels[pair.GetName()] = bridgeInvalidChars.ReplaceAllString(pair.GetValue(), "_")
+	}
+
+	metric.Name = metric.FullName
+	if parts := strings.SplitN(metric.FullName, "_", 3); len(parts) == 3 {
+		metric.Namespace, metric.Subsystem, metric.Name = parts[0], parts[1], parts[2]
+	}
+
+	buf := &bytes.Buffer{}
+	if err := exporter.tmpl.Execute(buf, metric); err != nil {
+		return "", errors.Wrap(err, "failed to render template")
+	}
+
+	// segments of empty namespace, subsystem and label values would be removed
+	return strings.Trim(bridgeEmptySegment.ReplaceAllString(buf.String(), "."), "."), nil
+}
+
+// String returns string value of BridgeExporter
+func (exporter *BridgeExporter) String() string {
+	bytes, err := json.Marshal(exporter)
+	if err != nil {
+		// failed to marshal, just return empty string
+		return "{}"
+	}
+
+	return string(bytes)
+}
+
+// packLines joins lines with newline into packets no larger than size,
+// line longer than size would be packed alone
+func packLines(lines []string, size int) []string {
+	packer := &linePacker{size: size}
+	for _, line := range lines {
+		packer.add(line)
+	}
+	packer.flush()
+
+	return packer.packets
+}
+
+// linePacker joins lines with newline into packets no larger than size, line longer than size would be packed alone
+type linePacker struct {
+	size    int
+	buf     strings.Builder
+	packets []string
+}
+
+// fits returns true if n bytes could be appended into current packet
+func (p *linePacker) fits(n int) bool {
+	return p.buf.Len() < 1 || p.buf.Len()+1+n <= p.size
+}
+
+// add line into current packet, current packet would be flushed if line does not fit
+func (p *linePacker) add(line string) {
+	if !p.fits(len(line)) {
+		p.flush()
+	}
+
+	if p.buf.Len() > 0 {
+		p.buf.WriteString("\n")
+	}
+	p.buf.WriteString(line)
+}
+
+// flush current packet if it is not empty
+func (p *linePacker) flush() {
+	if p.buf.Len() > 0 {
+		p.packets = append(p.packets, p.buf.String())
+		p.buf.Reset()
+	}
+}
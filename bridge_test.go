@@ -0,0 +1,352 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"bufio"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// graphiteMock is a local stand-in of Graphite which records received lines
+type graphiteMock struct {
+	listener net.Listener
+	lock     sync.Mutex
+	lines    []string
+}
+
+func newGraphiteMock(t *testing.T) *graphiteMock {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	mock := &graphiteMock{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				mock.lock.Lock()
+				mock.lines = append(mock.lines, scanner.Text())
+				mock.lock.Unlock()
+			}
+			conn.Close()
+		}
+	}()
+
+	return mock
+}
+
+func (mock *graphiteMock) getLines() []string {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	return append([]string{}, mock.lines...)
+}
+
+// statsDMock is a local stand-in of StatsD which records received packets
+type statsDMock struct {
+	conn net.PacketConn
+}
+
+func newStatsDMock(t *testing.T) *statsDMock {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	return &statsDMock{conn: conn}
+}
+
+// read packets until timeout
+func (mock *statsDMock) read(t *testing.T) []string {
+	res := make([]string, 0)
+	buf := make([]byte, 65535)
+
+	for {
+		assert.Nil(t, mock.conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		n, _, err := mock.conn.ReadFrom(buf)
+		if err != nil {
+			return res
+		}
+		res = append(res, string(buf[:n]))
+	}
+}
+
+func TestNewBridgeExporter_WithInvalidArgs(t *testing.T) {
+	// with invalid protocol
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge("collectd"),
+		WithAddressBridge("localhost:2003"))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// without address
+	exporter, err = NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolGraphite))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// with invalid template
+	exporter, err = NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolGraphite),
+		WithAddressBridge("localhost:2003"),
+		WithTemplateBridge("{{.Name"))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+}
+
+func TestNewBridgeExporter_HappyCase(t *testing.T) {
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolStatsD),
+		WithAddressBridge("localhost:8125"),
+		WithMaxPacketSizeBridge(-1),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, exportIntervalDefault, exporter.IntervalMs)
+	assert.Equal(t, BridgeTemplateDefault, exporter.Template)
+	assert.Equal(t, rkentry.DefaultTimeout, exporter.Timeout)
+	assert.Equal(t, bridgeMaxPacketSizeDefault, exporter.MaxPacketSize)
+	assert.False(t, exporter.IsRunning())
+}
+
+func TestBridgeExporter_ExportNow_WithGraphite(t *testing.T) {
+	graphite := newGraphiteMock(t)
+	defer graphite.listener.Close()
+
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolGraphite),
+		WithAddressBridge(graphite.listener.Addr().String()),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rk",
+		Subsystem: "svc",
+		Name:      "requests_total",
+	}, []string{"path", "code"})
+	counter.WithLabelValues("/v1/users", "200").Add(3)
+	registry.MustRegister(counter, prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	// counters were written as they are
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	assert.Eventually(t, func() bool {
+		return len(graphite.getLines()) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	lines := graphite.getLines()
+	sort.Strings(lines)
+	assert.True(t, strings.HasPrefix(lines[0], "rk.svc.requests_total.code.200.path._v1_users 3 "))
+	assert.True(t, strings.HasPrefix(lines[2], "up 0 "))
+}
+
+func TestBridgeExporter_ExportNow_WithStatsD(t *testing.T) {
+	statsD := newStatsDMock(t)
+	defer statsD.conn.Close()
+
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolStatsD),
+		WithAddressBridge(statsD.conn.LocalAddr().String()),
+		WithTemplateBridge("app.{{.FullName}}{{if .Labels.method}}.{{.Labels.method}}{{end}}"),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"method"})
+	counter.WithLabelValues("GET").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight"})
+	gauge.Set(2)
+	registry.MustRegister(counter, gauge)
+	exporter.SetGatherer(registry)
+
+	// the first export sends current value of counters
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	packets := statsD.read(t)
+	assert.Len(t, packets, 1)
+	assert.Equal(t, "app.in_flight:2|g\napp.requests_total.GET:3|c", packets[0])
+
+	// deltas would be sent afterwards, unchanged counters would be skipped
+	counter.WithLabelValues("GET").Add(2)
+	counter.WithLabelValues("POST").Add(1)
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	packets = statsD.read(t)
+	assert.Equal(t, "app.in_flight:2|g\napp.requests_total.GET:2|c\napp.requests_total.POST:1|c", packets[0])
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	packets = statsD.read(t)
+	assert.Equal(t, "app.in_flight:2|g", packets[0])
+}
+
+func TestBridgeExporter_ExportNow_WithNegativeStatsDGauge(t *testing.T) {
+	statsD := newStatsDMock(t)
+	defer statsD.conn.Close()
+
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolStatsD),
+		WithAddressBridge(statsD.conn.LocalAddr().String()),
+		WithTemplateBridge("{{.FullName}}"),
+		WithMaxPacketSizeBridge(16),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "zz_temp"})
+	gauge.Set(-5)
+	exporter.SetGatherer(newRegistryWith(gauge))
+
+	// gauge would be reset to zero before set in the same packet even if it exceeds max packet size
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	packets := statsD.read(t)
+	assert.Len(t, packets, 1)
+	assert.Equal(t, "zz_temp:0|g\nzz_temp:-5|g", packets[0])
+}
+
+func TestBridgeExporter_encode_WithStatsDPackets(t *testing.T) {
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolStatsD),
+		WithAddressBridge("localhost:8125"),
+		WithTemplateBridge("{{.FullName}}"),
+		WithMaxPacketSizeBridge(30),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_total"}, []string{"code"})
+	counter.WithLabelValues("200").Add(1)
+	counter.WithLabelValues("500").Add(2)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ut_seconds", Buckets: []float64{1}})
+	histogram.Observe(0.5)
+	families, err := newRegistryWith(counter, histogram).Gather()
+	assert.Nil(t, err)
+
+	// metric would be carried by the packet containing its last line, lines of histogram exceeded a packet
+	payloads, err := exporter.encode(families, time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 5)
+	for i, payload := range payloads {
+		assert.LessOrEqual(t, len(payload.body), 30)
+		assert.Equal(t, i == 3 || i == 4, len(payload.families) > 0)
+	}
+	assert.Equal(t, "ut_seconds_count:1|c", string(payloads[3].body))
+	assert.Equal(t, "ut_seconds", payloads[3].families[0].GetName())
+	// metrics fit in a packet were packed together
+	assert.Equal(t, "ut_total:1|c\nut_total:2|c", string(payloads[4].body))
+	assert.Len(t, payloads[4].families, 2)
+}
+
+func TestBridgeExporter_render_WithCounterReset(t *testing.T) {
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolStatsD),
+		WithAddressBridge("localhost:8125"),
+		WithTemplateBridge("{{.FullName}}"),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total"})
+	counter.Add(10)
+	families, err := newRegistryWith(counter).Gather()
+	assert.Nil(t, err)
+	exporter.delta.Commit(families, time.Now())
+
+	// counter restarted from zero
+	counter = prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total"})
+	counter.Add(4)
+	families, err = newRegistryWith(counter).Gather()
+	assert.Nil(t, err)
+	lines, err := exporter.render(toTimeSeries(exporter.delta.Delta(families), nil, time.Now()))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"requests_total:4|c"}, lines)
+}
+
+func TestBridgeExporter_ExportNow_WithUnreachableGraphite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	exporter, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolGraphite),
+		WithAddressBridge(address),
+		WithGathererBridge(newRegistryWith(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	assert.NotNil(t, exporter.ExportNow(context.Background()))
+	assert.Equal(t, int64(1), exporter.Health().ConsecutiveFailures)
+}
+
+func TestPackLines(t *testing.T) {
+	assert.Empty(t, packLines(nil, 10))
+	assert.Equal(t, []string{"a:1|c\nb:1|c", "c:1|c"}, packLines([]string{"a:1|c", "b:1|c", "c:1|c"}, 11))
+	// line larger than size would be sent in its own packet
+	assert.Equal(t, []string{"a:1|c", "long:100|c"}, packLines([]string{"a:1|c", "long:100|c"}, 6))
+}
+
+func TestRegisterPromEntriesWithConfig_WithBridges(t *testing.T) {
+	bootFileWithBridges := `
+---
+prom:
+  enabled: true
+  bridges:
+    - enabled: true
+      protocol: graphite
+      address: "localhost:2003"
+      intervalMs: 2000
+      template: "{{.FullName}}"
+    - enabled: true
+      protocol: statsd
+      address: "localhost:8125"
+      maxPacketSize: 512
+    - enabled: false
+      protocol: statsd
+      address: "localhost:8126"
+    - enabled: true
+      protocol: collectd
+      address: "localhost:25826"
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithBridges), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	// disabled and invalid bridges would be ignored
+	assert.Len(t, entry.Exporters, 2)
+	graphite, statsD := entry.Exporters[0].(*BridgeExporter), entry.Exporters[1].(*BridgeExporter)
+	assert.Equal(t, BridgeProtocolGraphite, graphite.Protocol)
+	assert.Equal(t, 2*time.Second, graphite.IntervalMs)
+	assert.Equal(t, "{{.FullName}}", graphite.Template)
+	assert.Equal(t, BridgeProtocolStatsD, statsD.Protocol)
+	assert.Equal(t, exportIntervalDefault, statsD.IntervalMs)
+	assert.Equal(t, 512, statsD.MaxPacketSize)
+	assert.Contains(t, entry.String(), "statsd://localhost:8125")
+}
+
+// Internal use only
+func newRegistryWith(collectors ...prometheus.Collector) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors...)
+	return registry
+}
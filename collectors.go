@@ -0,0 +1,73 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+)
+
+const (
+	// AppInfoMetricsName is the name of app info gauge which is always 1
+	AppInfoMetricsName = "rk_app_info"
+)
+
+// BootConfigCollectors toggles default collectors registered by prom entry.
+//
+// 1: Go: Enable go collector, true by default.
+// 2: Process: Enable process collector, true by default.
+// 3: BuildInfo: Enable build info collector which reads info from debug.ReadBuildInfo.
+// 4: AppInfo: Enable app info gauge with application name and version.
+type BootConfigCollectors struct {
+	Go        *bool `yaml:"go" json:"go"`
+	Process   *bool `yaml:"process" json:"process"`
+	BuildInfo bool  `yaml:"buildInfo" json:"buildInfo"`
+	AppInfo   bool  `yaml:"appInfo" json:"appInfo"`
+}
+
+// NewAppInfoCollector creates a gauge whose value is always 1 with application name and version
+// from rkentry.AppInfoEntry as labels.
+func NewAppInfoCollector(appInfoEntry *rkentry.AppInfoEntry) prometheus.Collector {
+	if appInfoEntry == nil {
+		appInfoEntry = rkentry.AppInfoEntryDefault()
+	}
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: AppInfoMetricsName,
+		Help: "A metric with a constant '1' value labeled by application name and version.",
+		ConstLabels: prometheus.Labels{
+			"app_name": appInfoEntry.AppName,
+			"version":  appInfoEntry.Version,
+		},
+	})
+	gauge.Set(1)
+
+	return gauge
+}
+
+// registerCollector registers collector into registerer.
+// Collector which was already registered would be ignored, so that the function is idempotent.
+func registerCollector(registerer prometheus.Registerer, collector prometheus.Collector) error {
+	if err := registerer.Register(collector); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toggleCollector registers collector if enabled, otherwise unregisters it.
+// Collectors were identified by descriptors, so a new instance of collector could unregister
+// the one registered previously, i.e. go collector in prometheus.DefaultRegisterer.
+func toggleCollector(registerer prometheus.Registerer, collector prometheus.Collector, enabled bool) error {
+	if enabled {
+		return registerCollector(registerer, collector)
+	}
+
+	registerer.Unregister(collector)
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func gatherNames(t *testing.T, gatherer prometheus.Gatherer) map[string]bool {
+	families, err := gatherer.Gather()
+	assert.Nil(t, err)
+
+	res := make(map[string]bool)
+	for _, family := range families {
+		res[family.GetName()] = true
+	}
+
+	return res
+}
+
+func TestNewAppInfoCollector_HappyCase(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(NewAppInfoCollector(nil)))
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, families, 1)
+	assert.Equal(t, AppInfoMetricsName, families[0].GetName())
+	assert.Equal(t, float64(1), families[0].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestRegisterCollector_WithDuplicate(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registerCollector(registry, prometheus.NewGoCollector()))
+	assert.Nil(t, registerCollector(registry, prometheus.NewGoCollector()))
+}
+
+func TestToggleCollector_WithDisabled(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, toggleCollector(registry, prometheus.NewGoCollector(), true))
+	assert.True(t, gatherNames(t, registry)["go_goroutines"])
+
+	assert.Nil(t, toggleCollector(registry, prometheus.NewGoCollector(), false))
+	assert.False(t, gatherNames(t, registry)["go_goroutines"])
+}
+
+func TestPromEntry_Bootstrap_WithCollectors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(registry),
+		WithGoCollector(false),
+		WithBuildInfoCollector(true),
+		WithAppInfoCollector(true))
+
+	entry.Bootstrap(context.Background())
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+	entry.Interrupt(context.Background())
+
+	// bootstrap twice should not panic
+	entry.Bootstrap(context.Background())
+	defer entry.Interrupt(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	names := gatherNames(t, registry)
+	assert.False(t, names["go_goroutines"])
+	assert.True(t, names["go_build_info"])
+	assert.True(t, names[AppInfoMetricsName])
+	assert.True(t, names["process_start_time_seconds"])
+}
+
+func TestPromEntry_RegisterDefaultCollectors_WithGlobalLabels(t *testing.T) {
+	// restore collectors of default registry
+	defer prometheus.DefaultRegisterer.Register(prometheus.NewGoCollector())
+
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithGlobalLabels(map[string]string{"region": "ut-region"}))
+
+	// collectors registered by client_golang would not collide with the ones of entry
+	assert.Nil(t, entry.registerDefaultCollectors())
+
+	families, err := entry.Gatherer.Gather()
+	assert.Nil(t, err)
+	found := false
+	for _, family := range families {
+		if family.GetName() == "go_goroutines" {
+			found = true
+			assert.Len(t, family.GetMetric(), 1)
+			assert.Equal(t, "region", family.GetMetric()[0].GetLabel()[0].GetName())
+			assert.Equal(t, "ut-region", family.GetMetric()[0].GetLabel()[0].GetValue())
+		}
+	}
+	assert.True(t, found)
+
+	// disabled collectors would be unregistered from default registry
+	entry.EnableGoCollector = false
+	assert.Nil(t, entry.registerDefaultCollectors())
+	assert.False(t, gatherNames(t, prometheus.DefaultGatherer)["go_goroutines"])
+}
+
+func TestRegisterPromEntriesWithConfig_WithCollectors(t *testing.T) {
+	bootFileWithCollectors := `
+---
+prom:
+  enabled: true
+  collectors:
+    go: false
+    buildInfo: true
+    appInfo: true
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithCollectors), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.False(t, entry.EnableGoCollector)
+	assert.True(t, entry.EnableProcessCollector)
+	assert.True(t, entry.EnableBuildInfoCollector)
+	assert.True(t, entry.EnableAppInfoCollector)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeltaCalculator converts consecutive snapshots of gathered metric families into deltas.
+// thread safe
+//
+// Deltas were calculated for value of counters, cumulative count of histogram buckets, sample count and sample sum
+// of histograms and summaries, against the last committed snapshot. Gauges, untyped metrics and quantiles of
+// summaries would be kept as they are.
+//
+// 1: Series which appeared for the first time would be treated as started from zero, so the delta is the value itself.
+// 2: Counter whose value decreased was treated as reset, so the delta is the value itself.
+// 3: Histogram or summary whose sample count decreased, or histogram whose buckets changed, was treated as reset.
+// 4: Series which disappeared would be forgotten once committed, and treated as new if appeared again.
+//
+// Delta and Commit were separated, so that exporters could commit only if deltas were delivered,
+// otherwise, deltas would be accumulated into the next export.
+type DeltaCalculator struct {
+	lock       sync.Mutex
+	previous   map[string]*deltaPoint
+	lastCommit time.Time
+}
+
+// deltaPoint is the committed cumulative value of a series
+type deltaPoint struct {
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+// NewDeltaCalculator creates a new delta calculator without any committed snapshot
+func NewDeltaCalculator() *DeltaCalculator {
+	return &DeltaCalculator{
+		previous: make(map[string]*deltaPoint),
+	}
+}
+
+// Delta returns copies of families whose cumulative values were replaced with deltas against the last
+// committed snapshot, families would be neither modified nor committed.
+// Families in result are one-to-one with families in argument in the same order.
+func (calc *DeltaCalculator) Delta(families []*dto.MetricFamily) []*dto.MetricFamily {
+	calc.lock.Lock()
+	defer calc.lock.Unlock()
+
+	res := make([]*dto.MetricFamily, 0, len(families))
+
+	for _, family := range families {
+		target := &dto.MetricFamily{
+			Name:   family.Name,
+			Help:   family.Help,
+			Type:   family.Type,
+			Metric: make([]*dto.Metric, 0, len(family.GetMetric())),
+		}
+
+		for _, metric := range family.GetMetric() {
+			delta := proto.Clone(metric).(*dto.Metric)
+			prev := calc.previous[deltaKey(family.GetName(), metric)]
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if prev != nil && delta.GetCounter().GetValue() >= prev.value {
+					delta.Counter.Value = proto.Float64(delta.GetCounter().GetValue() - prev.value)
+				}
+			case dto.MetricType_HISTOGRAM:
+				histogram := delta.GetHistogram()
+				if prev != nil && !isHistogramReset(histogram, prev) {
+					histogram.SampleCount = proto.Uint64(histogram.GetSampleCount() - prev.count)
+					histogram.SampleSum = proto.Float64(histogram.GetSampleSum() - prev.value)
+					for i, bucket := range histogram.GetBucket() {
+						bucket.CumulativeCount = proto.Uint64(bucket.GetCumulativeCount() - prev.buckets[i])
+					}
+				}
+			case dto.MetricType_SUMMARY:
+				summary := delta.GetSummary()
+				if prev != nil && summary.GetSampleCount() >= prev.count {
+					summary.SampleCount = proto.Uint64(summary.GetSampleCount() - prev.count)
+					summary.SampleSum = proto.Float64(summary.GetSampleSum() - prev.value)
+				}
+			}
+
+			target.Metric = append(target.Metric, delta)
+		}
+
+		res = append(res, target)
+	}
+
+	return res
+}
+
+// Commit families as the snapshot which would be compared with in the next Delta,
+// series which were not in families would be forgotten.
+func (calc *DeltaCalculator) Commit(families []*dto.MetricFamily, now time.Time) {
+	current := toDeltaPoints(families)
+
+	calc.lock.Lock()
+	defer calc.lock.Unlock()
+
+	calc.previous = current
+	calc.lastCommit = now
+}
+
+// commitDelivered commits series of delivered families. Series which were gathered but not delivered would keep
+// previous values, so that their deltas would be sent again, series which were not gathered would be forgotten.
+func (calc *DeltaCalculator) commitDelivered(gathered, delivered []*dto.MetricFamily, now time.Time) {
+	current := toDeltaPoints(delivered)
+
+	calc.lock.Lock()
+	defer calc.lock.Unlock()
+
+	for key := range toDeltaPoints(gathered) {
+		if _, ok := current[key]; ok {
+			continue
+		}
+
+		if prev, ok := calc.previous[key]; ok {
+			current[key] = prev
+		}
+	}
+
+	calc.previous = current
+	calc.lastCommit = now
+}
+
+// toDeltaPoints returns points of cumulative series keyed by deltaKey
+func toDeltaPoints(families []*dto.MetricFamily) map[string]*deltaPoint {
+	res := make(map[string]*deltaPoint)
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			point := &deltaPoint{}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				point.value = metric.GetCounter().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				point.value = histogram.GetSampleSum()
+				point.count = histogram.GetSampleCount()
+				point.buckets = make([]uint64, 0, len(histogram.GetBucket()))
+				for _, bucket := range histogram.GetBucket() {
+					point.buckets = append(point.buckets, bucket.GetCumulativeCount())
+				}
+			case dto.MetricType_SUMMARY:
+				point.value = metric.GetSummary().GetSampleSum()
+				point.count = metric.GetSummary().GetSampleCount()
+			default:
+				continue
+			}
+
+			res[deltaKey(family.GetName(), metric)] = point
+		}
+	}
+
+	return res
+}
+
+// Next returns deltas of families and commits families immediately
+func (calc *DeltaCalculator) Next(families []*dto.MetricFamily) []*dto.MetricFamily {
+	res := calc.Delta(families)
+	calc.Commit(families, time.Now())
+
+	return res
+}
+
+// LastCommit returns time of the last commit, zero time would be returned if nothing was committed
+func (calc *DeltaCalculator) LastCommit() time.Time {
+	calc.lock.Lock()
+	defer calc.lock.Unlock()
+
+	return calc.lastCommit
+}
+
+// Reset forgets committed snapshot
+func (calc *DeltaCalculator) Reset() {
+	calc.lock.Lock()
+	defer calc.lock.Unlock()
+
+	calc.previous = make(map[string]*deltaPoint)
+	calc.lastCommit = time.Time{}
+}
+
+// isHistogramReset returns true if sample count or any bucket decreased, or buckets changed
+func isHistogramReset(histogram *dto.Histogram, prev *deltaPoint) bool {
+	if histogram.GetSampleCount() < prev.count || len(histogram.GetBucket()) != len(prev.buckets) {
+		return true
+	}
+
+	for i, bucket := range histogram.GetBucket() {
+		if bucket.GetCumulativeCount() < prev.buckets[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deltaKey identifies series by name and sorted labels
+func deltaKey(name string, metric *dto.Metric) string {
+	pairs := append([]*dto.LabelPair{}, metric.GetLabel()...)
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+
+	buf := &strings.Builder{}
+	buf.WriteString(name)
+	for _, pair := range pairs {
+		buf.WriteString(",")
+		buf.WriteString(pair.GetName())
+		buf.WriteString("=")
+		buf.WriteString(strconv.Quote(pair.GetValue()))
+	}
+
+	return buf.String()
+}
+
+// deltaGatherer returns deltas against the previous Gather
+type deltaGatherer struct {
+	gatherer   prometheus.Gatherer
+	calculator *DeltaCalculator
+}
+
+// NewDeltaGatherer wraps gatherer, so that every Gather returns deltas against the previous one.
+// Exporters built on gatherer of prom entry could opt into delta temporality with it, i.e.
+// exporter.SetGatherer(NewDeltaGatherer(entry.Gatherer)). Deltas would be committed on every Gather,
+// so the wrapped gatherer should be used by only one exporter which delivers every Gather.
+func NewDeltaGatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return &deltaGatherer{
+		gatherer:   gatherer,
+		calculator: NewDeltaCalculator(),
+	}
+}
+
+// Gather implements prometheus.Gatherer
+func (g *deltaGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	return g.calculator.Next(families), err
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// counterFamily returns a counter family with a single series labeled with method
+func counterFamily(method string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String("ut_counter"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{
+			Label:   []*dto.LabelPair{{Name: proto.String("method"), Value: proto.String(method)}},
+			Counter: &dto.Counter{Value: proto.Float64(value)},
+		}},
+	}
+}
+
+// histogramFamily returns a histogram family with buckets of 0.5 and 1
+func histogramFamily(count uint64, sum float64, buckets ...uint64) *dto.MetricFamily {
+	histogram := &dto.Histogram{
+		SampleCount: proto.Uint64(count),
+		SampleSum:   proto.Float64(sum),
+	}
+
+	for i, bound := range []float64{0.5, 1} {
+		if i < len(buckets) {
+			histogram.Bucket = append(histogram.Bucket, &dto.Bucket{
+				UpperBound:      proto.Float64(bound),
+				CumulativeCount: proto.Uint64(buckets[i]),
+			})
+		}
+	}
+
+	return &dto.MetricFamily{
+		Name:   proto.String("ut_histogram"),
+		Type:   dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{Histogram: histogram}},
+	}
+}
+
+func TestDeltaCalculator_Next_WithCounter(t *testing.T) {
+	calc := NewDeltaCalculator()
+
+	// series appeared for the first time would be started from zero
+	res := calc.Next([]*dto.MetricFamily{counterFamily("GET", 3)})
+	assert.Equal(t, float64(3), res[0].GetMetric()[0].GetCounter().GetValue())
+
+	res = calc.Next([]*dto.MetricFamily{counterFamily("GET", 5)})
+	assert.Equal(t, float64(2), res[0].GetMetric()[0].GetCounter().GetValue())
+	assert.Equal(t, "method", res[0].GetMetric()[0].GetLabel()[0].GetName())
+
+	// decreased counter would be treated as reset
+	res = calc.Next([]*dto.MetricFamily{counterFamily("GET", 1)})
+	assert.Equal(t, float64(1), res[0].GetMetric()[0].GetCounter().GetValue())
+
+	// series with different labels would be treated as new
+	res = calc.Next([]*dto.MetricFamily{counterFamily("POST", 4)})
+	assert.Equal(t, float64(4), res[0].GetMetric()[0].GetCounter().GetValue())
+
+	// disappeared series would be forgotten
+	res = calc.Next([]*dto.MetricFamily{counterFamily("GET", 6)})
+	assert.Equal(t, float64(6), res[0].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestDeltaCalculator_Delta_WithoutCommit(t *testing.T) {
+	calc := NewDeltaCalculator()
+	assert.True(t, calc.LastCommit().IsZero())
+
+	now := time.Now()
+	calc.Commit([]*dto.MetricFamily{counterFamily("GET", 3)}, now)
+	assert.Equal(t, now, calc.LastCommit())
+
+	// deltas would be accumulated until committed
+	current := counterFamily("GET", 5)
+	res := calc.Delta([]*dto.MetricFamily{current})
+	assert.Equal(t, float64(2), res[0].GetMetric()[0].GetCounter().GetValue())
+	// families in argument would not be modified
+	assert.Equal(t, float64(5), current.GetMetric()[0].GetCounter().GetValue())
+
+	res = calc.Delta([]*dto.MetricFamily{counterFamily("GET", 7)})
+	assert.Equal(t, float64(4), res[0].GetMetric()[0].GetCounter().GetValue())
+
+	calc.Reset()
+	assert.True(t, calc.LastCommit().IsZero())
+	res = calc.Delta([]*dto.MetricFamily{counterFamily("GET", 7)})
+	assert.Equal(t, float64(7), res[0].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestDeltaCalculator_Next_WithHistogram(t *testing.T) {
+	calc := NewDeltaCalculator()
+
+	calc.Next([]*dto.MetricFamily{histogramFamily(3, 1.5, 1, 2)})
+
+	res := calc.Next([]*dto.MetricFamily{histogramFamily(5, 4, 2, 3)})
+	histogram := res[0].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(2), histogram.GetSampleCount())
+	assert.Equal(t, 2.5, histogram.GetSampleSum())
+	assert.Equal(t, uint64(1), histogram.GetBucket()[0].GetCumulativeCount())
+	assert.Equal(t, uint64(1), histogram.GetBucket()[1].GetCumulativeCount())
+	assert.Equal(t, 0.5, histogram.GetBucket()[0].GetUpperBound())
+
+	// decreased bucket would be treated as reset
+	res = calc.Next([]*dto.MetricFamily{histogramFamily(6, 5, 1, 4)})
+	histogram = res[0].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(6), histogram.GetSampleCount())
+	assert.Equal(t, uint64(1), histogram.GetBucket()[0].GetCumulativeCount())
+
+	// changed buckets would be treated as reset
+	res = calc.Next([]*dto.MetricFamily{histogramFamily(7, 6, 7)})
+	histogram = res[0].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(7), histogram.GetSampleCount())
+	assert.Equal(t, uint64(7), histogram.GetBucket()[0].GetCumulativeCount())
+}
+
+func TestDeltaCalculator_Next_WithSummaryAndGauge(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_gauge"})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary", Objectives: map[float64]float64{0.5: 0.05}})
+	registry.MustRegister(gauge, summary)
+
+	gatherer := NewDeltaGatherer(registry)
+
+	gauge.Set(5)
+	summary.Observe(2)
+	_, err := gatherer.Gather()
+	assert.Nil(t, err)
+
+	gauge.Set(3)
+	summary.Observe(4)
+	families, err := gatherer.Gather()
+	assert.Nil(t, err)
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "ut_gauge":
+			// gauges would be kept as they are
+			assert.Equal(t, float64(3), family.GetMetric()[0].GetGauge().GetValue())
+		case "ut_summary":
+			summary := family.GetMetric()[0].GetSummary()
+			assert.Equal(t, uint64(1), summary.GetSampleCount())
+			assert.Equal(t, float64(4), summary.GetSampleSum())
+			// quantiles would be kept as they are
+			assert.Equal(t, float64(2), summary.GetQuantile()[0].GetValue())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"sort"
+	"sync"
+)
+
+const (
+	// ExporterTypePushGateway creates PushGatewayPusher from BootConfigPusher
+	ExporterTypePushGateway = "pushGateway"
+	// ExporterTypeRemoteWrite creates RemoteWriteExporter from BootConfigRemoteWrite
+	ExporterTypeRemoteWrite = "remoteWrite"
+	// ExporterTypeOTLP creates OTLPExporter from BootConfigOTLP
+	ExporterTypeOTLP = "otlp"
+	// ExporterTypeInflux creates InfluxExporter from BootConfigInflux
+	ExporterTypeInflux = "influx"
+	// ExporterTypeBridge creates BridgeExporter from BootConfigBridge
+	ExporterTypeBridge = "bridge"
+)
+
+var (
+	exporterFactoriesLock = &sync.RWMutex{}
+	exporterFactories     = map[string]ExporterFactory{
+		ExporterTypePushGateway: newPushGatewayExporter,
+		ExporterTypeRemoteWrite: newRemoteWriteExporter,
+		ExporterTypeOTLP:        newOTLPExporter,
+		ExporterTypeInflux:      newInfluxExporter,
+		ExporterTypeBridge:      newBridgeExporter,
+	}
+
+	_ Exporter = (*PushGatewayPusher)(nil)
+	_ Exporter = (*MultiPushGatewayPusher)(nil)
+	_ Exporter = (*RemoteWriteExporter)(nil)
+	_ Exporter = (*OTLPExporter)(nil)
+	_ Exporter = (*InfluxExporter)(nil)
+	_ Exporter = (*BridgeExporter)(nil)
+)
+
+// Exporter delivers metrics of prom entry to a remote sink.
+//
+// Exporter would be started while bootstrapping prom entry and stopped while interrupting.
+// Gatherer of prom entry would be assigned if exporter implements SetGatherer(prometheus.Gatherer),
+// health metrics would be registered into prom entry if exporter implements HealthCollector() prometheus.Collector.
+type Exporter interface {
+	// Start starts periodic job of exporter, calling Start of a running exporter should be a no-op
+	Start()
+
+	// Stop stops periodic job and blocks until in-flight export finished or canceled
+	Stop()
+
+	// ExportNow gathers and delivers metrics synchronously
+	ExportNow(ctx context.Context) error
+
+	// Health returns a snapshot of export health
+	Health() HealthStatus
+}
+
+// ExporterFactory creates an exporter from config of prom.exporters[].config in boot config.
+// Keys of config were lower cased while parsing boot config, use DecodeExporterConfig to decode it into struct.
+type ExporterFactory func(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error)
+
+// BootConfigExporter is config of an exporter created by registered factory.
+//
+// 1: Enabled: Enable exporter.
+// 2: Type: Name of factory registered with RegisterExporterFactory.
+// 3: Config: Config of exporter which would be passed to factory.
+type BootConfigExporter struct {
+	Enabled bool                   `yaml:"enabled" json:"enabled"`
+	Type    string                 `yaml:"type" json:"type"`
+	Config  map[string]interface{} `yaml:"config" json:"config"`
+}
+
+// gathererSetter is implemented by exporters which gather metrics from gatherer of prom entry
+type gathererSetter interface {
+	SetGatherer(gatherer prometheus.Gatherer)
+}
+
+// healthCollectorProvider is implemented by exporters which expose health as metrics
+type healthCollectorProvider interface {
+	HealthCollector() prometheus.Collector
+}
+
+// targetProvider is implemented by built-in exporters
+type targetProvider interface {
+	target() string
+}
+
+// exporterTarget returns where exporter delivers metrics to, type of exporter would be returned if unknown
+func exporterTarget(exporter Exporter) string {
+	if provider, ok := exporter.(targetProvider); ok {
+		return provider.target()
+	}
+
+	return fmt.Sprintf("%T", exporter)
+}
+
+// RegisterExporterFactory registers factory with name, so that exporters could be created from boot config
+// with prom.exporters[].type. Factory registered with the same name would be replaced.
+// Thread safe, should be called before RegisterPromEntriesWithConfig, i.e. in init() of plugin package.
+func RegisterExporterFactory(name string, factory ExporterFactory) {
+	if len(name) < 1 || factory == nil {
+		return
+	}
+
+	exporterFactoriesLock.Lock()
+	defer exporterFactoriesLock.Unlock()
+
+	exporterFactories[name] = factory
+}
+
+// GetExporterFactory returns factory registered with name, nil would be returned if missing
+func GetExporterFactory(name string) ExporterFactory {
+	exporterFactoriesLock.RLock()
+	defer exporterFactoriesLock.RUnlock()
+
+	return exporterFactories[name]
+}
+
+// ListExporterFactories returns sorted names of registered factories
+func ListExporterFactories() []string {
+	exporterFactoriesLock.RLock()
+	defer exporterFactoriesLock.RUnlock()
+
+	res := make([]string, 0, len(exporterFactories))
+	for name := range exporterFactories {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// DecodeExporterConfig decodes config passed to ExporterFactory into struct pointed by out,
+// keys were matched with field names case insensitively, the same as boot config.
+func DecodeExporterConfig(config map[string]interface{}, out interface{}) error {
+	return mapstructure.Decode(config, out)
+}
+
+// NewExporterFromConfig creates an exporter with factory registered as config.Type
+func NewExporterFromConfig(config *BootConfigExporter,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	factory := GetExporterFactory(config.Type)
+	if factory == nil {
+		return nil, errors.New(fmt.Sprintf("exporter factory not found, type:%s", config.Type))
+	}
+
+	exporter, err := factory(config.Config, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter == nil {
+		return nil, errors.New(fmt.Sprintf("nil exporter created, type:%s", config.Type))
+	}
+
+	return exporter, nil
+}
+
+// Internal use only
+func newPushGatewayExporter(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	pusherConfig := &BootConfigPusher{}
+	if err := DecodeExporterConfig(config, pusherConfig); err != nil {
+		return nil, err
+	}
+
+	if len(pusherConfig.Targets) > 0 {
+		return nil, errors.New("targets are not supported by pushGateway exporter, use prom.pusher instead")
+	}
+
+	pusherConfig.Enabled = true
+	pusher, err := newPusherFromConfig(pusherConfig, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return pusher, nil
+}
+
+// Internal use only
+func newRemoteWriteExporter(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	remoteWriteConfig := &BootConfigRemoteWrite{}
+	if err := DecodeExporterConfig(config, remoteWriteConfig); err != nil {
+		return nil, err
+	}
+
+	remoteWriteConfig.Enabled = true
+	exporter, err := newRemoteWriteFromConfig(remoteWriteConfig, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// Internal use only
+func newOTLPExporter(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	otlpConfig := &BootConfigOTLP{}
+	if err := DecodeExporterConfig(config, otlpConfig); err != nil {
+		return nil, err
+	}
+
+	otlpConfig.Enabled = true
+	exporter, err := newOTLPFromConfig(otlpConfig, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// Internal use only
+func newInfluxExporter(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	influxConfig := &BootConfigInflux{}
+	if err := DecodeExporterConfig(config, influxConfig); err != nil {
+		return nil, err
+	}
+
+	influxConfig.Enabled = true
+	exporter, err := newInfluxFromConfig(influxConfig, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// Internal use only
+func newBridgeExporter(config map[string]interface{},
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (Exporter, error) {
+	bridgeConfig := &BootConfigBridge{}
+	if err := DecodeExporterConfig(config, bridgeConfig); err != nil {
+		return nil, err
+	}
+
+	bridgeConfig.Enabled = true
+	exporter, err := newBridgeFromConfig(bridgeConfig, zapLoggerEntry, eventLoggerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
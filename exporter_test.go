@@ -0,0 +1,204 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// exporterMock records calls from prom entry
+type exporterMock struct {
+	Endpoint   string
+	IntervalMs int64
+	running    *atomic.Bool
+	exports    *atomic.Int64
+	gatherer   prometheus.Gatherer
+}
+
+func newExporterMock() *exporterMock {
+	return &exporterMock{
+		running: atomic.NewBool(false),
+		exports: atomic.NewInt64(0),
+	}
+}
+
+func (exporter *exporterMock) Start() {
+	exporter.running.Store(true)
+}
+
+func (exporter *exporterMock) Stop() {
+	exporter.running.Store(false)
+}
+
+func (exporter *exporterMock) ExportNow(context.Context) error {
+	exporter.exports.Inc()
+	return nil
+}
+
+func (exporter *exporterMock) Health() HealthStatus {
+	return HealthStatus{TotalPushes: exporter.exports.Load()}
+}
+
+func (exporter *exporterMock) SetGatherer(gatherer prometheus.Gatherer) {
+	exporter.gatherer = gatherer
+}
+
+func TestRegisterExporterFactory(t *testing.T) {
+	RegisterExporterFactory("ut-factory", func(map[string]interface{},
+		*rkentry.ZapLoggerEntry, *rkentry.EventLoggerEntry) (Exporter, error) {
+		return newExporterMock(), nil
+	})
+	defer func() {
+		exporterFactoriesLock.Lock()
+		delete(exporterFactories, "ut-factory")
+		exporterFactoriesLock.Unlock()
+	}()
+
+	// nil factory would be ignored
+	RegisterExporterFactory("ut-nil", nil)
+
+	assert.NotNil(t, GetExporterFactory("ut-factory"))
+	assert.Nil(t, GetExporterFactory("ut-nil"))
+	assert.Contains(t, ListExporterFactories(), "ut-factory")
+	assert.Contains(t, ListExporterFactories(), ExporterTypeRemoteWrite)
+}
+
+func TestNewExporterFromConfig(t *testing.T) {
+	RegisterExporterFactory("ut-failure", func(map[string]interface{},
+		*rkentry.ZapLoggerEntry, *rkentry.EventLoggerEntry) (Exporter, error) {
+		return nil, errors.New("ut error")
+	})
+	defer func() {
+		exporterFactoriesLock.Lock()
+		delete(exporterFactories, "ut-failure")
+		exporterFactoriesLock.Unlock()
+	}()
+
+	// unknown type
+	exporter, err := NewExporterFromConfig(&BootConfigExporter{Type: "ut-unknown"}, zapLoggerEntry, eventLoggerEntry)
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// factory failed
+	exporter, err = NewExporterFromConfig(&BootConfigExporter{Type: "ut-failure"}, zapLoggerEntry, eventLoggerEntry)
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// invalid config of built-in factory, cause would be returned
+	exporter, err = NewExporterFromConfig(&BootConfigExporter{Type: ExporterTypeRemoteWrite}, zapLoggerEntry, eventLoggerEntry)
+	assert.Nil(t, exporter)
+	assert.EqualError(t, err, "empty remote write url")
+
+	// built-in factory
+	exporter, err = NewExporterFromConfig(&BootConfigExporter{
+		Type: ExporterTypePushGateway,
+		Config: map[string]interface{}{
+			"intervalms":    1000,
+			"remoteaddress": "localhost:9091",
+			"jobname":       "ut-job",
+		},
+	}, zapLoggerEntry, eventLoggerEntry)
+	assert.Nil(t, err)
+	assert.Equal(t, "ut-job", exporter.(*PushGatewayPusher).JobName)
+	assert.Equal(t, time.Second, exporter.(*PushGatewayPusher).IntervalMs)
+}
+
+func TestRegisterPromEntriesWithConfig_WithExporters(t *testing.T) {
+	var created *exporterMock
+	RegisterExporterFactory("ut-exporter", func(config map[string]interface{},
+		_ *rkentry.ZapLoggerEntry, _ *rkentry.EventLoggerEntry) (Exporter, error) {
+		created = newExporterMock()
+		return created, DecodeExporterConfig(config, created)
+	})
+	defer func() {
+		exporterFactoriesLock.Lock()
+		delete(exporterFactories, "ut-exporter")
+		exporterFactoriesLock.Unlock()
+	}()
+
+	bootFileWithExporters := `
+---
+prom:
+  enabled: true
+  exporters:
+    - enabled: true
+      type: ut-exporter
+      config:
+        endpoint: "localhost:1234"
+        intervalMs: 2000
+    - enabled: true
+      type: influx
+      config:
+        url: "http://localhost:8086"
+        org: ut-org
+        bucket: ut-bucket
+    - enabled: true
+      type: ut-unknown
+    - enabled: false
+      type: ut-exporter
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithExporters), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	// unknown and disabled exporters would be skipped
+	assert.Len(t, entry.Exporters, 2)
+	assert.Equal(t, created, entry.Exporters[0])
+	assert.Equal(t, "localhost:1234", created.Endpoint)
+	assert.Equal(t, int64(2000), created.IntervalMs)
+	assert.Equal(t, entry.Gatherer, created.gatherer)
+	assert.Equal(t, "http://localhost:8086", entry.Exporters[1].(*InfluxExporter).URL)
+	assert.Contains(t, entry.String(), "http://localhost:8086")
+}
+
+func TestPromEntry_Bootstrap_WithExporters(t *testing.T) {
+	exporter := newExporterMock()
+
+	// one-shot pusher would push while interrupting
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Minute),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher("ut-job"),
+		WithOneShotPusher(true),
+		WithZapLoggerEntryPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithExporters(exporter, nil, pusher))
+	pusher.SetGatherer(entry.Gatherer)
+	assert.Len(t, entry.Exporters, 2)
+
+	entry.Bootstrap(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, exporter.running.Load())
+	assert.False(t, pusher.IsRunning())
+	assert.True(t, gatherNames(t, entry.Gatherer)["rk_pusher_pushes_total"])
+
+	entry.Interrupt(context.Background())
+	assert.False(t, exporter.running.Load())
+	assert.Len(t, gateway.getRequests(), 1)
+}
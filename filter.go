@@ -0,0 +1,220 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"regexp"
+)
+
+// FilterConfig selects metrics which would be pushed, regular expressions are anchored on both ends.
+//
+// 1: Include:       Metric family would be kept only if its name matches one of them, all families would be kept if empty.
+// 2: Exclude:       Metric family would be dropped if its name matches one of them, evaluated after Include.
+// 3: IncludeLabels: Metric would be kept only if value of every label matches its regex.
+// 4: ExcludeLabels: Metric would be dropped if value of any label matches its regex.
+//
+// Missing labels were matched as empty string. Metric families without any metric left would be dropped.
+type FilterConfig struct {
+	Include       []string       `yaml:"include" json:"include"`
+	Exclude       []string       `yaml:"exclude" json:"exclude"`
+	IncludeLabels []LabelMatcher `yaml:"includeLabels" json:"includeLabels"`
+	ExcludeLabels []LabelMatcher `yaml:"excludeLabels" json:"excludeLabels"`
+}
+
+// LabelMatcher matches value of label Name against Regex
+type LabelMatcher struct {
+	Name  string `yaml:"name" json:"name"`
+	Regex string `yaml:"regex" json:"regex"`
+}
+
+// isEmpty returns true if nothing would be filtered
+func (config *FilterConfig) isEmpty() bool {
+	return config == nil ||
+		(len(config.Include) < 1 && len(config.Exclude) < 1 &&
+			len(config.IncludeLabels) < 1 && len(config.ExcludeLabels) < 1)
+}
+
+// labelRegex is a compiled LabelMatcher
+type labelRegex struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+// metricsFilter is a compiled FilterConfig
+type metricsFilter struct {
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	includeLabels []*labelRegex
+	excludeLabels []*labelRegex
+}
+
+// newMetricsFilter compiles config, nil would be returned if config is empty
+func newMetricsFilter(config *FilterConfig) (*metricsFilter, error) {
+	if config.isEmpty() {
+		return nil, nil
+	}
+
+	res := &metricsFilter{}
+
+	var err error
+	if res.include, err = compileAnchored(config.Include); err != nil {
+		return nil, err
+	}
+
+	if res.exclude, err = compileAnchored(config.Exclude); err != nil {
+		return nil, err
+	}
+
+	if res.includeLabels, err = compileLabelMatchers(config.IncludeLabels); err != nil {
+		return nil, err
+	}
+
+	if res.excludeLabels, err = compileLabelMatchers(config.ExcludeLabels); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Internal use only
+func compileAnchored(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+
+	for _, expr := range exprs {
+		regex, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid filter regex:%s", expr)
+		}
+		res = append(res, regex)
+	}
+
+	return res, nil
+}
+
+// Internal use only
+func compileLabelMatchers(matchers []LabelMatcher) ([]*labelRegex, error) {
+	res := make([]*labelRegex, 0, len(matchers))
+
+	for _, matcher := range matchers {
+		if len(matcher.Name) < 1 {
+			return nil, errors.New("empty label name of filter")
+		}
+
+		regex, err := compileAnchored([]string{matcher.Regex})
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &labelRegex{name: matcher.Name, regex: regex[0]})
+	}
+
+	return res, nil
+}
+
+// keepFamily returns true if name of family passes include and exclude regexes
+func (filter *metricsFilter) keepFamily(name string) bool {
+	if len(filter.include) > 0 && !matchAny(filter.include, name) {
+		return false
+	}
+
+	return !matchAny(filter.exclude, name)
+}
+
+// keepMetric returns true if labels of metric pass label matchers
+func (filter *metricsFilter) keepMetric(metric *dto.Metric) bool {
+	if len(filter.includeLabels) < 1 && len(filter.excludeLabels) < 1 {
+		return true
+	}
+
+	labels := make(map[string]string, len(metric.GetLabel()))
+	for _, pair := range metric.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+
+	for _, matcher := range filter.includeLabels {
+		if !matcher.regex.MatchString(labels[matcher.name]) {
+			return false
+		}
+	}
+
+	for _, matcher := range filter.excludeLabels {
+		if matcher.regex.MatchString(labels[matcher.name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Internal use only
+func matchAny(regexes []*regexp.Regexp, value string) bool {
+	for _, regex := range regexes {
+		if regex.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterGatherer drops gathered metric families and metrics which did not pass filter
+type filterGatherer struct {
+	gatherer prometheus.Gatherer
+	filter   *metricsFilter
+}
+
+// NewFilterGatherer wraps gatherer with filter config which would be applied at gather time.
+// Gatherer itself would be returned if config is empty.
+func NewFilterGatherer(gatherer prometheus.Gatherer, config *FilterConfig) (prometheus.Gatherer, error) {
+	filter, err := newMetricsFilter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.wrap(gatherer), nil
+}
+
+// wrap gatherer with filter, gatherer itself would be returned if filter is nil
+func (filter *metricsFilter) wrap(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	if filter == nil || gatherer == nil {
+		return gatherer
+	}
+
+	return &filterGatherer{
+		gatherer: gatherer,
+		filter:   filter,
+	}
+}
+
+// Gather implements prometheus.Gatherer
+func (g *filterGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	res := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		if !g.filter.keepFamily(family.GetName()) {
+			continue
+		}
+
+		metrics := make([]*dto.Metric, 0, len(family.GetMetric()))
+		for _, metric := range family.GetMetric() {
+			if g.filter.keepMetric(metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+
+		if len(metrics) < 1 {
+			continue
+		}
+
+		family.Metric = metrics
+		res = append(res, family)
+	}
+
+	return res, err
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"bytes"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// newFilterRegistry returns a registry with counters of go_ut_total, process_ut_total and ut_requests_total
+func newFilterRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	goCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "go_ut_total"})
+	processCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "process_ut_total"})
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_requests_total"}, []string{"method", "path"})
+	requests.WithLabelValues("GET", "/ut").Inc()
+	requests.WithLabelValues("POST", "/ut").Inc()
+	requests.WithLabelValues("GET", "/healthy").Inc()
+
+	registry.MustRegister(goCounter, processCounter, requests)
+
+	return registry
+}
+
+func TestNewFilterGatherer_WithEmptyConfig(t *testing.T) {
+	registry := newFilterRegistry()
+
+	gatherer, err := NewFilterGatherer(registry, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, registry, gatherer)
+
+	gatherer, err = NewFilterGatherer(registry, &FilterConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, registry, gatherer)
+}
+
+func TestNewFilterGatherer_WithInvalidConfig(t *testing.T) {
+	// invalid name regex
+	gatherer, err := NewFilterGatherer(prometheus.NewRegistry(), &FilterConfig{Include: []string{"("}})
+	assert.Nil(t, gatherer)
+	assert.NotNil(t, err)
+
+	// invalid label regex
+	gatherer, err = NewFilterGatherer(prometheus.NewRegistry(), &FilterConfig{
+		ExcludeLabels: []LabelMatcher{{Name: "path", Regex: "("}},
+	})
+	assert.Nil(t, gatherer)
+	assert.NotNil(t, err)
+
+	// empty label name
+	gatherer, err = NewFilterGatherer(prometheus.NewRegistry(), &FilterConfig{
+		IncludeLabels: []LabelMatcher{{Regex: ".*"}},
+	})
+	assert.Nil(t, gatherer)
+	assert.NotNil(t, err)
+}
+
+func TestFilterGatherer_Gather_WithNames(t *testing.T) {
+	// exclude only
+	gatherer, err := NewFilterGatherer(newFilterRegistry(), &FilterConfig{
+		Exclude: []string{"go_.*", "process_.*"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"ut_requests_total": true}, gatherNames(t, gatherer))
+
+	// include with exclude, regexes are anchored
+	gatherer, err = NewFilterGatherer(newFilterRegistry(), &FilterConfig{
+		Include: []string{".*_total"},
+		Exclude: []string{"process"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{
+		"go_ut_total":       true,
+		"process_ut_total":  true,
+		"ut_requests_total": true,
+	}, gatherNames(t, gatherer))
+}
+
+func TestFilterGatherer_Gather_WithLabels(t *testing.T) {
+	gatherer, err := NewFilterGatherer(newFilterRegistry(), &FilterConfig{
+		IncludeLabels: []LabelMatcher{{Name: "method", Regex: "GET"}},
+		ExcludeLabels: []LabelMatcher{{Name: "path", Regex: "/healthy"}},
+	})
+	assert.Nil(t, err)
+
+	families, err := gatherer.Gather()
+	assert.Nil(t, err)
+
+	// families without labels were dropped since missing label was matched as empty string
+	assert.Len(t, families, 1)
+	assert.Equal(t, "ut_requests_total", families[0].GetName())
+	assert.Len(t, families[0].GetMetric(), 1)
+	assert.Equal(t, "/ut", families[0].GetMetric()[0].GetLabel()[1].GetValue())
+}
+
+func TestPushGatewayPusher_PushNow_WithFilter(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithFilterPusher(&FilterConfig{Exclude: []string{"go_.*", "process_.*"}}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(newFilterRegistry())
+
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	requests := gateway.getRequests()
+	assert.Len(t, requests, 1)
+	assert.True(t, bytes.Contains(requests[0].body, []byte("ut_requests_total")))
+	assert.False(t, bytes.Contains(requests[0].body, []byte("go_ut_total")))
+	assert.False(t, bytes.Contains(requests[0].body, []byte("process_ut_total")))
+}
+
+func TestNewPushGatewayPusher_WithInvalidFilter(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithFilterPusher(&FilterConfig{Include: []string{"["}}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestRegisterPromEntriesWithConfig_WithPusherFilter(t *testing.T) {
+	bootFileWithFilter := `
+---
+prom:
+  enabled: true
+  pusher:
+    enabled: true
+    intervalMs: 1000
+    jobName: ut-job
+    remoteAddress: localhost:9091
+    filter:
+      exclude:
+        - "go_.*"
+        - "process_.*"
+      includeLabels:
+        - name: grpcMethod
+          regex: "Get.*"
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithFilter), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.Exporters, 1)
+	pusher := entry.Exporters[0].(*PushGatewayPusher)
+	assert.Equal(t, []string{"go_.*", "process_.*"}, pusher.Filter.Exclude)
+	// label name would not be lower cased
+	assert.Equal(t, "grpcMethod", pusher.Filter.IncludeLabels[0].Name)
+	assert.NotNil(t, pusher.filter)
+}
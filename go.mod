@@ -3,12 +3,17 @@ module github.com/rookie-ninja/rk-prom
 go 1.14
 
 require (
+	github.com/golang/protobuf v1.5.2
+	github.com/golang/snappy v0.0.4
+	github.com/mitchellh/mapstructure v1.4.1
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.22.0
 	github.com/rookie-ninja/rk-common v1.2.3
 	github.com/rookie-ninja/rk-entry v1.0.4
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/atomic v1.7.0
 	go.uber.org/zap v1.16.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,126 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// HealthStatus is a snapshot of push health, every attempt including retries would be recorded.
+//
+// 1: LastPushTime:        Time of the last attempt
+// 2: LastSuccessTime:     Time of the last successful attempt
+// 3: LastError:           Error of the last failed attempt, cleared after a successful attempt
+// 4: ConsecutiveFailures: Number of failed attempts since the last successful attempt
+// 5: TotalPushes:         Total number of attempts
+// 6: TotalFailures:       Total number of failed attempts
+// 7: TotalBytes:          Total number of bytes sent
+// 8: SpoolBacklog:        Number of failed payloads waiting in spool to be replayed
+type HealthStatus struct {
+	LastPushTime        time.Time `json:"lastPushTime" yaml:"lastPushTime"`
+	LastSuccessTime     time.Time `json:"lastSuccessTime" yaml:"lastSuccessTime"`
+	LastError           string    `json:"lastError" yaml:"lastError"`
+	ConsecutiveFailures int64     `json:"consecutiveFailures" yaml:"consecutiveFailures"`
+	TotalPushes         int64     `json:"totalPushes" yaml:"totalPushes"`
+	TotalFailures       int64     `json:"totalFailures" yaml:"totalFailures"`
+	TotalBytes          int64     `json:"totalBytes" yaml:"totalBytes"`
+	SpoolBacklog        int       `json:"spoolBacklog" yaml:"spoolBacklog"`
+}
+
+// healthTracker records push attempts and exposes them as prometheus metrics.
+// thread safe
+type healthTracker struct {
+	lock   sync.Mutex
+	status HealthStatus
+
+	lastPushDesc            *prometheus.Desc
+	lastSuccessDesc         *prometheus.Desc
+	consecutiveFailuresDesc *prometheus.Desc
+	pushesDesc              *prometheus.Desc
+	failuresDesc            *prometheus.Desc
+	bytesDesc               *prometheus.Desc
+}
+
+// newHealthTracker creates a tracker whose metrics are named with prefix and labeled with constLabels
+func newHealthTracker(prefix string, constLabels prometheus.Labels) *healthTracker {
+	return &healthTracker{
+		lastPushDesc: prometheus.NewDesc(prefix+"_last_push_timestamp_seconds",
+			"Unix timestamp of the last push attempt.", nil, constLabels),
+		lastSuccessDesc: prometheus.NewDesc(prefix+"_last_success_timestamp_seconds",
+			"Unix timestamp of the last successful push.", nil, constLabels),
+		consecutiveFailuresDesc: prometheus.NewDesc(prefix+"_consecutive_failures",
+			"Number of failed push attempts since the last successful push.", nil, constLabels),
+		pushesDesc: prometheus.NewDesc(prefix+"_pushes_total",
+			"Total number of push attempts.", nil, constLabels),
+		failuresDesc: prometheus.NewDesc(prefix+"_failures_total",
+			"Total number of failed push attempts.", nil, constLabels),
+		bytesDesc: prometheus.NewDesc(prefix+"_sent_bytes_total",
+			"Total number of bytes sent.", nil, constLabels),
+	}
+}
+
+// record an attempt with error and bytes sent
+func (h *healthTracker) record(err error, bytes int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	h.status.LastPushTime = now
+	h.status.TotalPushes++
+	h.status.TotalBytes += bytes
+
+	if err != nil {
+		h.status.LastError = err.Error()
+		h.status.ConsecutiveFailures++
+		h.status.TotalFailures++
+		return
+	}
+
+	h.status.LastSuccessTime = now
+	h.status.LastError = ""
+	h.status.ConsecutiveFailures = 0
+}
+
+// get returns a snapshot of status
+func (h *healthTracker) get() HealthStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.status
+}
+
+// Describe implements prometheus.Collector
+func (h *healthTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.lastPushDesc
+	ch <- h.lastSuccessDesc
+	ch <- h.consecutiveFailuresDesc
+	ch <- h.pushesDesc
+	ch <- h.failuresDesc
+	ch <- h.bytesDesc
+}
+
+// Collect implements prometheus.Collector
+func (h *healthTracker) Collect(ch chan<- prometheus.Metric) {
+	status := h.get()
+
+	ch <- prometheus.MustNewConstMetric(h.lastPushDesc, prometheus.GaugeValue, toUnixSeconds(status.LastPushTime))
+	ch <- prometheus.MustNewConstMetric(h.lastSuccessDesc, prometheus.GaugeValue, toUnixSeconds(status.LastSuccessTime))
+	ch <- prometheus.MustNewConstMetric(h.consecutiveFailuresDesc, prometheus.GaugeValue, float64(status.ConsecutiveFailures))
+	ch <- prometheus.MustNewConstMetric(h.pushesDesc, prometheus.CounterValue, float64(status.TotalPushes))
+	ch <- prometheus.MustNewConstMetric(h.failuresDesc, prometheus.CounterValue, float64(status.TotalFailures))
+	ch <- prometheus.MustNewConstMetric(h.bytesDesc, prometheus.CounterValue, float64(status.TotalBytes))
+}
+
+// toUnixSeconds returns 0 for zero time
+func toUnixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker_record(t *testing.T) {
+	tracker := newHealthTracker("ut", nil)
+
+	tracker.record(errors.New("ut-error"), 10)
+	tracker.record(errors.New("ut-error"), 20)
+	status := tracker.get()
+	assert.Equal(t, int64(2), status.TotalPushes)
+	assert.Equal(t, int64(2), status.TotalFailures)
+	assert.Equal(t, int64(2), status.ConsecutiveFailures)
+	assert.Equal(t, int64(30), status.TotalBytes)
+	assert.Equal(t, "ut-error", status.LastError)
+	assert.True(t, status.LastSuccessTime.IsZero())
+
+	tracker.record(nil, 5)
+	status = tracker.get()
+	assert.Equal(t, int64(3), status.TotalPushes)
+	assert.Equal(t, int64(2), status.TotalFailures)
+	assert.Equal(t, int64(0), status.ConsecutiveFailures)
+	assert.Equal(t, int64(35), status.TotalBytes)
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, status.LastPushTime, status.LastSuccessTime)
+}
+
+func TestHealthTracker_Collect(t *testing.T) {
+	tracker := newHealthTracker("ut", prometheus.Labels{"key": "value"})
+	tracker.record(errors.New("ut-error"), 10)
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(tracker))
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, families, 6)
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		assert.Equal(t, "key", family.GetMetric()[0].GetLabel()[0].GetName())
+		if family.GetMetric()[0].GetCounter() != nil {
+			values[family.GetName()] = family.GetMetric()[0].GetCounter().GetValue()
+		} else {
+			values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	assert.Equal(t, float64(1), values["ut_pushes_total"])
+	assert.Equal(t, float64(1), values["ut_failures_total"])
+	assert.Equal(t, float64(10), values["ut_sent_bytes_total"])
+	assert.Equal(t, float64(1), values["ut_consecutive_failures"])
+	assert.Equal(t, float64(0), values["ut_last_success_timestamp_seconds"])
+	assert.True(t, values["ut_last_push_timestamp_seconds"] > 0)
+}
+
+func TestToUnixSeconds(t *testing.T) {
+	assert.Equal(t, float64(0), toUnixSeconds(time.Time{}))
+	assert.Equal(t, float64(1), toUnixSeconds(time.Unix(1, 0)))
+}
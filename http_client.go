@@ -0,0 +1,573 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig is auth config of outgoing requests, it could be used in both of code and boot config.
+// Credentials could be provided inline, from files or from environment variables, files would be
+// re-read once they were modified, so that rotated secrets would be picked up without restarting.
+// Secrets would never be marshaled.
+//
+// 1: BasicAuth:       Basic auth credential of form user:pass, everything after the first colon is the password.
+// 2: BasicAuthFile:   File contains basic auth credential of form user:pass.
+// 3: BasicAuthEnv:    Environment variable contains basic auth credential of form user:pass.
+// 4: BearerToken:     Bearer token.
+// 5: BearerTokenFile: File contains bearer token.
+// 6: BearerTokenEnv:  Environment variable contains bearer token.
+// 7: Headers:         Extra headers, values could be expanded from environment variables with form of $VAR or ${VAR}.
+// 8: Token:           Token sent with scheme of Token, i.e. API token of InfluxDB.
+// 9: TokenFile:       File contains token.
+// 10: TokenEnv:       Environment variable contains token.
+type AuthConfig struct {
+	BasicAuth       string            `yaml:"basicAuth" json:"-"`
+	BasicAuthFile   string            `yaml:"basicAuthFile" json:"basicAuthFile"`
+	BasicAuthEnv    string            `yaml:"basicAuthEnv" json:"basicAuthEnv"`
+	BearerToken     string            `yaml:"bearerToken" json:"-"`
+	BearerTokenFile string            `yaml:"bearerTokenFile" json:"bearerTokenFile"`
+	BearerTokenEnv  string            `yaml:"bearerTokenEnv" json:"bearerTokenEnv"`
+	Headers         map[string]string `yaml:"headers" json:"-"`
+	Token           string            `yaml:"token" json:"-"`
+	TokenFile       string            `yaml:"tokenFile" json:"tokenFile"`
+	TokenEnv        string            `yaml:"tokenEnv" json:"tokenEnv"`
+}
+
+// Internal use only
+func (config *AuthConfig) isEmpty() bool {
+	return config == nil ||
+		(len(config.BasicAuth) < 1 && len(config.BasicAuthFile) < 1 && len(config.BasicAuthEnv) < 1 &&
+			len(config.BearerToken) < 1 && len(config.BearerTokenFile) < 1 && len(config.BearerTokenEnv) < 1 &&
+			len(config.Token) < 1 && len(config.TokenFile) < 1 && len(config.TokenEnv) < 1 &&
+			len(config.Headers) < 1)
+}
+
+// secret is a credential loaded from one of inline value, file or environment variable
+type secret struct {
+	value string
+	env   string
+	file  *secretFile
+}
+
+// newSecret returns nil if none of sources was provided and error if more than one of them were provided
+func newSecret(name, value, file, env string) (*secret, error) {
+	sources := 0
+	for _, source := range []string{value, file, env} {
+		if len(source) > 0 {
+			sources++
+		}
+	}
+
+	if sources < 1 {
+		return nil, nil
+	}
+
+	if sources > 1 {
+		return nil, errors.New(fmt.Sprintf("only one of %s, %sFile and %sEnv could be provided", name, name, name))
+	}
+
+	res := &secret{
+		value: value,
+		env:   env,
+	}
+
+	if len(file) > 0 {
+		res.file = &secretFile{path: file}
+		// fail fast if file is not readable
+		if _, err := res.file.get(); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// get returns trimmed credential
+func (s *secret) get() (string, error) {
+	if s.file != nil {
+		return s.file.get()
+	}
+
+	if len(s.env) > 0 {
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			return "", errors.New(fmt.Sprintf("environment variable %s not defined", s.env))
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	return strings.TrimSpace(s.value), nil
+}
+
+// secretFile caches content of file and re-reads it once modification time or size changed.
+// thread safe
+type secretFile struct {
+	path    string
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// get returns trimmed content of file
+func (f *secretFile) get() (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read secret file")
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read secret file")
+	}
+
+	f.value = strings.TrimSpace(string(content))
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return f.value, nil
+}
+
+// authRoundTripper attaches auth headers to every outgoing request
+type authRoundTripper struct {
+	basicAuth   *secret
+	bearerToken *secret
+	token       *secret
+	headers     map[string]string
+	next        http.RoundTripper
+}
+
+// newAuthRoundTripper wraps next with auth config, next would be returned if config is empty.
+// http.DefaultTransport would be used if next is nil.
+func newAuthRoundTripper(config *AuthConfig, next http.RoundTripper) (http.RoundTripper, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if config.isEmpty() {
+		return next, nil
+	}
+
+	basicAuth, err := newSecret("basicAuth", config.BasicAuth, config.BasicAuthFile, config.BasicAuthEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	bearerToken, err := newSecret("bearerToken", config.BearerToken, config.BearerTokenFile, config.BearerTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newSecret("token", config.Token, config.TokenFile, config.TokenEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	schemes := 0
+	for _, s := range []*secret{basicAuth, bearerToken, token} {
+		if s != nil {
+			schemes++
+		}
+	}
+
+	if schemes > 1 {
+		return nil, errors.New("only one of basic auth, bearer token and token could be provided")
+	}
+
+	// validate inline credential while initializing
+	if basicAuth != nil && len(basicAuth.value) > 0 {
+		if _, _, err := splitBasicAuth(basicAuth.value); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make(map[string]string, len(config.Headers))
+	for k, v := range config.Headers {
+		headers[http.CanonicalHeaderKey(k)] = os.Expand(v, lookupVariable)
+	}
+
+	return &authRoundTripper{
+		basicAuth:   basicAuth,
+		bearerToken: bearerToken,
+		token:       token,
+		headers:     headers,
+		next:        next,
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper should not modify the original request
+	req = req.Clone(req.Context())
+
+	for k, v := range rt.headers {
+		req.Header.Set(k, v)
+	}
+
+	if rt.basicAuth != nil {
+		credential, err := rt.basicAuth.get()
+		if err != nil {
+			return nil, err
+		}
+
+		user, pass, err := splitBasicAuth(credential)
+		if err != nil {
+			return nil, err
+		}
+
+		req.SetBasicAuth(user, pass)
+	}
+
+	if rt.bearerToken != nil {
+		token, err := rt.bearerToken.get()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if rt.token != nil {
+		token, err := rt.token.get()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// splitBasicAuth splits credential at the first colon, so that password could contain colons
+func splitBasicAuth(credential string) (string, string, error) {
+	tokens := strings.SplitN(credential, ":", 2)
+	if len(tokens) != 2 || len(tokens[0]) < 1 {
+		return "", "", errors.New("invalid basic auth, expecting form of user:pass")
+	}
+
+	return tokens[0], tokens[1], nil
+}
+
+// TLSConfig is TLS config of outgoing requests, it could be used in both of code and boot config.
+//
+// 1: CAFile:             CA bundle used to verify server certificates, system roots would be used if empty.
+// 2: CertFile:           Client certificate, should be provided along with KeyFile.
+// 3: KeyFile:            Client key, should be provided along with CertFile.
+// 4: ServerName:         Override server name used to verify certificate of server.
+// 5: MinVersion:         Minimum TLS version, one of 1.0, 1.1, 1.2 and 1.3, 1.2 by default.
+// 6: InsecureSkipVerify: Skip verifying certificate of server, should only be used for testing.
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile" json:"caFile"`
+	CertFile           string `yaml:"certFile" json:"certFile"`
+	KeyFile            string `yaml:"keyFile" json:"keyFile"`
+	ServerName         string `yaml:"serverName" json:"serverName"`
+	MinVersion         string `yaml:"minVersion" json:"minVersion"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+}
+
+// Internal use only
+func (config *TLSConfig) isEmpty() bool {
+	return config == nil || *config == TLSConfig{}
+}
+
+// tlsVersions maps names of TLS versions to constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig creates tls.Config from config, material provided in certStore would be appended,
+// ServerCert of certStore would be trusted and ClientCert along with ClientKey would be used as client certificate
+// if CertFile was not provided. Invalid material would cause errors instead of being ignored.
+func newTLSConfig(config *TLSConfig, certStore *rkentry.CertStore) (*tls.Config, error) {
+	if config == nil {
+		config = &TLSConfig{}
+	}
+
+	res := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if len(config.MinVersion) > 0 {
+		version, ok := tlsVersions[strings.TrimSpace(config.MinVersion)]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("invalid TLS minVersion:%s", config.MinVersion))
+		}
+		res.MinVersion = version
+	}
+
+	// CA bundle
+	if len(config.CAFile) > 0 {
+		content, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read TLS caFile")
+		}
+
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(content) {
+			return nil, errors.New(fmt.Sprintf("no valid certificate found in TLS caFile:%s", config.CAFile))
+		}
+	}
+
+	if certStore != nil && len(certStore.ServerCert) > 0 {
+		if res.RootCAs == nil {
+			res.RootCAs = x509.NewCertPool()
+		}
+
+		if !res.RootCAs.AppendCertsFromPEM(certStore.ServerCert) {
+			return nil, errors.New("no valid server certificate found in cert store")
+		}
+	}
+
+	// client certificate
+	if len(config.CertFile) > 0 || len(config.KeyFile) > 0 {
+		if len(config.CertFile) < 1 || len(config.KeyFile) < 1 {
+			return nil, errors.New("both of TLS certFile and keyFile should be provided")
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load TLS client certificate")
+		}
+		res.Certificates = []tls.Certificate{cert}
+	} else if certStore != nil && (len(certStore.ClientCert) > 0 || len(certStore.ClientKey) > 0) {
+		cert, err := tls.X509KeyPair(certStore.ClientCert, certStore.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate from cert store")
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+
+	return res, nil
+}
+
+const (
+	// CompressionGzip compresses request bodies with gzip
+	CompressionGzip = "gzip"
+)
+
+// HTTPClientConfig is config of outgoing HTTP client, it could be used in both of code and boot config.
+//
+// 1: TimeoutMs:         Timeout of every request in milliseconds, 3000 by default.
+// 2: ProxyURL:          Proxy of every request, HTTP_PROXY, HTTPS_PROXY and NO_PROXY would be used if empty.
+// 3: DisableKeepAlives: Disable HTTP keep-alives, a new connection would be established for every request.
+// 4: MaxIdleConns:      Maximum number of idle connections, 100 by default.
+// 5: IdleConnTimeoutMs: Idle connections would be closed after timeout in milliseconds, 90000 by default.
+// 6: Compression:       Compress request bodies, gzip or empty.
+type HTTPClientConfig struct {
+	TimeoutMs         int64  `yaml:"timeoutMs" json:"timeoutMs"`
+	ProxyURL          string `yaml:"proxyUrl" json:"proxyUrl"`
+	DisableKeepAlives bool   `yaml:"disableKeepAlives" json:"disableKeepAlives"`
+	MaxIdleConns      int    `yaml:"maxIdleConns" json:"maxIdleConns"`
+	IdleConnTimeoutMs int64  `yaml:"idleConnTimeoutMs" json:"idleConnTimeoutMs"`
+	Compression       string `yaml:"compression" json:"compression"`
+}
+
+// httpClientOptions are options of outgoing HTTP client.
+//
+// Client would be used as it is if provided, with auth attached on top of its transport.
+// RoundTripper would replace the transport built from config if provided.
+// TLS could not be used along with either of them, since TLS is configured in transport.
+type httpClientOptions struct {
+	config       *HTTPClientConfig
+	tls          *TLSConfig
+	certStore    *rkentry.CertStore
+	auth         *AuthConfig
+	client       *http.Client
+	roundTripper http.RoundTripper
+}
+
+// newClient validates options and creates http client
+func (opts *httpClientOptions) newClient() (*http.Client, error) {
+	config := opts.config
+	if config == nil {
+		config = &HTTPClientConfig{}
+	}
+
+	if len(config.Compression) > 0 && config.Compression != CompressionGzip {
+		return nil, errors.New(fmt.Sprintf("invalid compression:%s", config.Compression))
+	}
+
+	useTLS := opts.certStore != nil || !opts.tls.isEmpty()
+	if useTLS && (opts.client != nil || opts.roundTripper != nil) {
+		return nil, errors.New("TLS could not be used along with custom http client or round tripper")
+	}
+
+	// use custom client as it is
+	if opts.client != nil {
+		client := *opts.client
+		transport, err := newAuthRoundTripper(opts.auth, client.Transport)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = transport
+
+		return &client, nil
+	}
+
+	transport := opts.roundTripper
+	if transport == nil {
+		// clone default transport, so that proxy and timeouts of dialing would be kept
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		base.DisableKeepAlives = config.DisableKeepAlives
+
+		if len(config.ProxyURL) > 0 {
+			proxy, err := url.Parse(config.ProxyURL)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid proxyUrl")
+			}
+			base.Proxy = http.ProxyURL(proxy)
+		}
+
+		if config.MaxIdleConns > 0 {
+			base.MaxIdleConns = config.MaxIdleConns
+		}
+
+		if config.IdleConnTimeoutMs > 0 {
+			base.IdleConnTimeout = time.Duration(config.IdleConnTimeoutMs) * time.Millisecond
+		}
+
+		if useTLS {
+			conf, err := newTLSConfig(opts.tls, opts.certStore)
+			if err != nil {
+				return nil, err
+			}
+			base.TLSClientConfig = conf
+		}
+
+		transport = base
+	}
+
+	transport, err := newAuthRoundTripper(opts.auth, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := rkentry.DefaultTimeout
+	if config.TimeoutMs > 0 {
+		timeout = time.Duration(config.TimeoutMs) * time.Millisecond
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// gzipRequest compresses body of request and sets Content-Encoding header, request without body would be returned as it is
+func gzipRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Body.Close(); err != nil {
+		return nil, err
+	}
+
+	compressed, err := gzipBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", CompressionGzip)
+
+	return req, nil
+}
+
+// gzipBody compresses body with gzip
+func gzipBody(body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sendRequest sends body to url and returns error if status code is not 2xx. 4xx except 429 would be returned
+// as permanentError, so that rejected requests would not be retried.
+func sendRequest(ctx context.Context, client *http.Client, method, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	// read part of response for error message
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	err = errors.New(fmt.Sprintf("unexpected status code %d while sending request to %s: %s",
+		resp.StatusCode, url, strings.TrimSpace(string(msg))))
+
+	if isPermanentStatus(resp.StatusCode) {
+		return &permanentError{err: err}
+	}
+
+	return err
+}
+
+// isPermanentStatus returns true if request was rejected with 4xx status code except 429,
+// which would never succeed if retried or replayed
+func isPermanentStatus(code int) bool {
+	return code/100 == 4 && code != http.StatusTooManyRequests
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripperMock records the last request
+type roundTripperMock struct {
+	req *http.Request
+}
+
+func (mock *roundTripperMock) RoundTrip(req *http.Request) (*http.Response, error) {
+	mock.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func roundTrip(t *testing.T, rt http.RoundTripper) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, "http://localhost:9091", nil)
+	resp, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.NotNil(t, resp)
+
+	// original request should not be modified
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	return req
+}
+
+func TestSplitBasicAuth(t *testing.T) {
+	user, pass, err := splitBasicAuth("user:pass:with:colon")
+	assert.Nil(t, err)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass:with:colon", pass)
+
+	user, pass, err = splitBasicAuth("user:")
+	assert.Nil(t, err)
+	assert.Equal(t, "user", user)
+	assert.Empty(t, pass)
+
+	_, _, err = splitBasicAuth("user")
+	assert.NotNil(t, err)
+
+	_, _, err = splitBasicAuth(":pass")
+	assert.NotNil(t, err)
+}
+
+func TestNewAuthRoundTripper_WithEmptyConfig(t *testing.T) {
+	next := &roundTripperMock{}
+
+	rt, err := newAuthRoundTripper(nil, next)
+	assert.Nil(t, err)
+	assert.Equal(t, next, rt)
+
+	rt, err = newAuthRoundTripper(&AuthConfig{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.DefaultTransport, rt)
+}
+
+func TestNewAuthRoundTripper_WithInvalidConfig(t *testing.T) {
+	// multiple sources
+	_, err := newAuthRoundTripper(&AuthConfig{BasicAuth: "user:pass", BasicAuthEnv: "ENV"}, nil)
+	assert.NotNil(t, err)
+
+	// both of basic auth and bearer token
+	_, err = newAuthRoundTripper(&AuthConfig{BasicAuth: "user:pass", BearerToken: "token"}, nil)
+	assert.NotNil(t, err)
+
+	// both of bearer token and token
+	_, err = newAuthRoundTripper(&AuthConfig{BearerToken: "token", TokenEnv: "ENV"}, nil)
+	assert.NotNil(t, err)
+
+	// invalid basic auth
+	_, err = newAuthRoundTripper(&AuthConfig{BasicAuth: "user"}, nil)
+	assert.NotNil(t, err)
+
+	// non exist file
+	_, err = newAuthRoundTripper(&AuthConfig{BearerTokenFile: path.Join(t.TempDir(), "non-exist")}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestAuthRoundTripper_WithBasicAuth(t *testing.T) {
+	next := &roundTripperMock{}
+	rt, err := newAuthRoundTripper(&AuthConfig{BasicAuth: "user:pass:word"}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	user, pass, ok := next.req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass:word", pass)
+}
+
+func TestAuthRoundTripper_WithBasicAuthEnv(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_BASIC_AUTH", "user:pass"))
+	defer os.Unsetenv("RK_PROM_UT_BASIC_AUTH")
+
+	next := &roundTripperMock{}
+	rt, err := newAuthRoundTripper(&AuthConfig{BasicAuthEnv: "RK_PROM_UT_BASIC_AUTH"}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	user, pass, ok := next.req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	// env was removed
+	assert.Nil(t, os.Unsetenv("RK_PROM_UT_BASIC_AUTH"))
+	_, err = rt.RoundTrip(httptest.NewRequest(http.MethodPut, "http://localhost:9091", nil))
+	assert.NotNil(t, err)
+}
+
+func TestAuthRoundTripper_WithBearerTokenFile(t *testing.T) {
+	tokenFile := path.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("token-1\n"), os.ModePerm))
+
+	next := &roundTripperMock{}
+	rt, err := newAuthRoundTripper(&AuthConfig{BearerTokenFile: tokenFile}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	assert.Equal(t, "Bearer token-1", next.req.Header.Get("Authorization"))
+
+	// rotate token
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("token-22\n"), os.ModePerm))
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(tokenFile, future, future))
+
+	roundTrip(t, rt)
+	assert.Equal(t, "Bearer token-22", next.req.Header.Get("Authorization"))
+}
+
+func TestAuthRoundTripper_WithToken(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_TOKEN", "token-env"))
+	defer os.Unsetenv("RK_PROM_UT_TOKEN")
+
+	next := &roundTripperMock{}
+	rt, err := newAuthRoundTripper(&AuthConfig{Token: "token"}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	assert.Equal(t, "Token token", next.req.Header.Get("Authorization"))
+
+	rt, err = newAuthRoundTripper(&AuthConfig{TokenEnv: "RK_PROM_UT_TOKEN"}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	assert.Equal(t, "Token token-env", next.req.Header.Get("Authorization"))
+}
+
+func TestAuthRoundTripper_WithHeaders(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_TENANT", "tenant-1"))
+	defer os.Unsetenv("RK_PROM_UT_TENANT")
+
+	next := &roundTripperMock{}
+	rt, err := newAuthRoundTripper(&AuthConfig{
+		BearerToken: "token",
+		Headers: map[string]string{
+			"x-scope-orgid": "${RK_PROM_UT_TENANT}",
+		},
+	}, next)
+	assert.Nil(t, err)
+
+	roundTrip(t, rt)
+	assert.Equal(t, "tenant-1", next.req.Header.Get("X-Scope-OrgID"))
+	assert.Equal(t, "Bearer token", next.req.Header.Get("Authorization"))
+}
+
+func writeCAFile(t *testing.T, server *httptest.Server) string {
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	bytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(caFile, bytes, os.ModePerm))
+
+	return caFile
+}
+
+func TestNewTLSConfig_WithInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	invalidFile := path.Join(dir, "invalid.pem")
+	assert.Nil(t, ioutil.WriteFile(invalidFile, []byte("invalid"), os.ModePerm))
+
+	// invalid min version
+	_, err := newTLSConfig(&TLSConfig{MinVersion: "2.0"}, nil)
+	assert.NotNil(t, err)
+
+	// non exist CA file
+	_, err = newTLSConfig(&TLSConfig{CAFile: path.Join(dir, "non-exist")}, nil)
+	assert.NotNil(t, err)
+
+	// invalid CA file
+	_, err = newTLSConfig(&TLSConfig{CAFile: invalidFile}, nil)
+	assert.NotNil(t, err)
+
+	// key file missing
+	_, err = newTLSConfig(&TLSConfig{CertFile: invalidFile}, nil)
+	assert.NotNil(t, err)
+
+	// invalid key pair
+	_, err = newTLSConfig(&TLSConfig{CertFile: invalidFile, KeyFile: invalidFile}, nil)
+	assert.NotNil(t, err)
+
+	// invalid client cert in cert store
+	_, err = newTLSConfig(nil, &rkentry.CertStore{ClientCert: []byte("invalid")})
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSConfig_HappyCase(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	conf, err := newTLSConfig(&TLSConfig{
+		CAFile:             writeCAFile(t, server),
+		ServerName:         "example.com",
+		MinVersion:         "1.3",
+		InsecureSkipVerify: true,
+	}, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, conf.RootCAs)
+	assert.Equal(t, "example.com", conf.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), conf.MinVersion)
+	assert.True(t, conf.InsecureSkipVerify)
+
+	// defaults
+	conf, err = newTLSConfig(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, conf.RootCAs)
+	assert.Equal(t, uint16(tls.VersionTLS12), conf.MinVersion)
+}
+
+func TestHTTPClientOptions_newClient_WithDefault(t *testing.T) {
+	opts := &httpClientOptions{}
+	client, err := opts.newClient()
+	assert.Nil(t, err)
+	assert.Equal(t, rkentry.DefaultTimeout, client.Timeout)
+
+	transport, ok := client.Transport.(*http.Transport)
+	assert.True(t, ok)
+	// proxy of environment would be kept
+	assert.NotNil(t, transport.Proxy)
+	assert.False(t, transport.DisableKeepAlives)
+}
+
+func TestHTTPClientOptions_newClient_WithConfig(t *testing.T) {
+	opts := &httpClientOptions{
+		config: &HTTPClientConfig{
+			TimeoutMs:         500,
+			ProxyURL:          "http://proxy:3128",
+			DisableKeepAlives: true,
+			MaxIdleConns:      10,
+			IdleConnTimeoutMs: 1000,
+		},
+		tls: &TLSConfig{InsecureSkipVerify: true},
+	}
+	client, err := opts.newClient()
+	assert.Nil(t, err)
+	assert.Equal(t, 500*time.Millisecond, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	assert.True(t, transport.DisableKeepAlives)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, time.Second, transport.IdleConnTimeout)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+	proxy, err := transport.Proxy(httptest.NewRequest(http.MethodPut, "https://localhost:9091", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "proxy:3128", proxy.Host)
+}
+
+func TestHTTPClientOptions_newClient_WithInvalidConfig(t *testing.T) {
+	// invalid compression
+	opts := &httpClientOptions{config: &HTTPClientConfig{Compression: "zstd"}}
+	_, err := opts.newClient()
+	assert.NotNil(t, err)
+
+	// invalid proxy
+	opts = &httpClientOptions{config: &HTTPClientConfig{ProxyURL: "://invalid"}}
+	_, err = opts.newClient()
+	assert.NotNil(t, err)
+
+	// TLS along with custom round tripper
+	opts = &httpClientOptions{tls: &TLSConfig{InsecureSkipVerify: true}, roundTripper: &roundTripperMock{}}
+	_, err = opts.newClient()
+	assert.NotNil(t, err)
+
+	// TLS along with custom client
+	opts = &httpClientOptions{certStore: &rkentry.CertStore{}, client: &http.Client{}}
+	_, err = opts.newClient()
+	assert.NotNil(t, err)
+}
+
+func TestHTTPClientOptions_newClient_WithCustomClient(t *testing.T) {
+	next := &roundTripperMock{}
+	custom := &http.Client{Transport: next, Timeout: time.Minute}
+	opts := &httpClientOptions{
+		client: custom,
+		auth:   &AuthConfig{BearerToken: "token"},
+	}
+
+	client, err := opts.newClient()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, client.Timeout)
+	// custom client should not be modified
+	assert.Equal(t, next, custom.Transport)
+
+	roundTrip(t, client.Transport)
+	assert.Equal(t, "Bearer token", next.req.Header.Get("Authorization"))
+}
+
+func TestGzipRequest(t *testing.T) {
+	// without body
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost:9091", nil)
+	res, err := gzipRequest(req)
+	assert.Nil(t, err)
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+
+	// with body
+	req = httptest.NewRequest(http.MethodPut, "http://localhost:9091", strings.NewReader("metrics"))
+	res, err = gzipRequest(req)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionGzip, res.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(res.Body)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "metrics", string(body))
+}
+
+func TestSendRequest(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "metrics", string(body))
+		w.WriteHeader(status)
+		w.Write([]byte("ut-message"))
+	}))
+	defer server.Close()
+
+	header := http.Header{"Content-Type": {"text/plain"}}
+
+	assert.Nil(t, sendRequest(context.Background(), server.Client(), http.MethodPost, server.URL, []byte("metrics"), header))
+
+	// rejected request would not be retried
+	status = http.StatusBadRequest
+	err := sendRequest(context.Background(), server.Client(), http.MethodPost, server.URL, []byte("metrics"), header)
+	assert.IsType(t, &permanentError{}, err)
+	assert.Contains(t, err.Error(), "ut-message")
+
+	// throttled request would be retried
+	status = http.StatusTooManyRequests
+	err = sendRequest(context.Background(), server.Client(), http.MethodPost, server.URL, []byte("metrics"), header)
+	_, ok := err.(*permanentError)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+
+	status = http.StatusServiceUnavailable
+	err = sendRequest(context.Background(), server.Client(), http.MethodPost, server.URL, []byte("metrics"), header)
+	_, ok = err.(*permanentError)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// InfluxVersion1 writes to /write endpoint of InfluxDB 1.x with database and retention policy
+	InfluxVersion1 = "v1"
+	// InfluxVersion2 writes to /api/v2/write endpoint of InfluxDB 2.x with org and bucket
+	InfluxVersion2 = "v2"
+	// InfluxMetricsPrefix is prefix of health metrics of InfluxDB exporter
+	InfluxMetricsPrefix = "rk_influx"
+
+	influxBatchSizeDefault = 5000
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// BootConfigInflux is InfluxDB exporter config of prom entry.
+//
+// 1: Enabled: Enable InfluxDB exporter.
+// 2: URL: Base URL of InfluxDB, i.e. http://localhost:8086
+// 3: Version: One of v1 and v2, decides which write endpoint would be used, v2 by default.
+// 4: Database: Database of InfluxDB 1.x.
+// 5: RetentionPolicy: Retention policy of InfluxDB 1.x, default retention policy would be used if empty.
+// 6: Org: Organization of InfluxDB 2.x.
+// 7: Bucket: Bucket of InfluxDB 2.x.
+// 8: IntervalMs: Interval of gathering and writing metrics in milliseconds.
+// 9: BatchSize: Maximum number of lines in every request, 5000 by default.
+// 10: Jitter: Fraction of interval which would be randomized in every cycle.
+// 11: Retry: Retry failed requests with exponential backoff in every cycle.
+// 12: Auth: Token, basic auth and extra headers.
+// 13: TLS: CA bundle, client certificate, server name, minimum version and insecureSkipVerify.
+// 14: HTTPClient: Timeout, proxy, keep-alive and compression of http client.
+type BootConfigInflux struct {
+	Enabled         bool             `yaml:"enabled" json:"enabled"`
+	URL             string           `yaml:"url" json:"url"`
+	Version         string           `yaml:"version" json:"version"`
+	Database        string           `yaml:"database" json:"database"`
+	RetentionPolicy string           `yaml:"retentionPolicy" json:"retentionPolicy"`
+	Org             string           `yaml:"org" json:"org"`
+	Bucket          string           `yaml:"bucket" json:"bucket"`
+	IntervalMs      int64            `yaml:"intervalMs" json:"intervalMs"`
+	BatchSize       int              `yaml:"batchSize" json:"batchSize"`
+	Jitter          float64          `yaml:"jitter" json:"jitter"`
+	Retry           BootConfigRetry  `yaml:"retry" json:"retry"`
+	Auth            AuthConfig       `yaml:"auth" json:"auth"`
+	TLS             TLSConfig        `yaml:"tls" json:"tls"`
+	HTTPClient      HTTPClientConfig `yaml:"httpClient" json:"httpClient"`
+}
+
+// InfluxExporter gathers metrics periodically, converts them into line protocol and writes them to InfluxDB.
+// thread safe
+//
+// Measurement is metric name, labels were written as tags and values as fields.
+// Counters, gauges and untyped metrics were written with field of value,
+// histograms with fields of count, sum and cumulative count of every upper bound,
+// summaries with fields of count, sum and value of every quantile.
+//
+// 1: url:             base URL of InfluxDB
+// 2: version:         v1 or v2
+// 3: database:        database of InfluxDB 1.x
+// 4: retentionPolicy: retention policy of InfluxDB 1.x
+// 5: org:             organization of InfluxDB 2.x
+// 6: bucket:          bucket of InfluxDB 2.x
+// 7: batchSize:       maximum number of lines in every request
+// 8: auth:            token, basic auth and extra headers attached to every request
+// 9: tls:             CA bundle, client certificate, server name and minimum version of TLS
+// 10: httpClient:     timeout, proxy, keep-alive and compression of http client
+type InfluxExporter struct {
+	periodicExporter
+	URL             string            `json:"url" yaml:"url"`
+	Version         string            `json:"version" yaml:"version"`
+	Database        string            `json:"database" yaml:"database"`
+	RetentionPolicy string            `json:"retentionPolicy" yaml:"retentionPolicy"`
+	Org             string            `json:"org" yaml:"org"`
+	Bucket          string            `json:"bucket" yaml:"bucket"`
+	BatchSize       int               `json:"batchSize" yaml:"batchSize"`
+	Auth            *AuthConfig       `json:"auth" yaml:"auth"`
+	TLS             *TLSConfig        `json:"tls" yaml:"tls"`
+	HTTPClient      *HTTPClientConfig `json:"httpClient" yaml:"httpClient"`
+	writeURL        string            `json:"-" yaml:"-"`
+	client          *http.Client      `json:"-" yaml:"-"`
+	roundTripper    http.RoundTripper `json:"-" yaml:"-"`
+}
+
+// InfluxExporterOption is used while initializing InfluxDB exporter via code
+type InfluxExporterOption func(*InfluxExporter)
+
+// WithURLInflux provides base URL of InfluxDB
+func WithURLInflux(url string) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.URL = url
+	}
+}
+
+// WithVersionInflux provides version of write endpoint, one of v1 and v2
+func WithVersionInflux(version string) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.Version = version
+	}
+}
+
+// WithDatabaseInflux provides database and retention policy of InfluxDB 1.x
+func WithDatabaseInflux(database, retentionPolicy string) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.Database = database
+		exporter.RetentionPolicy = retentionPolicy
+	}
+}
+
+// WithBucketInflux provides organization and bucket of InfluxDB 2.x
+func WithBucketInflux(org, bucket string) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.Org = org
+		exporter.Bucket = bucket
+	}
+}
+
+// WithIntervalMSInflux provides interval in milliseconds
+func WithIntervalMSInflux(intervalMs time.Duration) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.IntervalMs = intervalMs
+	}
+}
+
+// WithBatchSizeInflux provides maximum number of lines in every request
+func WithBatchSizeInflux(batchSize int) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.BatchSize = batchSize
+	}
+}
+
+// WithJitterInflux randomizes interval of every cycle, jitter should be in range of (0, 1]
+func WithJitterInflux(jitter float64) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.Jitter = jitter
+	}
+}
+
+// WithRetryInflux retries failed requests with exponential backoff and full jitter in every cycle,
+// requests rejected with 4xx status code except 429 would not be retried
+func WithRetryInflux(maxRetries int, initialBackoff, maxBackoff, maxElapsed time.Duration) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.retrier = newRetrier(maxRetries, initialBackoff, maxBackoff, maxElapsed)
+	}
+}
+
+// WithAuthInflux provides auth of InfluxDB, API token should be provided with AuthConfig.Token
+func WithAuthInflux(auth *AuthConfig) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.Auth = auth
+	}
+}
+
+// WithTLSInflux provides TLS config of InfluxDB
+func WithTLSInflux(config *TLSConfig) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.TLS = config
+	}
+}
+
+// WithHTTPClientConfigInflux provides timeout, proxy, keep-alive and compression of http client
+func WithHTTPClientConfigInflux(config *HTTPClientConfig) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.HTTPClient = config
+	}
+}
+
+// WithHTTPClientInflux provides a custom http client which would be used as it is, auth would still be attached
+func WithHTTPClientInflux(client *http.Client) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.client = client
+	}
+}
+
+// WithRoundTripperInflux provides a custom round tripper, auth would still be attached
+func WithRoundTripperInflux(roundTripper http.RoundTripper) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.roundTripper = roundTripper
+	}
+}
+
+// WithGathererInflux provides gatherer, prometheus.DefaultGatherer would be used by default
+func WithGathererInflux(gatherer prometheus.Gatherer) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.gatherer = gatherer
+	}
+}
+
+// WithZapLoggerEntryInflux provides ZapLoggerEntry
+func WithZapLoggerEntryInflux(zapLoggerEntry *rkentry.ZapLoggerEntry) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.ZapLoggerEntry = zapLoggerEntry
+	}
+}
+
+// WithEventLoggerEntryInflux provides EventLoggerEntry
+func WithEventLoggerEntryInflux(eventLoggerEntry *rkentry.EventLoggerEntry) InfluxExporterOption {
+	return func(exporter *InfluxExporter) {
+		exporter.EventLoggerEntry = eventLoggerEntry
+	}
+}
+
+// NewInfluxExporter creates a new InfluxDB exporter
+// 1: url:        should be a non empty url
+// 2: version:    should be one of v1 and v2, v2 would be used if empty
+// 3: database:   should not be empty for v1
+// 4: bucket:     org and bucket should not be empty for v2
+// 5: batchSize:  5000 would be used if not positive
+func NewInfluxExporter(opts ...InfluxExporterOption) (*InfluxExporter, error) {
+	exporter := &InfluxExporter{
+		periodicExporter: newPeriodicExporter(),
+		BatchSize:        influxBatchSizeDefault,
+	}
+
+	for i := range opts {
+		opts[i](exporter)
+	}
+
+	if len(exporter.URL) < 1 {
+		return nil, errors.New("empty InfluxDB url")
+	}
+
+	if len(exporter.Version) < 1 {
+		exporter.Version = InfluxVersion2
+	}
+
+	params := url.Values{}
+	params.Set("precision", "ms")
+
+	switch exporter.Version {
+	case InfluxVersion1:
+		if len(exporter.Database) < 1 {
+			return nil, errors.New("empty database")
+		}
+		params.Set("db", exporter.Database)
+		if len(exporter.RetentionPolicy) > 0 {
+			params.Set("rp", exporter.RetentionPolicy)
+		}
+		exporter.writeURL = strings.TrimSuffix(exporter.URL, "/") + "/write?" + params.Encode()
+	case InfluxVersion2:
+		if len(exporter.Org) < 1 || len(exporter.Bucket) < 1 {
+			return nil, errors.New("empty org or bucket")
+		}
+		params.Set("org", exporter.Org)
+		params.Set("bucket", exporter.Bucket)
+		exporter.writeURL = strings.TrimSuffix(exporter.URL, "/") + "/api/v2/write?" + params.Encode()
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid version:%s", exporter.Version))
+	}
+
+	if exporter.BatchSize < 1 {
+		exporter.BatchSize = influxBatchSizeDefault
+	}
+
+	clientOpts := &httpClientOptions{
+		config:       exporter.HTTPClient,
+		tls:          exporter.TLS,
+		auth:         exporter.Auth,
+		client:       exporter.client,
+		roundTripper: exporter.roundTripper,
+	}
+
+	client, err := clientOpts.newClient()
+	if err != nil {
+		return nil, err
+	}
+	exporter.client = client
+
+	if err := exporter.init("InfluxDB exporter", exporter.URL, exporter, InfluxMetricsPrefix, prometheus.Labels{
+		"influx_url": exporter.URL,
+	}); err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// encode metrics into batches of line protocol, every batch would be compressed if compression of http client is gzip
+func (exporter *InfluxExporter) encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error) {
+	lines := toLineProtocol(families, now)
+	res := make([]*exportPayload, 0, len(lines)/exporter.BatchSize+1)
+
+	for start := 0; start < len(lines); start += exporter.BatchSize {
+		end := start + exporter.BatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		body := []byte(strings.Join(lines[start:end], "\n"))
+		if exporter.HTTPClient != nil && exporter.HTTPClient.Compression == CompressionGzip {
+			compressed, err := gzipBody(body)
+			if err != nil {
+				return nil, err
+			}
+			body = compressed
+		}
+
+		res = append(res, &exportPayload{body: body})
+	}
+
+	return res, nil
+}
+
+// send a batch to write endpoint
+func (exporter *InfluxExporter) send(ctx context.Context, payload []byte) error {
+	header := http.Header{
+		"Content-Type": {"text/plain; charset=utf-8"},
+		"User-Agent":   {"rk-prom"},
+	}
+
+	if exporter.HTTPClient != nil && exporter.HTTPClient.Compression == CompressionGzip {
+		header.Set("Content-Encoding", CompressionGzip)
+	}
+
+	return sendRequest(ctx, exporter.client, http.MethodPost, exporter.writeURL, payload, header)
+}
+
+// String returns string value of InfluxExporter
+func (exporter *InfluxExporter) String() string {
+	bytes, err := json.Marshal(exporter)
+	if err != nil {
+		// failed to marshal, just return empty string
+		return "{}"
+	}
+
+	return string(bytes)
+}
+
+// toLineProtocol converts metric families into lines of InfluxDB line protocol with timestamp in milliseconds.
+// NaN and Inf values would be skipped since they could not be written into InfluxDB,
+// metric without any valid field would be skipped.
+func toLineProtocol(families []*dto.MetricFamily, now time.Time) []string {
+	res := make([]string, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	for _, family := range families {
+		measurement := influxMeasurementEscaper.Replace(family.GetName())
+
+		for _, metric := range family.GetMetric() {
+			fields := make([]string, 0)
+			addField := func(key string, value float64) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return
+				}
+				fields = append(fields, influxKeyEscaper.Replace(key)+"="+formatFloat(value))
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				addField("value", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				addField("value", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				addField("value", metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				addField("count", float64(histogram.GetSampleCount()))
+				addField("sum", histogram.GetSampleSum())
+				for _, bucket := range histogram.GetBucket() {
+					addField(formatBound(bucket.GetUpperBound()), float64(bucket.GetCumulativeCount()))
+				}
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				addField("count", float64(summary.GetSampleCount()))
+				addField("sum", summary.GetSampleSum())
+				for _, quantile := range summary.GetQuantile() {
+					addField(formatBound(quantile.GetQuantile()), quantile.GetValue())
+				}
+			}
+
+			if len(fields) < 1 {
+				continue
+			}
+
+			// labels were sorted by name while gathering, as InfluxDB recommends
+			line := &strings.Builder{}
+			line.WriteString(measurement)
+			for _, pair := range metric.GetLabel() {
+				if len(pair.GetValue()) < 1 {
+					continue
+				}
+				line.WriteString(",")
+				line.WriteString(influxKeyEscaper.Replace(pair.GetName()))
+				line.WriteString("=")
+				line.WriteString(influxKeyEscaper.Replace(pair.GetValue()))
+			}
+
+			timestampMs := nowMs
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+
+			line.WriteString(" ")
+			line.WriteString(strings.Join(fields, ","))
+			line.WriteString(" ")
+			line.WriteString(strconv.FormatInt(timestampMs, 10))
+
+			res = append(res, line.String())
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxReceiver is a local stand-in of InfluxDB write endpoint which records lines
+type influxReceiver struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests [][]string
+	urls     []string
+	headers  []http.Header
+}
+
+func newInfluxReceiver(t *testing.T) *influxReceiver {
+	receiver := &influxReceiver{status: http.StatusNoContent}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.lock.Lock()
+		defer receiver.lock.Unlock()
+
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == CompressionGzip {
+			gzipReader, err := gzip.NewReader(r.Body)
+			assert.Nil(t, err)
+			reader = gzipReader
+		}
+
+		body, _ := ioutil.ReadAll(reader)
+		receiver.requests = append(receiver.requests, strings.Split(string(body), "\n"))
+		receiver.urls = append(receiver.urls, r.URL.String())
+		receiver.headers = append(receiver.headers, r.Header)
+		w.WriteHeader(receiver.status)
+	}))
+
+	return receiver
+}
+
+func (receiver *influxReceiver) getRequests() [][]string {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	return append([][]string{}, receiver.requests...)
+}
+
+func TestNewInfluxExporter_WithInvalidArgs(t *testing.T) {
+	// without url
+	exporter, err := NewInfluxExporter()
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// v2 without bucket
+	exporter, err = NewInfluxExporter(WithURLInflux("http://localhost:8086"))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// v1 without database
+	exporter, err = NewInfluxExporter(
+		WithURLInflux("http://localhost:8086"),
+		WithVersionInflux(InfluxVersion1))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// with invalid version
+	exporter, err = NewInfluxExporter(
+		WithURLInflux("http://localhost:8086"),
+		WithVersionInflux("v3"))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+}
+
+func TestNewInfluxExporter_HappyCase(t *testing.T) {
+	// v1
+	exporter, err := NewInfluxExporter(
+		WithURLInflux("http://localhost:8086/"),
+		WithVersionInflux(InfluxVersion1),
+		WithDatabaseInflux("ut-db", "ut-rp"),
+		WithZapLoggerEntryInflux(zapLoggerEntry),
+		WithEventLoggerEntryInflux(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:8086/write?db=ut-db&precision=ms&rp=ut-rp", exporter.writeURL)
+	assert.Equal(t, 15*time.Second, exporter.IntervalMs)
+	assert.Equal(t, influxBatchSizeDefault, exporter.BatchSize)
+	assert.False(t, exporter.IsRunning())
+
+	// v2 by default
+	exporter, err = NewInfluxExporter(
+		WithURLInflux("http://localhost:8086"),
+		WithBucketInflux("ut-org", "ut-bucket"),
+		WithZapLoggerEntryInflux(zapLoggerEntry),
+		WithEventLoggerEntryInflux(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, InfluxVersion2, exporter.Version)
+	assert.Equal(t, "http://localhost:8086/api/v2/write?bucket=ut-bucket&org=ut-org&precision=ms", exporter.writeURL)
+}
+
+func TestToLineProtocol(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_counter"}, []string{"path", "method"})
+	counter.WithLabelValues("/a b,c=d", "GET").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_gauge"})
+	gauge.Set(math.NaN())
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ut_histogram", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.1)
+	histogram.Observe(5)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(2)
+	registry.MustRegister(counter, gauge, histogram, summary)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	lines := toLineProtocol(families, time.Unix(100, 0))
+
+	// gauge with NaN would be skipped
+	assert.Equal(t, []string{
+		`ut_counter,method=GET,path=/a\ b\,c\=d value=3 100000`,
+		`ut_histogram count=2,sum=5.1,0.5=1,1=1 100000`,
+		`ut_summary count=1,sum=2,0.5=2 100000`,
+	}, lines)
+}
+
+func TestInfluxExporter_ExportNow(t *testing.T) {
+	receiver := newInfluxReceiver(t)
+	defer receiver.Close()
+
+	exporter, err := NewInfluxExporter(
+		WithURLInflux(receiver.URL),
+		WithBucketInflux("ut-org", "ut-bucket"),
+		WithBatchSizeInflux(2),
+		WithAuthInflux(&AuthConfig{Token: "ut-token"}),
+		WithHTTPClientConfigInflux(&HTTPClientConfig{Compression: CompressionGzip}),
+		WithZapLoggerEntryInflux(zapLoggerEntry),
+		WithEventLoggerEntryInflux(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_counter"}, []string{"id"})
+	counter.WithLabelValues("1").Inc()
+	counter.WithLabelValues("2").Inc()
+	counter.WithLabelValues("3").Inc()
+	registry.MustRegister(counter)
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	// three lines would be split into two batches
+	requests := receiver.getRequests()
+	assert.Len(t, requests, 2)
+	assert.Len(t, requests[0], 2)
+	assert.Len(t, requests[1], 1)
+	assert.True(t, strings.HasPrefix(requests[1][0], "ut_counter,id=3 value=1 "))
+
+	assert.Equal(t, "/api/v2/write?bucket=ut-bucket&org=ut-org&precision=ms", receiver.urls[0])
+	assert.Equal(t, "Token ut-token", receiver.headers[0].Get("Authorization"))
+	assert.Equal(t, "text/plain; charset=utf-8", receiver.headers[0].Get("Content-Type"))
+}
+
+func TestRegisterPromEntriesWithConfig_WithInflux(t *testing.T) {
+	bootFileWithInflux := `
+---
+prom:
+  enabled: true
+  influx:
+    enabled: true
+    url: "http://localhost:8086"
+    org: ut-org
+    bucket: ut-bucket
+    intervalMs: 2000
+    batchSize: 100
+    auth:
+      token: ut-token
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithInflux), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.Exporters, 1)
+	influx := entry.Exporters[0].(*InfluxExporter)
+	assert.Equal(t, "http://localhost:8086", influx.URL)
+	assert.Equal(t, InfluxVersion2, influx.Version)
+	assert.Equal(t, 2*time.Second, influx.IntervalMs)
+	assert.Equal(t, 100, influx.BatchSize)
+	assert.Equal(t, "ut-token", influx.Auth.Token)
+	assert.Contains(t, entry.String(), "http://localhost:8086")
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatQueryKey is the query parameter used to select exposition format, e.g. /metrics?format=json
+	FormatQueryKey = "format"
+	// FormatJSON selects JSON exposition format
+	FormatJSON = "json"
+	// ContentTypeJSON is the content type of JSON exposition format
+	ContentTypeJSON = "application/json"
+)
+
+// MetricFamilyJSON is the JSON rendering of a prometheus MetricFamily.
+//
+// The schema is stable, values are rendered as strings in the same way as prometheus HTTP API
+// does, so that NaN, +Inf and -Inf could be represented.
+//
+// 1: Name:    Fully qualified name of metric family
+// 2: Help:    Help string of metric family
+// 3: Type:    One of counter, gauge, summary, histogram and untyped
+// 4: Metrics: Metrics of family
+type MetricFamilyJSON struct {
+	Name    string        `json:"name" yaml:"name"`
+	Help    string        `json:"help" yaml:"help"`
+	Type    string        `json:"type" yaml:"type"`
+	Metrics []*MetricJSON `json:"metrics" yaml:"metrics"`
+}
+
+// MetricJSON is the JSON rendering of a single prometheus Metric.
+//
+// Value would be filled for counter, gauge and untyped metrics.
+// SampleCount, SampleSum and Buckets would be filled for histogram metrics.
+// SampleCount, SampleSum and Quantiles would be filled for summary metrics.
+type MetricJSON struct {
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Value       string            `json:"value,omitempty" yaml:"value,omitempty"`
+	SampleCount string            `json:"sampleCount,omitempty" yaml:"sampleCount,omitempty"`
+	SampleSum   string            `json:"sampleSum,omitempty" yaml:"sampleSum,omitempty"`
+	Buckets     []*BucketJSON     `json:"buckets,omitempty" yaml:"buckets,omitempty"`
+	Quantiles   []*QuantileJSON   `json:"quantiles,omitempty" yaml:"quantiles,omitempty"`
+	TimestampMs int64             `json:"timestampMs,omitempty" yaml:"timestampMs,omitempty"`
+}
+
+// BucketJSON is the JSON rendering of a histogram bucket
+type BucketJSON struct {
+	UpperBound      string `json:"upperBound" yaml:"upperBound"`
+	CumulativeCount string `json:"cumulativeCount" yaml:"cumulativeCount"`
+}
+
+// QuantileJSON is the JSON rendering of a summary quantile
+type QuantileJSON struct {
+	Quantile string `json:"quantile" yaml:"quantile"`
+	Value    string `json:"value" yaml:"value"`
+}
+
+// ToMetricFamiliesJSON converts gathered metric families into JSON rendering
+func ToMetricFamiliesJSON(families []*dto.MetricFamily) []*MetricFamilyJSON {
+	res := make([]*MetricFamilyJSON, 0, len(families))
+
+	for _, family := range families {
+		familyJSON := &MetricFamilyJSON{
+			Name:    family.GetName(),
+			Help:    family.GetHelp(),
+			Type:    strings.ToLower(family.GetType().String()),
+			Metrics: make([]*MetricJSON, 0, len(family.GetMetric())),
+		}
+
+		for _, metric := range family.GetMetric() {
+			familyJSON.Metrics = append(familyJSON.Metrics, toMetricJSON(family.GetType(), metric))
+		}
+
+		res = append(res, familyJSON)
+	}
+
+	return res
+}
+
+// Internal use only
+func toMetricJSON(metricType dto.MetricType, metric *dto.Metric) *MetricJSON {
+	res := &MetricJSON{
+		Labels:      make(map[string]string),
+		TimestampMs: metric.GetTimestampMs(),
+	}
+
+	for _, pair := range metric.GetLabel() {
+		res.Labels[pair.GetName()] = pair.GetValue()
+	}
+
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		res.Value = formatFloat(metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		res.Value = formatFloat(metric.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		res.Value = formatFloat(metric.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		res.SampleCount = strconv.FormatUint(summary.GetSampleCount(), 10)
+		res.SampleSum = formatFloat(summary.GetSampleSum())
+		res.Quantiles = make([]*QuantileJSON, 0, len(summary.GetQuantile()))
+		for _, quantile := range summary.GetQuantile() {
+			res.Quantiles = append(res.Quantiles, &QuantileJSON{
+				Quantile: formatFloat(quantile.GetQuantile()),
+				Value:    formatFloat(quantile.GetValue()),
+			})
+		}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		res.SampleCount = strconv.FormatUint(histogram.GetSampleCount(), 10)
+		res.SampleSum = formatFloat(histogram.GetSampleSum())
+		res.Buckets = make([]*BucketJSON, 0, len(histogram.GetBucket())+1)
+		for _, bucket := range histogram.GetBucket() {
+			res.Buckets = append(res.Buckets, &BucketJSON{
+				UpperBound:      formatFloat(bucket.GetUpperBound()),
+				CumulativeCount: strconv.FormatUint(bucket.GetCumulativeCount(), 10),
+			})
+		}
+		// prometheus does not export +Inf bucket explicitly, add it for completeness
+		if len(res.Buckets) < 1 || !math.IsInf(histogram.GetBucket()[len(histogram.GetBucket())-1].GetUpperBound(), 1) {
+			res.Buckets = append(res.Buckets, &BucketJSON{
+				UpperBound:      formatFloat(math.Inf(1)),
+				CumulativeCount: res.SampleCount,
+			})
+		}
+	}
+
+	return res
+}
+
+// Internal use only
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// newMetricsHandler returns a http.Handler which serves JSON exposition format if client asked for it
+// with either format=json query parameter or Accept header of application/json.
+// Otherwise, request would be passed to text handler.
+func newMetricsHandler(gatherer prometheus.Gatherer, textHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !acceptsJSON(request) {
+			textHandler.ServeHTTP(writer, request)
+			return
+		}
+
+		families, err := gatherer.Gather()
+		if err != nil && len(families) < 1 {
+			http.Error(writer, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		bytes, err := json.Marshal(ToMetricFamiliesJSON(families))
+		if err != nil {
+			http.Error(writer, "An error has occurred while encoding metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", ContentTypeJSON)
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(bytes)
+	})
+}
+
+// Internal use only
+func acceptsJSON(request *http.Request) bool {
+	if format := request.URL.Query().Get(FormatQueryKey); len(format) > 0 {
+		return strings.EqualFold(format, FormatJSON)
+	}
+
+	for _, accept := range strings.Split(request.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == ContentTypeJSON {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newJSONTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	set := NewMetricsSet("ut", "json", registry)
+
+	set.RegisterCounter("counter", "key")
+	set.GetCounterWithValues("counter", "value").Add(2)
+
+	set.RegisterHistogram("histogram", []float64{1, 5})
+	set.GetHistogramWithValues("histogram").Observe(3)
+
+	set.RegisterSummary("summary", map[float64]float64{0.5: 0.05})
+	set.GetSummaryWithValues("summary").Observe(1)
+
+	return registry
+}
+
+func TestToMetricFamiliesJSON_HappyCase(t *testing.T) {
+	families, err := newJSONTestRegistry().Gather()
+	assert.Nil(t, err)
+
+	res := ToMetricFamiliesJSON(families)
+	assert.Len(t, res, 3)
+
+	// families are sorted by name
+	counter := res[0]
+	assert.Equal(t, "ut_json_counter", counter.Name)
+	assert.Equal(t, "counter", counter.Type)
+	assert.Equal(t, "2", counter.Metrics[0].Value)
+	assert.Equal(t, "value", counter.Metrics[0].Labels["key"])
+
+	histogram := res[1]
+	assert.Equal(t, "histogram", histogram.Type)
+	assert.Equal(t, "1", histogram.Metrics[0].SampleCount)
+	assert.Equal(t, "3", histogram.Metrics[0].SampleSum)
+	assert.Len(t, histogram.Metrics[0].Buckets, 3)
+	assert.Equal(t, "0", histogram.Metrics[0].Buckets[0].CumulativeCount)
+	assert.Equal(t, "5", histogram.Metrics[0].Buckets[1].UpperBound)
+	assert.Equal(t, "1", histogram.Metrics[0].Buckets[1].CumulativeCount)
+	assert.Equal(t, "+Inf", histogram.Metrics[0].Buckets[2].UpperBound)
+
+	summary := res[2]
+	assert.Equal(t, "summary", summary.Type)
+	assert.Equal(t, "0.5", summary.Metrics[0].Quantiles[0].Quantile)
+	assert.Equal(t, "1", summary.Metrics[0].Quantiles[0].Value)
+}
+
+func TestNewMetricsHandler_WithQueryParameter(t *testing.T) {
+	registry := newJSONTestRegistry()
+	handler := newMetricsHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics?format=json", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, ContentTypeJSON, recorder.Header().Get("Content-Type"))
+
+	res := make([]*MetricFamilyJSON, 0)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Len(t, res, 3)
+}
+
+func TestNewMetricsHandler_WithAcceptHeader(t *testing.T) {
+	registry := newJSONTestRegistry()
+	handler := newMetricsHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept", "text/html;q=0.9, application/json")
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, ContentTypeJSON, recorder.Header().Get("Content-Type"))
+}
+
+func TestNewMetricsHandler_WithTextFormat(t *testing.T) {
+	registry := newJSONTestRegistry()
+	handler := newMetricsHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, recorder.Body.String(), "ut_json_counter{key=\"value\"} 2")
+}
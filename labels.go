@@ -0,0 +1,97 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"os"
+	"sort"
+)
+
+const (
+	// HostnameVariable would be expanded with os.Hostname() if environment variable was not defined
+	HostnameVariable = "HOSTNAME"
+)
+
+// expandLabels returns a copy of labels whose values were expanded with environment variables.
+// Both of $VAR and ${VAR} are supported, undefined variables would be replaced with empty string.
+// $HOSTNAME would be expanded with os.Hostname() if it was not defined in environment variables.
+func expandLabels(labels map[string]string) prometheus.Labels {
+	res := make(prometheus.Labels, len(labels))
+
+	for k, v := range labels {
+		res[k] = os.Expand(v, lookupVariable)
+	}
+
+	return res
+}
+
+// Internal use only
+func lookupVariable(name string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+
+	if name == HostnameVariable {
+		hostname, _ := os.Hostname()
+		return hostname
+	}
+
+	return ""
+}
+
+// labelsGatherer attaches labels to every gathered metric which does not contain them.
+// Metrics registered via wrapped registerer already contain them, the rest of them,
+// i.e. collectors registered into prometheus.DefaultRegisterer by other packages, would
+// be attached while gathering.
+type labelsGatherer struct {
+	gatherer prometheus.Gatherer
+	labels   prometheus.Labels
+}
+
+// newLabelsGatherer wraps gatherer with labels, gatherer itself would be returned if labels is empty
+func newLabelsGatherer(gatherer prometheus.Gatherer, labels prometheus.Labels) prometheus.Gatherer {
+	if len(labels) < 1 {
+		return gatherer
+	}
+
+	return &labelsGatherer{
+		gatherer: gatherer,
+		labels:   labels,
+	}
+}
+
+// Gather implements prometheus.Gatherer
+func (g *labelsGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			existing := make(map[string]bool, len(metric.GetLabel()))
+			for _, pair := range metric.GetLabel() {
+				existing[pair.GetName()] = true
+			}
+
+			appended := false
+			for k, v := range g.labels {
+				if !existing[k] {
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+					appended = true
+				}
+			}
+
+			if appended {
+				sort.Slice(metric.Label, func(i, j int) bool {
+					return metric.Label[i].GetName() < metric.Label[j].GetName()
+				})
+			}
+		}
+	}
+
+	return families, err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func labelsOf(t *testing.T, gatherer prometheus.Gatherer, name string) map[string]string {
+	families, err := gatherer.Gather()
+	assert.Nil(t, err)
+
+	for _, family := range families {
+		if family.GetName() == name {
+			res := make(map[string]string)
+			for _, pair := range family.GetMetric()[0].GetLabel() {
+				res[pair.GetName()] = pair.GetValue()
+			}
+			return res
+		}
+	}
+
+	return nil
+}
+
+func TestExpandLabels_HappyCase(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_REGION", "us-east-1"))
+	defer os.Unsetenv("RK_PROM_UT_REGION")
+
+	labels := expandLabels(map[string]string{
+		"region": "${RK_PROM_UT_REGION}",
+		"env":    "prod",
+	})
+
+	assert.Equal(t, "us-east-1", labels["region"])
+	assert.Equal(t, "prod", labels["env"])
+}
+
+func TestNewLabelsGatherer_WithEmptyLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Equal(t, registry, newLabelsGatherer(registry, nil))
+}
+
+func TestLabelsGatherer_Gather(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	set := NewMetricsSet("ut", "labels", registry)
+	assert.Nil(t, set.RegisterCounter("counter", "env"))
+	set.GetCounterWithValues("counter", "dev").Inc()
+
+	gatherer := newLabelsGatherer(registry, prometheus.Labels{"env": "prod", "a_service": "ut", "zone": "a"})
+	labels := labelsOf(t, gatherer, "ut_labels_counter")
+
+	// existing label should not be overridden
+	assert.Equal(t, "dev", labels["env"])
+	assert.Equal(t, "ut", labels["a_service"])
+	assert.Equal(t, "a", labels["zone"])
+
+	families, _ := gatherer.Gather()
+	pairs := families[0].GetMetric()[0].GetLabel()
+	assert.Equal(t, "a_service", pairs[0].GetName())
+}
+
+func TestRegisterPromEntry_WithGlobalLabels(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_POD", "pod-1"))
+	defer os.Unsetenv("RK_PROM_UT_POD")
+
+	promPath := NewPromPath("metrics/debug", promhttp.HandlerOpts{}, nil)
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithPromPath(promPath),
+		WithGlobalLabels(map[string]string{"service": "ut", "pod": "$RK_PROM_UT_POD"}))
+
+	set := NewMetricsSet("ut", "global", entry.Registerer)
+	assert.Nil(t, set.RegisterCounter("counter"))
+	set.GetCounterWithValues("counter").Inc()
+
+	// collector registered into registry directly
+	assert.Nil(t, entry.Registry.Register(NewAppInfoCollector(nil)))
+
+	labels := labelsOf(t, entry.Gatherer, "ut_global_counter")
+	assert.Equal(t, "ut", labels["service"])
+	assert.Equal(t, "pod-1", labels["pod"])
+
+	labels = labelsOf(t, entry.Gatherer, AppInfoMetricsName)
+	assert.Equal(t, "pod-1", labels["pod"])
+
+	debugSet := NewMetricsSet("ut", "debug", promPath.Registerer)
+	assert.Nil(t, debugSet.RegisterGauge("gauge"))
+	debugSet.GetGaugeWithValues("gauge").Set(1)
+	labels = labelsOf(t, promPath.Gatherer, "ut_debug_gauge")
+	assert.Equal(t, "ut", labels["service"])
+}
+
+func TestRegisterPromEntriesWithConfig_WithGlobalLabels(t *testing.T) {
+	bootFileWithLabels := `
+---
+prom:
+  enabled: true
+  labels:
+    service: ut
+    env: ${RK_PROM_UT_ENV}
+`
+	assert.Nil(t, os.Setenv("RK_PROM_UT_ENV", "prod"))
+	defer os.Unsetenv("RK_PROM_UT_ENV")
+
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithLabels), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Equal(t, prometheus.Labels{"service": "ut", "env": "prod"}, entry.GlobalLabels)
+}
@@ -0,0 +1,410 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PushPolicyAll requires every target to succeed
+	PushPolicyAll = "all"
+	// PushPolicyAny requires at least one target to succeed
+	PushPolicyAny = "any"
+)
+
+// MultiPushGatewayPusher pushes metrics to several pushGateways concurrently.
+// thread safe
+//
+// Every target is a PushGatewayPusher with its own remote address, auth, TLS, grouping, mode and retry settings,
+// however, periodic job of targets would not be started, MultiPushGatewayPusher drives all of them in one job.
+//
+// 1: targets:    pushGateway pushers of every remote pushGateway
+// 2: policy:     one of all and any, decides whether a cycle succeeded or not
+// 3: intervalMS: periodic job interval in milliseconds
+// 4: jitter:     fraction of interval which would be randomized in every cycle
+// 5: oneShot:    push once while stopping prom entry instead of pushing periodically
+// 6: isRunning:  a boolean flag for validating status of periodic job
+type MultiPushGatewayPusher struct {
+	ZapLoggerEntry   *rkentry.ZapLoggerEntry   `json:"zapLoggerEntry" yaml:"zapLoggerEntry"`
+	EventLoggerEntry *rkentry.EventLoggerEntry `json:"eventLoggerEntry" yaml:"eventLoggerEntry"`
+	Targets          []*PushGatewayPusher      `json:"targets" yaml:"targets"`
+	Policy           string                    `json:"policy" yaml:"policy"`
+	IntervalMs       time.Duration             `json:"intervalMs" yaml:"intervalMs"`
+	Jitter           float64                   `json:"jitter" yaml:"jitter"`
+	OneShot          bool                      `json:"oneShot" yaml:"oneShot"`
+	Running          *atomic.Bool              `json:"running" yaml:"running"`
+	lock             *sync.Mutex               `json:"-" yaml:"-"`
+	job              *periodicJob              `json:"-" yaml:"-"`
+}
+
+// MultiPushGatewayPusherOption is used while initializing multi pushGateway pusher via code
+type MultiPushGatewayPusherOption func(*MultiPushGatewayPusher)
+
+// WithTargetsMultiPusher provides pushGateway pushers of every remote pushGateway
+func WithTargetsMultiPusher(targets ...*PushGatewayPusher) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.Targets = append(pusher.Targets, targets...)
+	}
+}
+
+// WithPolicyMultiPusher provides policy, one of all and any, all by default.
+//
+// all: A cycle succeeded only if every target succeeded.
+// any: A cycle succeeded if at least one target succeeded.
+func WithPolicyMultiPusher(policy string) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.Policy = policy
+	}
+}
+
+// WithIntervalMSMultiPusher provides interval in milliseconds
+func WithIntervalMSMultiPusher(intervalMs time.Duration) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.IntervalMs = intervalMs
+	}
+}
+
+// WithJitterMultiPusher randomizes interval of every cycle, jitter should be in range of (0, 1]
+func WithJitterMultiPusher(jitter float64) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.Jitter = jitter
+	}
+}
+
+// WithOneShotMultiPusher makes prom entry push metrics once while interrupting instead of starting periodic job
+func WithOneShotMultiPusher(oneShot bool) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.OneShot = oneShot
+	}
+}
+
+// WithZapLoggerEntryMultiPusher provides ZapLoggerEntry
+func WithZapLoggerEntryMultiPusher(zapLoggerEntry *rkentry.ZapLoggerEntry) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.ZapLoggerEntry = zapLoggerEntry
+	}
+}
+
+// WithEventLoggerEntryMultiPusher provides EventLoggerEntry
+func WithEventLoggerEntryMultiPusher(eventLoggerEntry *rkentry.EventLoggerEntry) MultiPushGatewayPusherOption {
+	return func(pusher *MultiPushGatewayPusher) {
+		pusher.EventLoggerEntry = eventLoggerEntry
+	}
+}
+
+// NewMultiPushGatewayPusher creates a new multi pushGateway pusher with targets
+// 1: targets:    should not be empty or contain nil target, so that pushes would not succeed with fewer targets than expected
+// 2: policy:     should be one of all and any
+// 3: intervalMS: should be a positive integer
+func NewMultiPushGatewayPusher(opts ...MultiPushGatewayPusherOption) (*MultiPushGatewayPusher, error) {
+	pg := &MultiPushGatewayPusher{
+		ZapLoggerEntry:   rkentry.GlobalAppCtx.GetZapLoggerEntryDefault(),
+		EventLoggerEntry: rkentry.GlobalAppCtx.GetEventLoggerEntryDefault(),
+		Targets:          make([]*PushGatewayPusher, 0),
+		Policy:           PushPolicyAll,
+		IntervalMs:       1 * time.Second,
+		lock:             &sync.Mutex{},
+		job:              &periodicJob{},
+		Running:          atomic.NewBool(false),
+	}
+
+	for i := range opts {
+		opts[i](pg)
+	}
+
+	if len(pg.Targets) < 1 {
+		return nil, errors.New("empty targets")
+	}
+
+	for i, target := range pg.Targets {
+		if target == nil {
+			return nil, errors.New(fmt.Sprintf("nil target at index %d", i))
+		}
+	}
+
+	if pg.IntervalMs < 1 {
+		return nil, errors.New("invalid intervalMs")
+	}
+
+	if pg.Jitter < 0 || pg.Jitter > 1 {
+		return nil, errors.New(fmt.Sprintf("invalid jitter:%v", pg.Jitter))
+	}
+
+	pg.Policy = strings.ToLower(strings.TrimSpace(pg.Policy))
+	if len(pg.Policy) < 1 {
+		pg.Policy = PushPolicyAll
+	}
+
+	if pg.Policy != PushPolicyAll && pg.Policy != PushPolicyAny {
+		return nil, errors.New(fmt.Sprintf("invalid push policy:%s", pg.Policy))
+	}
+
+	if pg.ZapLoggerEntry == nil {
+		pg.ZapLoggerEntry = rkentry.GlobalAppCtx.GetZapLoggerEntryDefault()
+	}
+
+	if pg.EventLoggerEntry == nil {
+		pg.EventLoggerEntry = rkentry.GlobalAppCtx.GetEventLoggerEntryDefault()
+	}
+
+	return pg, nil
+}
+
+// Start starts a periodic job, metrics would be pushed to every target immediately and then every interval
+func (pub *MultiPushGatewayPusher) Start() {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+
+	if !pub.job.start(pub.nextInterval, pub.publish) {
+		pub.ZapLoggerEntry.GetLogger().Info("multi pushGateway publisher already started",
+			zap.Strings("remoteAddresses", pub.remoteAddresses()))
+		return
+	}
+
+	pub.Running.Store(true)
+
+	pub.ZapLoggerEntry.GetLogger().Info("starting multi pushGateway publisher",
+		zap.Strings("remoteAddresses", pub.remoteAddresses()))
+}
+
+// Internal use only
+func (pub *MultiPushGatewayPusher) nextInterval() time.Duration {
+	return jitter(pub.IntervalMs, pub.Jitter)
+}
+
+// Internal use only
+func (pub *MultiPushGatewayPusher) publish(ctx context.Context) {
+	event := pub.EventLoggerEntry.GetEventHelper().Start("publish")
+	event.AddPayloads(
+		zap.Strings("remoteAddrs", pub.remoteAddresses()),
+		zap.String("policy", pub.Policy),
+		zap.Duration("intervalMs", pub.IntervalMs))
+
+	// retries of every target would not overlap next cycle
+	if err := pub.pushAll(ctx, pub.IntervalMs); err != nil {
+		pub.ZapLoggerEntry.GetLogger().Warn("failed to push metrics to PushGateways",
+			zap.Strings("remoteAddresses", pub.remoteAddresses()),
+			zap.Error(err))
+		pub.EventLoggerEntry.GetEventHelper().FinishWithError(event, err)
+	} else {
+		pub.EventLoggerEntry.GetEventHelper().Finish(event)
+	}
+}
+
+// PushNow pushes metrics to every target concurrently and synchronously with retries of each target.
+// Error would be returned based on policy, retries would not be bounded by interval, use deadline of ctx instead.
+func (pub *MultiPushGatewayPusher) PushNow(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return pub.pushAll(ctx, 0)
+}
+
+// pushAll pushes metrics to every target concurrently, retries of every target would be bounded by bound
+func (pub *MultiPushGatewayPusher) pushAll(ctx context.Context, bound time.Duration) error {
+	errs := make([]error, len(pub.Targets))
+
+	wait := sync.WaitGroup{}
+	for i := range pub.Targets {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			target := pub.Targets[i]
+			if err := target.pushCycle(ctx, bound); err != nil {
+				errs[i] = errors.Wrap(err, target.target())
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	failed := make([]string, 0)
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) < 1 || (pub.Policy == PushPolicyAny && len(failed) < len(pub.Targets)) {
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("failed to push to %d of %d targets: %s",
+		len(failed), len(pub.Targets), strings.Join(failed, "; ")))
+}
+
+// Internal use only
+func (pub *MultiPushGatewayPusher) isOneShot() bool {
+	return pub.OneShot
+}
+
+// IsRunning validate whether periodic job is running or not
+func (pub *MultiPushGatewayPusher) IsRunning() bool {
+	return pub.Running.Load()
+}
+
+// Stop stops periodic job and blocks until in-flight pushes are canceled and the goroutine exits.
+// Groups would be deleted from targets whose DeleteOnStop is true.
+func (pub *MultiPushGatewayPusher) Stop() {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+
+	if !pub.job.stop() {
+		return
+	}
+
+	pub.Running.Store(false)
+
+	for _, target := range pub.Targets {
+		settings := target.settings()
+		if !settings.deleteOnStop {
+			continue
+		}
+
+		if err := target.delete(context.Background()); err != nil {
+			pub.ZapLoggerEntry.GetLogger().Warn("failed to delete metrics from PushGateway",
+				zap.String("remoteAddress", settings.remoteAddress),
+				zap.String("jobName", settings.jobName),
+				zap.Error(err))
+		}
+	}
+}
+
+// ExportNow implements Exporter, the same as PushNow
+func (pub *MultiPushGatewayPusher) ExportNow(ctx context.Context) error {
+	return pub.PushNow(ctx)
+}
+
+// Health returns push health combined from every target.
+// Times were the latest ones of targets, counters and spool backlog were summed up,
+// consecutive failures was the largest one and last error was the first one reported by targets.
+func (pub *MultiPushGatewayPusher) Health() HealthStatus {
+	res := HealthStatus{}
+
+	for _, target := range pub.Targets {
+		status := target.Health()
+
+		if status.LastPushTime.After(res.LastPushTime) {
+			res.LastPushTime = status.LastPushTime
+		}
+
+		if status.LastSuccessTime.After(res.LastSuccessTime) {
+			res.LastSuccessTime = status.LastSuccessTime
+		}
+
+		if len(res.LastError) < 1 {
+			res.LastError = status.LastError
+		}
+
+		if status.ConsecutiveFailures > res.ConsecutiveFailures {
+			res.ConsecutiveFailures = status.ConsecutiveFailures
+		}
+
+		res.TotalPushes += status.TotalPushes
+		res.TotalFailures += status.TotalFailures
+		res.TotalBytes += status.TotalBytes
+		res.SpoolBacklog += status.SpoolBacklog
+	}
+
+	return res
+}
+
+// TargetHealth returns snapshots of push health of every target keyed by remote address
+func (pub *MultiPushGatewayPusher) TargetHealth() map[string]HealthStatus {
+	res := make(map[string]HealthStatus, len(pub.Targets))
+
+	for _, target := range pub.Targets {
+		res[target.target()] = target.Health()
+	}
+
+	return res
+}
+
+// HealthCollector returns a collector which exposes push health of every target as metrics,
+// metrics of targets were distinguished by pusher_address label
+func (pub *MultiPushGatewayPusher) HealthCollector() prometheus.Collector {
+	res := make(collectors, 0, len(pub.Targets))
+
+	for _, target := range pub.Targets {
+		res = append(res, target.HealthCollector())
+	}
+
+	return res
+}
+
+// registerHealth registers health metrics of every target into registerer, the first error would be returned
+func (pub *MultiPushGatewayPusher) registerHealth(registerer prometheus.Registerer) error {
+	var err error
+	for _, target := range pub.Targets {
+		if innerErr := target.registerHealth(registerer); innerErr != nil && err == nil {
+			err = innerErr
+		}
+	}
+
+	return err
+}
+
+// SetGatherer sets gatherer of every target
+func (pub *MultiPushGatewayPusher) SetGatherer(gatherer prometheus.Gatherer) {
+	for _, target := range pub.Targets {
+		target.SetGatherer(gatherer)
+	}
+}
+
+// String returns string value of MultiPushGatewayPusher
+func (pub *MultiPushGatewayPusher) String() string {
+	bytes, err := json.Marshal(pub)
+	if err != nil {
+		// failed to marshal, just return empty string
+		return "{}"
+	}
+
+	return string(bytes)
+}
+
+// Internal use only
+func (pub *MultiPushGatewayPusher) target() string {
+	return strings.Join(pub.remoteAddresses(), ",")
+}
+
+// Internal use only
+func (pub *MultiPushGatewayPusher) remoteAddresses() []string {
+	res := make([]string, 0, len(pub.Targets))
+
+	for _, target := range pub.Targets {
+		res = append(res, target.target())
+	}
+
+	return res
+}
+
+// collectors combines several collectors into one
+type collectors []prometheus.Collector
+
+// Describe implements prometheus.Collector
+func (c collectors) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c collectors) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTargetPusher(t *testing.T, remoteAddress string, opts ...PushGatewayPusherOption) *PushGatewayPusher {
+	opts = append([]PushGatewayPusherOption{
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(remoteAddress),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry),
+	}, opts...)
+
+	pusher, err := NewPushGatewayPusher(opts...)
+	assert.Nil(t, err)
+
+	return pusher
+}
+
+func TestNewMultiPushGatewayPusher_WithEmptyTargets(t *testing.T) {
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(nil),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestNewMultiPushGatewayPusher_WithNilTarget(t *testing.T) {
+	// invalid target which was constructed as nil would not be dropped silently
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(newTargetPusher(t, remoteAddr), nil),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.EqualError(t, err, "nil target at index 1")
+}
+
+func TestNewMultiPushGatewayPusher_WithInvalidPolicy(t *testing.T) {
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(newTargetPusher(t, remoteAddr)),
+		WithPolicyMultiPusher("invalid"),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestNewMultiPushGatewayPusher_WithInvalidInterval(t *testing.T) {
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(newTargetPusher(t, remoteAddr)),
+		WithIntervalMSMultiPusher(-1),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestNewMultiPushGatewayPusher_HappyCase(t *testing.T) {
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(newTargetPusher(t, remoteAddr)),
+		WithPolicyMultiPusher(" ANY "),
+		WithZapLoggerEntryMultiPusher(nil),
+		WithEventLoggerEntryMultiPusher(nil))
+
+	assert.Nil(t, err)
+	assert.Equal(t, PushPolicyAny, pusher.Policy)
+	assert.Equal(t, time.Second, pusher.IntervalMs)
+	assert.NotNil(t, pusher.ZapLoggerEntry)
+	assert.NotNil(t, pusher.EventLoggerEntry)
+	assert.False(t, pusher.IsRunning())
+}
+
+func TestMultiPushGatewayPusher_PushNow_WithPolicy(t *testing.T) {
+	healthy := newGatewayMock()
+	defer healthy.Close()
+	broken := newGatewayMock()
+	defer broken.Close()
+	broken.status.Store(http.StatusServiceUnavailable)
+
+	healthyTarget := newTargetPusher(t, healthy.URL, WithBasicAuthPusher("user:pass"))
+	brokenTarget := newTargetPusher(t, broken.URL)
+
+	// all
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(healthyTarget, brokenTarget),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.NotNil(t, pusher.PushNow(context.Background()))
+	assert.Len(t, healthy.getRequests(), 1)
+	assert.Len(t, broken.getRequests(), 1)
+
+	// targets have independent auth settings
+	assert.NotEmpty(t, healthy.getRequests()[0].header.Get("Authorization"))
+	assert.Empty(t, broken.getRequests()[0].header.Get("Authorization"))
+
+	// any
+	pusher.Policy = PushPolicyAny
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	// per target health
+	health := pusher.TargetHealth()
+	assert.Equal(t, int64(0), health[healthy.URL].TotalFailures)
+	assert.Equal(t, int64(2), health[broken.URL].ConsecutiveFailures)
+
+	// combined health
+	status := pusher.Health()
+	assert.Equal(t, int64(4), status.TotalPushes)
+	assert.Equal(t, int64(2), status.TotalFailures)
+	assert.Equal(t, int64(2), status.ConsecutiveFailures)
+	assert.NotEmpty(t, status.LastError)
+	assert.Equal(t, health[healthy.URL].LastSuccessTime, status.LastSuccessTime)
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(pusher.HealthCollector()))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		assert.Len(t, family.GetMetric(), 2)
+	}
+
+	// any with all of targets failed
+	healthy.status.Store(http.StatusServiceUnavailable)
+	assert.NotNil(t, pusher.PushNow(context.Background()))
+}
+
+func TestMultiPushGatewayPusher_TargetHealth_WhileReconfiguring(t *testing.T) {
+	oldGateway := newGatewayMock()
+	defer oldGateway.Close()
+	newGateway := newGatewayMock()
+	defer newGateway.Close()
+
+	target := newTargetPusher(t, oldGateway.URL)
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(target),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, target.Reconfigure(WithRemoteAddressPusher(newGateway.URL)))
+	}()
+
+	// remote address of target would be read under lock of target
+	pusher.TargetHealth()
+	pusher.remoteAddresses()
+	<-done
+
+	assert.Contains(t, pusher.TargetHealth(), newGateway.URL)
+	assert.Equal(t, []string{newGateway.URL}, pusher.remoteAddresses())
+}
+
+func TestMultiPushGatewayPusher_StartAndStop(t *testing.T) {
+	first := newGatewayMock()
+	defer first.Close()
+	second := newGatewayMock()
+	defer second.Close()
+
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(
+			newTargetPusher(t, first.URL, WithDeleteOnStopPusher(true)),
+			newTargetPusher(t, second.URL)),
+		WithIntervalMSMultiPusher(time.Hour),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	pusher.Start()
+	pusher.Start()
+	assert.True(t, pusher.IsRunning())
+
+	// wait for the first cycle
+	time.Sleep(100 * time.Millisecond)
+
+	pusher.Stop()
+	pusher.Stop()
+	assert.False(t, pusher.IsRunning())
+
+	// targets were not started
+	for _, target := range pusher.Targets {
+		assert.False(t, target.IsRunning())
+	}
+
+	assert.Len(t, first.getRequests(), 2)
+	assert.Equal(t, http.MethodDelete, first.getRequests()[1].method)
+	assert.Len(t, second.getRequests(), 1)
+}
+
+func TestRegisterPromEntriesWithConfig_WithTargets(t *testing.T) {
+	bootFileWithTargets := `
+---
+prom:
+  enabled: true
+  pusher:
+    enabled: true
+    intervalMs: 2000
+    jobName: rk-job
+    policy: any
+    format: text
+    grouping:
+      instance: ut
+    targets:
+      - remoteAddress: "localhost:9091"
+        basicAuth: "user:pass"
+      - remoteAddress: "localhost:9092"
+        jobName: other-job
+        format: protobuf
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithTargets), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	// single pusher would not be created along with multi pusher
+	assert.Len(t, entry.Exporters, 1)
+	multiPusher := entry.Exporters[0].(*MultiPushGatewayPusher)
+	assert.Equal(t, PushPolicyAny, multiPusher.Policy)
+	assert.Equal(t, 2*time.Second, multiPusher.IntervalMs)
+	assert.Len(t, multiPusher.Targets, 2)
+
+	first, second := multiPusher.Targets[0], multiPusher.Targets[1]
+	assert.Equal(t, "localhost:9091", first.RemoteAddress)
+	assert.Equal(t, "rk-job", first.JobName)
+	assert.Equal(t, "user:pass", first.Credential)
+	assert.Equal(t, map[string]string{"instance": "ut"}, first.Grouping)
+	assert.Equal(t, PushFormatText, first.Format)
+	assert.Equal(t, "localhost:9092", second.RemoteAddress)
+	assert.Equal(t, "other-job", second.JobName)
+	assert.Equal(t, PushFormatProtobuf, second.Format)
+	assert.Empty(t, second.Credential)
+}
+
+func TestNewMultiPusherFromConfig_WithInvalidTarget(t *testing.T) {
+	config := &BootConfigPusher{
+		Enabled:    true,
+		IntervalMs: 1000,
+		JobName:    "ut-job",
+		Targets: []BootConfigPusher{
+			{RemoteAddress: "localhost:9091"},
+			{RemoteAddress: "localhost:9092", Mode: "invalid"},
+		},
+	}
+
+	// the whole pusher would be rejected instead of pushing to fewer targets than configured
+	pusher, err := newMultiPusherFromConfig(config, zapLoggerEntry, eventLoggerEntry)
+	assert.Nil(t, pusher)
+	assert.EqualError(t, err, "invalid target:localhost:9092: invalid push mode:invalid")
+}
@@ -0,0 +1,607 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// OTLPMetricsPrefix is prefix of health metrics of OTLP exporter
+	OTLPMetricsPrefix = "rk_otlp"
+	// OTLPScopeName is name of instrumentation scope attached to every exported metric
+	OTLPScopeName = "github.com/rookie-ninja/rk-prom"
+	// OTLPTemporalityCumulative exports counters and histograms accumulated since exporter started
+	OTLPTemporalityCumulative = "cumulative"
+	// OTLPTemporalityDelta exports counters and histograms accumulated since the last successful export
+	OTLPTemporalityDelta = "delta"
+
+	otlpServiceNameKey    = "service.name"
+	otlpServiceVersionKey = "service.version"
+
+	// aggregation temporality of OTLP
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+// BootConfigOTLP is OTLP exporter config of prom entry.
+//
+// 1: Enabled: Enable OTLP exporter.
+// 2: Endpoint: OTLP/HTTP metrics endpoint, i.e. http://localhost:4318/v1/metrics
+// 3: IntervalMs: Interval of gathering and exporting metrics in milliseconds.
+// 4: ResourceAttributes: Attributes of resource as list of key and value since keys like service.name contain dots, values could be expanded from environment variables.
+// 5: Jitter: Fraction of interval which would be randomized in every cycle.
+// 6: Retry: Retry failed requests with exponential backoff in every cycle.
+// 7: Auth: Basic auth, bearer token and extra headers.
+// 8: TLS: CA bundle, client certificate, server name, minimum version and insecureSkipVerify.
+// 9: HTTPClient: Timeout, proxy, keep-alive and compression of http client.
+// 10: Temporality: One of cumulative and delta, cumulative by default.
+type BootConfigOTLP struct {
+	Enabled            bool                  `yaml:"enabled" json:"enabled"`
+	Endpoint           string                `yaml:"endpoint" json:"endpoint"`
+	IntervalMs         int64                 `yaml:"intervalMs" json:"intervalMs"`
+	ResourceAttributes []BootConfigAttribute `yaml:"resourceAttributes" json:"resourceAttributes"`
+	Jitter             float64               `yaml:"jitter" json:"jitter"`
+	Retry              BootConfigRetry       `yaml:"retry" json:"retry"`
+	Auth               AuthConfig            `yaml:"auth" json:"auth"`
+	TLS                TLSConfig             `yaml:"tls" json:"tls"`
+	HTTPClient         HTTPClientConfig      `yaml:"httpClient" json:"httpClient"`
+	Temporality        string                `yaml:"temporality" json:"temporality"`
+}
+
+// BootConfigAttribute is a resource attribute of OTLP exporter.
+type BootConfigAttribute struct {
+	Key   string `yaml:"key" json:"key"`
+	Value string `yaml:"value" json:"value"`
+}
+
+// OTLPExporter gathers metrics periodically, converts them into OTLP metrics and exports them
+// to OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+// thread safe
+//
+// 1: endpoint:           OTLP/HTTP metrics endpoint
+// 2: resourceAttributes: attributes of resource, service.name and service.version were filled from AppInfoEntry if missing
+// 3: auth:               basic auth, bearer token and extra headers attached to every request
+// 4: tls:                CA bundle, client certificate, server name and minimum version of TLS
+// 5: httpClient:         timeout, proxy, keep-alive and compression of http client
+// 6: temporality:        cumulative or delta, deltas would be calculated against the last successful export
+type OTLPExporter struct {
+	periodicExporter
+	Endpoint           string            `json:"endpoint" yaml:"endpoint"`
+	ResourceAttributes map[string]string `json:"resourceAttributes" yaml:"resourceAttributes"`
+	Auth               *AuthConfig       `json:"auth" yaml:"auth"`
+	TLS                *TLSConfig        `json:"tls" yaml:"tls"`
+	HTTPClient         *HTTPClientConfig `json:"httpClient" yaml:"httpClient"`
+	Temporality        string            `json:"temporality" yaml:"temporality"`
+	startTime          time.Time         `json:"-" yaml:"-"`
+	client             *http.Client      `json:"-" yaml:"-"`
+	roundTripper       http.RoundTripper `json:"-" yaml:"-"`
+}
+
+// OTLPExporterOption is used while initializing OTLP exporter via code
+type OTLPExporterOption func(*OTLPExporter)
+
+// WithEndpointOTLP provides OTLP/HTTP metrics endpoint
+func WithEndpointOTLP(endpoint string) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.Endpoint = endpoint
+	}
+}
+
+// WithIntervalMSOTLP provides interval in milliseconds
+func WithIntervalMSOTLP(intervalMs time.Duration) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.IntervalMs = intervalMs
+	}
+}
+
+// WithResourceAttributesOTLP provides attributes of resource,
+// values could be expanded from environment variables with form of $VAR or ${VAR}
+func WithResourceAttributesOTLP(attributes map[string]string) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		if exporter.ResourceAttributes == nil {
+			exporter.ResourceAttributes = make(map[string]string)
+		}
+
+		for k, v := range attributes {
+			exporter.ResourceAttributes[k] = v
+		}
+	}
+}
+
+// WithJitterOTLP randomizes interval of every cycle, jitter should be in range of (0, 1]
+func WithJitterOTLP(jitter float64) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.Jitter = jitter
+	}
+}
+
+// WithRetryOTLP retries failed requests with exponential backoff and full jitter in every cycle,
+// requests rejected with 4xx status code except 429 would not be retried
+func WithRetryOTLP(maxRetries int, initialBackoff, maxBackoff, maxElapsed time.Duration) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.retrier = newRetrier(maxRetries, initialBackoff, maxBackoff, maxElapsed)
+	}
+}
+
+// WithAuthOTLP provides auth of OTLP endpoint
+func WithAuthOTLP(auth *AuthConfig) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.Auth = auth
+	}
+}
+
+// WithTLSOTLP provides TLS config of OTLP endpoint
+func WithTLSOTLP(config *TLSConfig) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.TLS = config
+	}
+}
+
+// WithHTTPClientConfigOTLP provides timeout, proxy, keep-alive and compression of http client
+func WithHTTPClientConfigOTLP(config *HTTPClientConfig) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.HTTPClient = config
+	}
+}
+
+// WithHTTPClientOTLP provides a custom http client which would be used as it is, auth would still be attached
+func WithHTTPClientOTLP(client *http.Client) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.client = client
+	}
+}
+
+// WithRoundTripperOTLP provides a custom round tripper, auth would still be attached
+func WithRoundTripperOTLP(roundTripper http.RoundTripper) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.roundTripper = roundTripper
+	}
+}
+
+// WithTemporalityOTLP provides aggregation temporality of counters and histograms, one of cumulative and delta.
+// Summaries would always be exported as cumulative since OTLP summaries do not support delta temporality.
+func WithTemporalityOTLP(temporality string) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.Temporality = temporality
+	}
+}
+
+// WithGathererOTLP provides gatherer, prometheus.DefaultGatherer would be used by default
+func WithGathererOTLP(gatherer prometheus.Gatherer) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.gatherer = gatherer
+	}
+}
+
+// WithZapLoggerEntryOTLP provides ZapLoggerEntry
+func WithZapLoggerEntryOTLP(zapLoggerEntry *rkentry.ZapLoggerEntry) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.ZapLoggerEntry = zapLoggerEntry
+	}
+}
+
+// WithEventLoggerEntryOTLP provides EventLoggerEntry
+func WithEventLoggerEntryOTLP(eventLoggerEntry *rkentry.EventLoggerEntry) OTLPExporterOption {
+	return func(exporter *OTLPExporter) {
+		exporter.EventLoggerEntry = eventLoggerEntry
+	}
+}
+
+// NewOTLPExporter creates a new OTLP exporter
+// 1: endpoint:    should be a non empty url
+// 2: temporality: should be one of cumulative and delta, cumulative would be used if empty
+func NewOTLPExporter(opts ...OTLPExporterOption) (*OTLPExporter, error) {
+	exporter := &OTLPExporter{
+		periodicExporter: newPeriodicExporter(),
+		Temporality:      OTLPTemporalityCumulative,
+		startTime:        time.Now(),
+	}
+
+	for i := range opts {
+		opts[i](exporter)
+	}
+
+	if len(exporter.Endpoint) < 1 {
+		return nil, errors.New("empty OTLP endpoint")
+	}
+
+	if len(exporter.Temporality) < 1 {
+		exporter.Temporality = OTLPTemporalityCumulative
+	}
+
+	switch exporter.Temporality {
+	case OTLPTemporalityCumulative:
+	case OTLPTemporalityDelta:
+		exporter.delta = NewDeltaCalculator()
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid temporality:%s", exporter.Temporality))
+	}
+
+	exporter.ResourceAttributes = expandLabels(exporter.ResourceAttributes)
+	if appInfo := rkentry.GlobalAppCtx.GetAppInfoEntry(); appInfo != nil {
+		if len(exporter.ResourceAttributes[otlpServiceNameKey]) < 1 {
+			exporter.ResourceAttributes[otlpServiceNameKey] = appInfo.AppName
+		}
+
+		if len(exporter.ResourceAttributes[otlpServiceVersionKey]) < 1 {
+			exporter.ResourceAttributes[otlpServiceVersionKey] = appInfo.Version
+		}
+	}
+
+	clientOpts := &httpClientOptions{
+		config:       exporter.HTTPClient,
+		tls:          exporter.TLS,
+		auth:         exporter.Auth,
+		client:       exporter.client,
+		roundTripper: exporter.roundTripper,
+	}
+
+	client, err := clientOpts.newClient()
+	if err != nil {
+		return nil, err
+	}
+	exporter.client = client
+
+	if err := exporter.init("OTLP exporter", exporter.Endpoint, exporter, OTLPMetricsPrefix, prometheus.Labels{
+		"otlp_endpoint": exporter.Endpoint,
+	}); err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// encode metrics into a JSON encoded request, body would be compressed if compression of http client is gzip.
+// Counters and histograms would be deltas against the last successful export in delta temporality.
+func (exporter *OTLPExporter) encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error) {
+	req := toOTLPRequest(families, exporter.ResourceAttributes, exporter.startTime, now, otlpTemporalityCumulative)
+	if exporter.delta != nil {
+		deltas := exporter.delta.Delta(families)
+		for i := range families {
+			if families[i].GetType() == dto.MetricType_SUMMARY {
+				deltas[i] = families[i]
+			}
+		}
+
+		start := exporter.startTime
+		if last := exporter.delta.LastCommit(); !last.IsZero() {
+			start = last
+		}
+		req = toOTLPRequest(deltas, exporter.ResourceAttributes, start, now, otlpTemporalityDelta)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter.HTTPClient != nil && exporter.HTTPClient.Compression == CompressionGzip {
+		if body, err = gzipBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	return []*exportPayload{{body: body, families: families}}, nil
+}
+
+// send a request to OTLP endpoint
+func (exporter *OTLPExporter) send(ctx context.Context, payload []byte) error {
+	header := http.Header{
+		"Content-Type": {"application/json"},
+		"User-Agent":   {"rk-prom"},
+	}
+
+	if exporter.HTTPClient != nil && exporter.HTTPClient.Compression == CompressionGzip {
+		header.Set("Content-Encoding", CompressionGzip)
+	}
+
+	return sendRequest(ctx, exporter.client, http.MethodPost, exporter.Endpoint, payload, header)
+}
+
+// String returns string value of OTLPExporter
+func (exporter *OTLPExporter) String() string {
+	bytes, err := json.Marshal(exporter)
+	if err != nil {
+		// failed to marshal, just return empty string
+		return "{}"
+	}
+
+	return string(bytes)
+}
+
+// OTLP/HTTP JSON encoding of ExportMetricsServiceRequest, see opentelemetry-proto.
+// 64 bits integers were encoded as strings as protobuf JSON mapping requires.
+type otlpRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     *otlpResource       `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   *otlpScope    `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpKeyValue struct {
+	Key   string        `json:"key"`
+	Value *otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []*otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []*otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                    `json:"aggregationTemporality"`
+	IsMonotonic            bool                   `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []*otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                       `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []*otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []*otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano uint64          `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64          `json:"timeUnixNano,string"`
+	AsDouble          otlpDouble      `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []*otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano uint64          `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64          `json:"timeUnixNano,string"`
+	Count             uint64          `json:"count,string"`
+	Sum               otlpDouble      `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []*otlpKeyValue      `json:"attributes"`
+	StartTimeUnixNano uint64               `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64               `json:"timeUnixNano,string"`
+	Count             uint64               `json:"count,string"`
+	Sum               otlpDouble           `json:"sum"`
+	QuantileValues    []*otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64    `json:"quantile"`
+	Value    otlpDouble `json:"value"`
+}
+
+// otlpDouble is a double value of OTLP metrics.
+// NaN and infinities were encoded as strings as protobuf JSON mapping requires, since encoding/json rejects them.
+type otlpDouble float64
+
+// MarshalJSON encodes non-finite values as "NaN", "Infinity" and "-Infinity"
+func (value otlpDouble) MarshalJSON() ([]byte, error) {
+	f := float64(value)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON decodes both numbers and strings of non-finite values
+func (value *otlpDouble) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		switch str {
+		case "NaN":
+			*value = otlpDouble(math.NaN())
+		case "Infinity":
+			*value = otlpDouble(math.Inf(1))
+		case "-Infinity":
+			*value = otlpDouble(math.Inf(-1))
+		default:
+			f, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return err
+			}
+			*value = otlpDouble(f)
+		}
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	*value = otlpDouble(f)
+
+	return nil
+}
+
+// toOTLPRequest converts metric families into OTLP metrics.
+//
+// Counters were converted into monotonic sums started at startTime, gauges and untyped metrics into gauges,
+// histograms into explicit-bucket histograms and summaries into summaries. Values of counters and histograms
+// should be deltas already if temporality is delta.
+func toOTLPRequest(families []*dto.MetricFamily, resourceAttributes map[string]string, startTime, now time.Time, temporality int) *otlpRequest {
+	metrics := make([]*otlpMetric, 0, len(families))
+	start := uint64(startTime.UnixNano())
+
+	for _, family := range families {
+		metric := &otlpMetric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+
+		for _, m := range family.GetMetric() {
+			ts := uint64(now.UnixNano())
+			if m.TimestampMs != nil {
+				ts = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+			attributes := toOTLPAttributes(m.GetLabel())
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if metric.Sum == nil {
+					metric.Sum = &otlpSum{AggregationTemporality: temporality, IsMonotonic: true}
+				}
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, &otlpNumberDataPoint{
+					Attributes:        attributes,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					AsDouble:          otlpDouble(m.GetCounter().GetValue()),
+				})
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				if metric.Gauge == nil {
+					metric.Gauge = &otlpGauge{}
+				}
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, &otlpNumberDataPoint{
+					Attributes:   attributes,
+					TimeUnixNano: ts,
+					AsDouble:     otlpDouble(value),
+				})
+			case dto.MetricType_HISTOGRAM:
+				if metric.Histogram == nil {
+					metric.Histogram = &otlpHistogram{AggregationTemporality: temporality}
+				}
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints,
+					toOTLPHistogramDataPoint(m.GetHistogram(), attributes, start, ts))
+			case dto.MetricType_SUMMARY:
+				if metric.Summary == nil {
+					metric.Summary = &otlpSummary{}
+				}
+				summary := m.GetSummary()
+				point := &otlpSummaryDataPoint{
+					Attributes:        attributes,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             summary.GetSampleCount(),
+					Sum:               otlpDouble(summary.GetSampleSum()),
+					QuantileValues:    make([]*otlpQuantileValue, 0, len(summary.GetQuantile())),
+				}
+				for _, quantile := range summary.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &otlpQuantileValue{
+						Quantile: quantile.GetQuantile(),
+						Value:    otlpDouble(quantile.GetValue()),
+					})
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, point)
+			}
+		}
+
+		if metric.Sum != nil || metric.Gauge != nil || metric.Histogram != nil || metric.Summary != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return &otlpRequest{
+		ResourceMetrics: []*otlpResourceMetrics{
+			{
+				Resource: &otlpResource{Attributes: toOTLPAttributes(toLabelPairs(resourceAttributes))},
+				ScopeMetrics: []*otlpScopeMetrics{
+					{
+						Scope:   &otlpScope{Name: OTLPScopeName},
+						Metrics: metrics,
+					},
+				},
+			},
+		},
+	}
+}
+
+// toOTLPHistogramDataPoint converts cumulative buckets of prometheus into per-bucket counts,
+// bucket of +Inf would be dropped from bounds since OTLP has an implicit overflow bucket
+func toOTLPHistogramDataPoint(histogram *dto.Histogram, attributes []*otlpKeyValue, start, ts uint64) *otlpHistogramDataPoint {
+	point := &otlpHistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             histogram.GetSampleCount(),
+		Sum:               otlpDouble(histogram.GetSampleSum()),
+		BucketCounts:      make([]string, 0, len(histogram.GetBucket())+1),
+		ExplicitBounds:    make([]float64, 0, len(histogram.GetBucket())),
+	}
+
+	prev := uint64(0)
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-prev, 10))
+		prev = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(histogram.GetSampleCount()-prev, 10))
+
+	return point
+}
+
+// toOTLPAttributes converts label pairs into string attributes sorted by key
+func toOTLPAttributes(pairs []*dto.LabelPair) []*otlpKeyValue {
+	res := make([]*otlpKeyValue, 0, len(pairs))
+
+	for _, pair := range pairs {
+		res = append(res, &otlpKeyValue{
+			Key:   pair.GetName(),
+			Value: &otlpAnyValue{StringValue: pair.GetValue()},
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+
+	return res
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpCollectorMock is a local stand-in of OTLP/HTTP endpoint which decodes requests
+type otlpCollectorMock struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests []*otlpRequest
+	headers  []http.Header
+}
+
+func newOTLPCollectorMock(t *testing.T) *otlpCollectorMock {
+	collector := &otlpCollectorMock{status: http.StatusOK}
+	collector.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collector.lock.Lock()
+		defer collector.lock.Unlock()
+
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == CompressionGzip {
+			gzipReader, err := gzip.NewReader(r.Body)
+			assert.Nil(t, err)
+			reader = gzipReader
+		}
+
+		req := &otlpRequest{}
+		body, _ := ioutil.ReadAll(reader)
+		assert.Nil(t, json.Unmarshal(body, req))
+
+		collector.requests = append(collector.requests, req)
+		collector.headers = append(collector.headers, r.Header)
+		w.WriteHeader(collector.status)
+	}))
+
+	return collector
+}
+
+func (collector *otlpCollectorMock) getRequests() []*otlpRequest {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	return append([]*otlpRequest{}, collector.requests...)
+}
+
+// findMetric returns metric with name in request
+func findMetric(req *otlpRequest, name string) *otlpMetric {
+	for _, metric := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if metric.Name == name {
+			return metric
+		}
+	}
+
+	return nil
+}
+
+func TestNewOTLPExporter_WithInvalidArgs(t *testing.T) {
+	// without endpoint
+	exporter, err := NewOTLPExporter()
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// with invalid compression
+	exporter, err = NewOTLPExporter(
+		WithEndpointOTLP("http://localhost:4318/v1/metrics"),
+		WithHTTPClientConfigOTLP(&HTTPClientConfig{Compression: "zstd"}))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// with invalid temporality
+	exporter, err = NewOTLPExporter(
+		WithEndpointOTLP("http://localhost:4318/v1/metrics"),
+		WithTemporalityOTLP("ut-temporality"))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+}
+
+func TestNewOTLPExporter_HappyCase(t *testing.T) {
+	assert.Nil(t, os.Setenv("UT_NAMESPACE", "ut-namespace"))
+	defer os.Unsetenv("UT_NAMESPACE")
+
+	exporter, err := NewOTLPExporter(
+		WithEndpointOTLP("http://localhost:4318/v1/metrics"),
+		WithResourceAttributesOTLP(map[string]string{
+			"service.namespace": "${UT_NAMESPACE}",
+			"service.name":      "ut-service",
+		}),
+		WithZapLoggerEntryOTLP(zapLoggerEntry),
+		WithEventLoggerEntryOTLP(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, 15*time.Second, exporter.IntervalMs)
+	assert.Equal(t, "ut-namespace", exporter.ResourceAttributes["service.namespace"])
+	assert.Equal(t, "ut-service", exporter.ResourceAttributes["service.name"])
+	// filled from AppInfoEntry
+	assert.Equal(t, rkentry.GlobalAppCtx.GetAppInfoEntry().Version, exporter.ResourceAttributes["service.version"])
+	assert.False(t, exporter.IsRunning())
+}
+
+func TestToOTLPRequest(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_counter", Help: "ut help"}, []string{"method"})
+	counter.WithLabelValues("GET").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_gauge"})
+	gauge.Set(-1)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ut_histogram", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.1)
+	histogram.Observe(0.7)
+	histogram.Observe(5)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(2)
+	registry.MustRegister(counter, gauge, histogram, summary)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	start := time.Unix(100, 0)
+	now := time.Unix(200, 0)
+	req := toOTLPRequest(families, map[string]string{"service.name": "ut-service"}, start, now, otlpTemporalityCumulative)
+
+	resource := req.ResourceMetrics[0].Resource
+	assert.Equal(t, "service.name", resource.Attributes[0].Key)
+	assert.Equal(t, "ut-service", resource.Attributes[0].Value.StringValue)
+	assert.Equal(t, OTLPScopeName, req.ResourceMetrics[0].ScopeMetrics[0].Scope.Name)
+
+	// counter would be cumulative monotonic sum
+	sum := findMetric(req, "ut_counter")
+	assert.Equal(t, "ut help", sum.Description)
+	assert.Equal(t, otlpTemporalityCumulative, sum.Sum.AggregationTemporality)
+	assert.True(t, sum.Sum.IsMonotonic)
+	assert.Equal(t, otlpDouble(3), sum.Sum.DataPoints[0].AsDouble)
+	assert.Equal(t, uint64(start.UnixNano()), sum.Sum.DataPoints[0].StartTimeUnixNano)
+	assert.Equal(t, uint64(now.UnixNano()), sum.Sum.DataPoints[0].TimeUnixNano)
+	assert.Equal(t, "method", sum.Sum.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "GET", sum.Sum.DataPoints[0].Attributes[0].Value.StringValue)
+
+	assert.Equal(t, otlpDouble(-1), findMetric(req, "ut_gauge").Gauge.DataPoints[0].AsDouble)
+
+	// cumulative buckets would be converted into per-bucket counts with an overflow bucket
+	point := findMetric(req, "ut_histogram").Histogram.DataPoints[0]
+	assert.Equal(t, []float64{0.5, 1}, point.ExplicitBounds)
+	assert.Equal(t, []string{"1", "1", "1"}, point.BucketCounts)
+	assert.Equal(t, uint64(3), point.Count)
+	assert.Equal(t, otlpDouble(5.8), point.Sum)
+
+	quantiles := findMetric(req, "ut_summary").Summary.DataPoints[0].QuantileValues
+	assert.Equal(t, 0.5, quantiles[0].Quantile)
+	assert.Equal(t, otlpDouble(2), quantiles[0].Value)
+}
+
+func TestOTLPExporter_ExportNow(t *testing.T) {
+	collector := newOTLPCollectorMock(t)
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(
+		WithEndpointOTLP(collector.URL),
+		WithHTTPClientConfigOTLP(&HTTPClientConfig{Compression: CompressionGzip}),
+		WithAuthOTLP(&AuthConfig{Headers: map[string]string{"X-Tenant": "ut-tenant"}}),
+		WithZapLoggerEntryOTLP(zapLoggerEntry),
+		WithEventLoggerEntryOTLP(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_counter"}))
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	requests := collector.getRequests()
+	assert.Len(t, requests, 1)
+	assert.NotNil(t, findMetric(requests[0], "ut_counter"))
+	assert.Equal(t, "application/json", collector.headers[0].Get("Content-Type"))
+	assert.Equal(t, "ut-tenant", collector.headers[0].Get("X-Tenant"))
+	assert.Equal(t, CompressionGzip, collector.headers[0].Get("Content-Encoding"))
+}
+
+func TestOTLPExporter_ExportNow_WithDeltaTemporality(t *testing.T) {
+	collector := newOTLPCollectorMock(t)
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(
+		WithEndpointOTLP(collector.URL),
+		WithTemporalityOTLP(OTLPTemporalityDelta),
+		WithZapLoggerEntryOTLP(zapLoggerEntry),
+		WithEventLoggerEntryOTLP(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_counter"})
+	counter.Add(3)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary"})
+	summary.Observe(2)
+	registry.MustRegister(counter, summary)
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	counter.Add(2)
+	summary.Observe(2)
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	requests := collector.getRequests()
+	assert.Len(t, requests, 2)
+
+	// the first export would be started from start time of exporter
+	first := findMetric(requests[0], "ut_counter").Sum
+	assert.Equal(t, otlpTemporalityDelta, first.AggregationTemporality)
+	assert.Equal(t, otlpDouble(3), first.DataPoints[0].AsDouble)
+	assert.Equal(t, uint64(exporter.startTime.UnixNano()), first.DataPoints[0].StartTimeUnixNano)
+
+	// the second export would be started from the first one
+	second := findMetric(requests[1], "ut_counter").Sum
+	assert.Equal(t, otlpDouble(2), second.DataPoints[0].AsDouble)
+	assert.Equal(t, first.DataPoints[0].TimeUnixNano, second.DataPoints[0].StartTimeUnixNano)
+
+	// summaries would be kept cumulative
+	assert.Equal(t, uint64(2), findMetric(requests[1], "ut_summary").Summary.DataPoints[0].Count)
+}
+
+func TestOTLPExporter_ExportNow_WithNonFiniteValues(t *testing.T) {
+	collector := newOTLPCollectorMock(t)
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(
+		WithEndpointOTLP(collector.URL),
+		WithZapLoggerEntryOTLP(zapLoggerEntry),
+		WithEventLoggerEntryOTLP(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	// quantiles of empty summary would be NaN
+	registry := prometheus.NewRegistry()
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary", Objectives: map[float64]float64{0.5: 0.05}})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_gauge"})
+	gauge.Set(math.Inf(-1))
+	registry.MustRegister(summary, gauge)
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	requests := collector.getRequests()
+	assert.Len(t, requests, 1)
+	assert.True(t, math.IsNaN(float64(findMetric(requests[0], "ut_summary").Summary.DataPoints[0].QuantileValues[0].Value)))
+	assert.True(t, math.IsInf(float64(findMetric(requests[0], "ut_gauge").Gauge.DataPoints[0].AsDouble), -1))
+}
+
+func TestOTLPDouble_MarshalJSON(t *testing.T) {
+	bytes, err := json.Marshal([]otlpDouble{1.5, otlpDouble(math.NaN()), otlpDouble(math.Inf(1)), otlpDouble(math.Inf(-1))})
+	assert.Nil(t, err)
+	assert.Equal(t, `[1.5,"NaN","Infinity","-Infinity"]`, string(bytes))
+}
+
+func TestRegisterPromEntriesWithConfig_WithOTLP(t *testing.T) {
+	bootFileWithOTLP := `
+---
+prom:
+  enabled: true
+  otlp:
+    enabled: true
+    endpoint: "http://localhost:4318/v1/metrics"
+    intervalMs: 2000
+    resourceAttributes:
+      - key: deployment.environment
+        value: ut
+    httpClient:
+      compression: gzip
+    temporality: delta
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithOTLP), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.Exporters, 1)
+	otlp := entry.Exporters[0].(*OTLPExporter)
+	assert.Equal(t, "http://localhost:4318/v1/metrics", otlp.Endpoint)
+	assert.Equal(t, 2*time.Second, otlp.IntervalMs)
+	assert.Equal(t, "ut", otlp.ResourceAttributes["deployment.environment"])
+	assert.Equal(t, CompressionGzip, otlp.HTTPClient.Compression)
+	assert.Equal(t, OTLPTemporalityDelta, otlp.Temporality)
+	assert.Contains(t, entry.String(), "http://localhost:4318/v1/metrics")
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// exportIntervalDefault is default interval of exporters other than pushGateway pusher
+const exportIntervalDefault = 15 * time.Second
+
+// exportInterval validates interval of exporters, exportIntervalDefault would be returned if interval is zero,
+// so that interval could be omitted in boot config
+func exportInterval(interval time.Duration) (time.Duration, error) {
+	if interval < 0 {
+		return 0, errors.New("invalid intervalMs")
+	}
+
+	if interval == 0 {
+		return exportIntervalDefault, nil
+	}
+
+	return interval, nil
+}
+
+// periodicJob runs a function periodically in a dedicated goroutine.
+// thread safe
+//
+// Interval is evaluated before every wait instead of using time.Ticker, so that interval could
+// be changed while running. Context passed to the function would be canceled while stopping,
+// and stop() blocks until the goroutine exits, so that nothing would be running after stop() returns.
+type periodicJob struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs function immediately and then waits for interval returned by next() in every cycle.
+// false would be returned if job is already running.
+func (job *periodicJob) start(next func() time.Duration, run func(ctx context.Context)) bool {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	if job.cancel != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	job.cancel = cancel
+	job.done = done
+
+	go func() {
+		defer close(done)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			run(ctx)
+
+			// check again in case of run was canceled
+			if ctx.Err() != nil {
+				return
+			}
+
+			timer.Reset(next())
+		}
+	}()
+
+	return true
+}
+
+// stop cancels the job and blocks until the goroutine exits.
+// false would be returned if job is not running.
+func (job *periodicJob) stop() bool {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	if job.cancel == nil {
+		return false
+	}
+
+	job.cancel()
+	<-job.done
+
+	job.cancel = nil
+	job.done = nil
+
+	return true
+}
+
+// isRunning returns true if job was started and not stopped yet
+func (job *periodicJob) isRunning() bool {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	return job.cancel != nil
+}
+
+// exportPayload is a request body along with metric families carried by it, families would be committed
+// into delta calculator once payload was delivered, they could be nil if exporter does not compute deltas
+type exportPayload struct {
+	body     []byte
+	families []*dto.MetricFamily
+}
+
+// exportCodec is implemented by exporters built on periodicExporter
+type exportCodec interface {
+	// encode converts gathered metric families into payloads, every payload would be sent in its own request
+	encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error)
+
+	// send a payload to sink, error wrapped with permanentError would not be retried
+	send(ctx context.Context, payload []byte) error
+}
+
+// periodicExporter is the shared core of exporters which gather metrics periodically and send them to a sink.
+// Exporters embed it, supply exportCodec and call init() at the end of constructor.
+// thread safe
+//
+// 1: intervalMS: periodic job interval in milliseconds, should not be negative, 15 seconds would be used if zero
+// 2: jitter:     fraction of interval which would be randomized in every cycle
+// 3: gatherer:   metrics would be gathered from it, gatherer of prom entry would be used if registered into entry
+// 4: retrier:    retries failed payloads with exponential backoff in every cycle, retries would not overlap next cycle
+// 5: delta:      committed after all payloads were sent if not nil, deltas of failed export would be sent in the next one
+type periodicExporter struct {
+	ZapLoggerEntry   *rkentry.ZapLoggerEntry   `json:"zapLoggerEntry" yaml:"zapLoggerEntry"`
+	EventLoggerEntry *rkentry.EventLoggerEntry `json:"eventLoggerEntry" yaml:"eventLoggerEntry"`
+	IntervalMs       time.Duration             `json:"intervalMs" yaml:"intervalMs"`
+	Jitter           float64                   `json:"jitter" yaml:"jitter"`
+	Running          *atomic.Bool              `json:"running" yaml:"running"`
+	name             string                    `json:"-" yaml:"-"`
+	endpoint         string                    `json:"-" yaml:"-"`
+	fields           []zap.Field               `json:"-" yaml:"-"`
+	codec            exportCodec               `json:"-" yaml:"-"`
+	gatherer         prometheus.Gatherer       `json:"-" yaml:"-"`
+	retrier          *retrier                  `json:"-" yaml:"-"`
+	delta            *DeltaCalculator          `json:"-" yaml:"-"`
+	health           *healthTracker            `json:"-" yaml:"-"`
+	lock             *sync.Mutex               `json:"-" yaml:"-"`
+	exportLock       *sync.Mutex               `json:"-" yaml:"-"`
+	job              *periodicJob              `json:"-" yaml:"-"`
+}
+
+// newPeriodicExporter returns core with default values, options of exporters would be applied on it
+func newPeriodicExporter() periodicExporter {
+	return periodicExporter{
+		ZapLoggerEntry:   rkentry.GlobalAppCtx.GetZapLoggerEntryDefault(),
+		EventLoggerEntry: rkentry.GlobalAppCtx.GetEventLoggerEntryDefault(),
+		IntervalMs:       exportIntervalDefault,
+		Running:          atomic.NewBool(false),
+		gatherer:         prometheus.DefaultGatherer,
+		retrier:          newRetrier(0, 0, 0, 0),
+		lock:             &sync.Mutex{},
+		exportLock:       &sync.Mutex{},
+		job:              &periodicJob{},
+	}
+}
+
+// init validates interval and jitter and binds codec.
+// name identifies exporter in logs and events, endpoint is where metrics would be sent to,
+// health metrics would be named with prefix and labeled with constLabels.
+func (exporter *periodicExporter) init(name, endpoint string, codec exportCodec, prefix string, constLabels prometheus.Labels) error {
+	var err error
+	if exporter.IntervalMs, err = exportInterval(exporter.IntervalMs); err != nil {
+		return err
+	}
+
+	if exporter.Jitter < 0 || exporter.Jitter > 1 {
+		return errors.New(fmt.Sprintf("invalid jitter:%v", exporter.Jitter))
+	}
+
+	if exporter.ZapLoggerEntry == nil {
+		exporter.ZapLoggerEntry = rkentry.GlobalAppCtx.GetZapLoggerEntryDefault()
+	}
+
+	if exporter.EventLoggerEntry == nil {
+		exporter.EventLoggerEntry = rkentry.GlobalAppCtx.GetEventLoggerEntryDefault()
+	}
+
+	exporter.name = name
+	exporter.endpoint = endpoint
+	exporter.codec = codec
+	exporter.health = newHealthTracker(prefix, constLabels)
+	exporter.fields = make([]zap.Field, 0, len(constLabels))
+	for _, pair := range toLabelPairs(constLabels) {
+		exporter.fields = append(exporter.fields, zap.String(pair.GetName(), pair.GetValue()))
+	}
+
+	return nil
+}
+
+// Start starts a periodic job, metrics would be exported immediately and then every interval
+func (exporter *periodicExporter) Start() {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+
+	if !exporter.job.start(exporter.nextInterval, exporter.publish) {
+		exporter.ZapLoggerEntry.GetLogger().Info(exporter.name+" already started", exporter.fields...)
+		return
+	}
+
+	exporter.Running.Store(true)
+
+	exporter.ZapLoggerEntry.GetLogger().Info("starting "+exporter.name, exporter.fields...)
+}
+
+// Stop stops periodic job and blocks until the in-flight export is canceled and the goroutine exits
+func (exporter *periodicExporter) Stop() {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+
+	if exporter.job.stop() {
+		exporter.Running.Store(false)
+	}
+}
+
+// IsRunning validate whether periodic job is running or not
+func (exporter *periodicExporter) IsRunning() bool {
+	return exporter.Running.Load()
+}
+
+// Internal use only
+func (exporter *periodicExporter) nextInterval() time.Duration {
+	return jitter(exporter.IntervalMs, exporter.Jitter)
+}
+
+// Internal use only
+func (exporter *periodicExporter) publish(ctx context.Context) {
+	event := exporter.EventLoggerEntry.GetEventHelper().Start("export")
+	event.AddPayloads(append([]zap.Field{
+		zap.String("exporter", exporter.name),
+		zap.Duration("intervalMs", exporter.IntervalMs)}, exporter.fields...)...)
+
+	if err := exporter.export(ctx, exporter.IntervalMs); err != nil {
+		exporter.ZapLoggerEntry.GetLogger().Warn("failed to export metrics",
+			append([]zap.Field{zap.String("exporter", exporter.name), zap.Error(err)}, exporter.fields...)...)
+		exporter.EventLoggerEntry.GetEventHelper().FinishWithError(event, err)
+	} else {
+		exporter.EventLoggerEntry.GetEventHelper().Finish(event)
+	}
+}
+
+// ExportNow gathers and exports metrics synchronously with retries and returns the first error.
+// Retries would not be bounded by interval, use deadline of ctx instead.
+func (exporter *periodicExporter) ExportNow(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return exporter.export(ctx, 0)
+}
+
+// export gathers, encodes and sends metrics, payloads would be sent in order even if some of them failed.
+// Exporters computing deltas would stop at the first failure instead, and only families carried by delivered
+// payloads would be committed, so that deltas would neither be lost nor sent twice.
+// The first error would be returned, gather error would be returned if sending succeeded.
+func (exporter *periodicExporter) export(ctx context.Context, bound time.Duration) error {
+	exporter.exportLock.Lock()
+	defer exporter.exportLock.Unlock()
+
+	families, err := exporter.gatherer.Gather()
+	if err != nil && len(families) < 1 {
+		exporter.health.record(err, 0)
+		return err
+	}
+
+	now := time.Now()
+	payloads, encodeErr := exporter.codec.encode(families, now)
+	if encodeErr != nil {
+		exporter.health.record(encodeErr, 0)
+		return encodeErr
+	}
+
+	var res error
+	delivered := make([]*dto.MetricFamily, 0, len(families))
+	for _, payload := range payloads {
+		body := payload.body
+		if sendErr := exporter.retrier.do(ctx, bound, func(ctx context.Context) error {
+			sendErr := exporter.codec.send(ctx, body)
+			exporter.health.record(unwrapPermanent(sendErr), int64(len(body)))
+			return sendErr
+		}); sendErr != nil {
+			if res == nil {
+				res = sendErr
+			}
+
+			if exporter.delta != nil {
+				break
+			}
+			continue
+		}
+
+		delivered = append(delivered, payload.families...)
+	}
+
+	// window of deltas would be kept if nothing was delivered
+	if exporter.delta != nil && (res == nil || len(delivered) > 0) {
+		exporter.delta.commitDelivered(families, delivered, now)
+	}
+
+	if res != nil {
+		return res
+	}
+
+	return err
+}
+
+// Health returns a snapshot of export health, every request including retries would be recorded
+func (exporter *periodicExporter) Health() HealthStatus {
+	return exporter.health.get()
+}
+
+// HealthCollector returns a collector which exposes export health as metrics
+func (exporter *periodicExporter) HealthCollector() prometheus.Collector {
+	return exporter.health
+}
+
+// Internal use only
+func (exporter *periodicExporter) target() string {
+	return exporter.endpoint
+}
+
+// SetGatherer sets gatherer of prometheus, it is safe to call SetGatherer while exporting
+func (exporter *periodicExporter) SetGatherer(gatherer prometheus.Gatherer) {
+	if gatherer == nil {
+		return
+	}
+
+	exporter.exportLock.Lock()
+	defer exporter.exportLock.Unlock()
+
+	exporter.gatherer = gatherer
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeriodicJob_HappyCase(t *testing.T) {
+	job := &periodicJob{}
+	count := atomic.NewInt32(0)
+
+	next := func() time.Duration { return 10 * time.Millisecond }
+	run := func(context.Context) { count.Inc() }
+
+	assert.True(t, job.start(next, run))
+	assert.False(t, job.start(next, run))
+	assert.True(t, job.isRunning())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, job.stop())
+	assert.False(t, job.stop())
+	assert.False(t, job.isRunning())
+
+	// nothing would be running after stop
+	res := count.Load()
+	assert.True(t, res > 1)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, res, count.Load())
+
+	// start after stop
+	assert.True(t, job.start(next, run))
+	assert.True(t, job.stop())
+}
+
+func TestPeriodicJob_StopWithBlockingRun(t *testing.T) {
+	job := &periodicJob{}
+	started := make(chan struct{})
+
+	job.start(func() time.Duration { return time.Hour }, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+
+	<-started
+	assert.True(t, job.stop())
+}
+
+func TestExportInterval(t *testing.T) {
+	interval, err := exportInterval(0)
+	assert.Nil(t, err)
+	assert.Equal(t, exportIntervalDefault, interval)
+
+	interval, err = exportInterval(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, interval)
+
+	_, err = exportInterval(-1)
+	assert.NotNil(t, err)
+}
+
+// codecMock encodes every metric family into a payload of its name, sending would fail with errors in order
+type codecMock struct {
+	lock sync.Mutex
+	errs []error
+	sent []string
+}
+
+func (codec *codecMock) encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error) {
+	res := make([]*exportPayload, 0, len(families))
+	for _, family := range families {
+		res = append(res, &exportPayload{body: []byte(family.GetName()), families: []*dto.MetricFamily{family}})
+	}
+
+	return res, nil
+}
+
+func (codec *codecMock) send(ctx context.Context, payload []byte) error {
+	codec.lock.Lock()
+	defer codec.lock.Unlock()
+
+	codec.sent = append(codec.sent, string(payload))
+	if len(codec.errs) < 1 {
+		return nil
+	}
+
+	err := codec.errs[0]
+	codec.errs = codec.errs[1:]
+	return err
+}
+
+func (codec *codecMock) getSent() []string {
+	codec.lock.Lock()
+	defer codec.lock.Unlock()
+
+	return append([]string{}, codec.sent...)
+}
+
+func newPeriodicExporterMock(t *testing.T, codec exportCodec, gatherer prometheus.Gatherer) *periodicExporter {
+	exporter := newPeriodicExporter()
+	exporter.IntervalMs = intervalMs
+	exporter.ZapLoggerEntry = zapLoggerEntry
+	exporter.EventLoggerEntry = eventLoggerEntry
+	exporter.retrier = newRetrier(1, time.Millisecond, time.Millisecond, 0)
+	exporter.SetGatherer(gatherer)
+	assert.Nil(t, exporter.init("ut exporter", "ut://localhost", codec, "rk_ut", prometheus.Labels{"ut_sink": "ut"}))
+
+	return &exporter
+}
+
+func TestPeriodicExporter_Init_WithInvalidArgs(t *testing.T) {
+	exporter := newPeriodicExporter()
+	exporter.IntervalMs = -1
+	assert.NotNil(t, exporter.init("ut exporter", "ut://localhost", &codecMock{}, "rk_ut", nil))
+
+	exporter = newPeriodicExporter()
+	exporter.Jitter = 2
+	assert.NotNil(t, exporter.init("ut exporter", "ut://localhost", &codecMock{}, "rk_ut", nil))
+
+	// loggers would be filled with default ones
+	exporter = newPeriodicExporter()
+	exporter.ZapLoggerEntry = nil
+	exporter.EventLoggerEntry = nil
+	assert.Nil(t, exporter.init("ut exporter", "ut://localhost", &codecMock{}, "rk_ut", nil))
+	assert.NotNil(t, exporter.ZapLoggerEntry)
+	assert.NotNil(t, exporter.EventLoggerEntry)
+	assert.Equal(t, exportIntervalDefault, exporter.IntervalMs)
+}
+
+func TestPeriodicExporter_ExportNow(t *testing.T) {
+	codec := &codecMock{errs: []error{
+		errors.New("ut transient error"),
+		nil,
+		&permanentError{err: errors.New("ut rejected")},
+	}}
+	exporter := newPeriodicExporterMock(t, codec, newRegistryWith(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_a"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_b"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_c"})))
+
+	// transient error would be retried, rejected payload would not be retried
+	// and payloads after the failed one would still be sent
+	assert.EqualError(t, exporter.ExportNow(context.Background()), "ut rejected")
+	assert.Equal(t, []string{"ut_a", "ut_a", "ut_b", "ut_c"}, codec.getSent())
+
+	status := exporter.Health()
+	assert.Equal(t, int64(4), status.TotalPushes)
+	assert.Equal(t, int64(2), status.TotalFailures)
+	assert.Equal(t, int64(16), status.TotalBytes)
+}
+
+func TestPeriodicExporter_ExportNow_WithGatherError(t *testing.T) {
+	codec := &codecMock{}
+	families, err := newRegistryWith(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_a"})).Gather()
+	assert.Nil(t, err)
+
+	// gather error would be returned after metrics gathered were sent
+	exporter := newPeriodicExporterMock(t, codec, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, errors.New("ut gather error")
+	}))
+	assert.EqualError(t, exporter.ExportNow(context.Background()), "ut gather error")
+	assert.Equal(t, []string{"ut_a"}, codec.getSent())
+
+	// nothing would be sent if nothing was gathered
+	exporter.SetGatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return nil, errors.New("ut gather error")
+	}))
+	assert.EqualError(t, exporter.ExportNow(context.Background()), "ut gather error")
+	assert.Len(t, codec.getSent(), 1)
+	assert.Equal(t, "ut gather error", exporter.Health().LastError)
+}
+
+func TestPeriodicExporter_ExportNow_WithDelta(t *testing.T) {
+	codec := &codecMock{errs: []error{&permanentError{err: errors.New("ut rejected")}}}
+	exporter := newPeriodicExporterMock(t, codec, newRegistryWith(prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_a"})))
+	exporter.delta = NewDeltaCalculator()
+
+	// deltas would not be committed if sending failed
+	assert.NotNil(t, exporter.ExportNow(context.Background()))
+	assert.True(t, exporter.delta.LastCommit().IsZero())
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	assert.False(t, exporter.delta.LastCommit().IsZero())
+}
+
+func TestPeriodicExporter_ExportNow_WithPartialDelivery(t *testing.T) {
+	first := prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_a"})
+	second := prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_b"})
+	first.Add(2)
+	second.Add(3)
+	registry := newRegistryWith(first, second)
+
+	// the second payload would be rejected
+	codec := &codecMock{errs: []error{nil, &permanentError{err: errors.New("ut rejected")}}}
+	exporter := newPeriodicExporterMock(t, codec, registry)
+	exporter.delta = NewDeltaCalculator()
+
+	assert.NotNil(t, exporter.ExportNow(context.Background()))
+	assert.False(t, exporter.delta.LastCommit().IsZero())
+
+	// only deltas of the delivered payload were committed
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	deltas := exporter.delta.Delta(families)
+	assert.Equal(t, float64(0), deltas[0].GetMetric()[0].GetCounter().GetValue())
+	assert.Equal(t, float64(3), deltas[1].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestPeriodicExporter_ExportNow_WithDeltaAndFailure(t *testing.T) {
+	registry := newRegistryWith(
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_a"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_b"}))
+
+	// payloads after the first failure would not be sent by exporters computing deltas
+	codec := &codecMock{errs: []error{&permanentError{err: errors.New("ut rejected")}}}
+	exporter := newPeriodicExporterMock(t, codec, registry)
+	exporter.delta = NewDeltaCalculator()
+
+	assert.NotNil(t, exporter.ExportNow(context.Background()))
+	assert.Equal(t, []string{"ut_a"}, codec.getSent())
+	assert.True(t, exporter.delta.LastCommit().IsZero())
+}
+
+func TestPeriodicExporter_StartAndStop(t *testing.T) {
+	codec := &codecMock{}
+	exporter := newPeriodicExporterMock(t, codec, newRegistryWith(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_a"})))
+
+	exporter.Start()
+	// duplicate calls would be ignored
+	exporter.Start()
+	assert.True(t, exporter.IsRunning())
+
+	time.Sleep(100 * time.Millisecond)
+	exporter.Stop()
+	assert.False(t, exporter.IsRunning())
+	assert.True(t, len(codec.getSent()) > 0)
+}
+
+func TestPeriodicExporter_SetGatherer_WhileExporting(t *testing.T) {
+	codec := &codecMock{}
+	exporter := newPeriodicExporterMock(t, codec, newRegistryWith(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_a"})))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, exporter.ExportNow(context.Background()))
+		}
+	}()
+
+	registry := newRegistryWith(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_b"}))
+	for i := 0; i < 10; i++ {
+		exporter.SetGatherer(registry)
+	}
+	<-done
+
+	// nil gatherer would be ignored
+	exporter.SetGatherer(nil)
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	sent := codec.getSent()
+	assert.Equal(t, "ut_b", sent[len(sent)-1])
+}
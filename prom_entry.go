@@ -9,6 +9,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rookie-ninja/rk-common/common"
@@ -16,7 +17,6 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -52,22 +52,31 @@ func init() {
 // 7: Pusher.BasicAuth: Basic auth used to interact with remote pushgateway.
 // 8: Pusher.Cert.Ref: Reference of rkentry.CertEntry.
 // 9: Cert.Ref: Reference of rkentry.CertEntry.
+// 10: Paths: Additional paths served by prom entry, each of them is bound to its own registry.
+// 11: Collectors: Toggles of default collectors.
+// 12: Labels: Global labels applied to every metric, values could be expanded from environment variables.
+// 13: Relabel: Relabel configs applied to every gathered metric.
+// 14: RemoteWrite: Periodic remote write exporter, see BootConfigRemoteWrite.
+// 15: OTLP: Periodic OTLP/HTTP exporter, see BootConfigOTLP.
+// 16: Bridges: Periodic Graphite and StatsD bridge exporters, see BootConfigBridge.
+// 17: Influx: Periodic InfluxDB line protocol exporter, see BootConfigInflux.
+// 18: Exporters: Exporters created by factories registered with RegisterExporterFactory, see BootConfigExporter.
 type BootConfigProm struct {
 	Prom struct {
-		Path    string `yaml:"path" json:"path"`
-		Port    uint64 `yaml:"port" json:"port"`
-		Enabled bool   `yaml:"enabled" json:"enabled"`
-		Pusher  struct {
-			Enabled       bool   `yaml:"enabled" json:"enabled"`
-			IntervalMs    int64  `yaml:"intervalMs" json:"intervalMs"`
-			JobName       string `yaml:"jobName" json:"jobName"`
-			RemoteAddress string `yaml:"remoteAddress" json:"remoteAddress"`
-			BasicAuth     string `yaml:"basicAuth" json:"basicAuth"`
-			Cert          struct {
-				Ref string `yaml:"ref" json:"ref"`
-			} `yaml:"cert" json:"cert"`
-		} `yaml:"pusher" json:"pusher"`
-		Cert struct {
+		Path        string                `yaml:"path" json:"path"`
+		Port        uint64                `yaml:"port" json:"port"`
+		Enabled     bool                  `yaml:"enabled" json:"enabled"`
+		Pusher      BootConfigPusher      `yaml:"pusher" json:"pusher"`
+		Paths       []BootConfigPath      `yaml:"paths" json:"paths"`
+		Collectors  BootConfigCollectors  `yaml:"collectors" json:"collectors"`
+		Labels      map[string]string     `yaml:"labels" json:"labels"`
+		Relabel     []*RelabelConfig      `yaml:"relabel" json:"relabel"`
+		RemoteWrite BootConfigRemoteWrite `yaml:"remoteWrite" json:"remoteWrite"`
+		OTLP        BootConfigOTLP        `yaml:"otlp" json:"otlp"`
+		Bridges     []BootConfigBridge    `yaml:"bridges" json:"bridges"`
+		Influx      BootConfigInflux      `yaml:"influx" json:"influx"`
+		Exporters   []BootConfigExporter  `yaml:"exporters" json:"exporters"`
+		Cert        struct {
 			Ref string `yaml:"ref" json:"ref"`
 		} `yaml:"cert" json:"cert"`
 		Logger struct {
@@ -81,9 +90,56 @@ type BootConfigProm struct {
 	} `yaml:"prom" json:"prom"`
 }
 
+// BootConfigPusher is pushgateway pusher config of prom entry.
+//
+// 1: Enabled: Enable pushgateway pusher.
+// 2: IntervalMS: Interval of pushing metrics to remote pushgateway in milliseconds.
+// 3: JobName: Job name would be attached as label while pushing to remote pushgateway.
+// 4: RemoteAddress: Pushgateway address, could be form of http://x.x.x.x or x.x.x.x
+// 5: BasicAuth: Basic auth used to interact with remote pushgateway.
+// 6: Cert.Ref: Reference of rkentry.CertEntry.
+// 7: Grouping: Grouping labels, values could be expanded from environment variables and $HOSTNAME.
+// 8: Mode: Push mode, one of push and add.
+// 9: DeleteOnStop: Delete the group from remote pushgateway while stopping.
+// 10: Jitter: Fraction of interval which would be randomized in every cycle.
+// 11: Retry: Retry failed pushes with exponential backoff in every cycle.
+// 12: OneShot: Push once while interrupting instead of pushing periodically.
+// 13: Targets: Push to several pushgateways concurrently, unset fields of targets were inherited from pusher.
+// 14: Policy: One of all and any, decides whether pushing to targets succeeded or not.
+// 15: Auth: Basic auth, bearer token and extra headers, credentials could be loaded from files or environment variables.
+// 16: TLS: CA bundle, client certificate, server name, minimum version and insecureSkipVerify.
+// 17: HTTPClient: Timeout, proxy, keep-alive and compression of http client.
+// 18: Spool: Durable on-disk buffer of failed payloads, would not be inherited by targets.
+// 19: Filter: Include and exclude regexes of metric names and label matchers applied before pushing.
+// 20: Format: Encoding format of pushed metrics, one of protobuf and text.
+type BootConfigPusher struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	IntervalMs    int64  `yaml:"intervalMs" json:"intervalMs"`
+	JobName       string `yaml:"jobName" json:"jobName"`
+	RemoteAddress string `yaml:"remoteAddress" json:"remoteAddress"`
+	BasicAuth     string `yaml:"basicAuth" json:"basicAuth"`
+	Cert          struct {
+		Ref string `yaml:"ref" json:"ref"`
+	} `yaml:"cert" json:"cert"`
+	Grouping     map[string]string  `yaml:"grouping" json:"grouping"`
+	Mode         string             `yaml:"mode" json:"mode"`
+	Format       string             `yaml:"format" json:"format"`
+	DeleteOnStop bool               `yaml:"deleteOnStop" json:"deleteOnStop"`
+	Jitter       float64            `yaml:"jitter" json:"jitter"`
+	Retry        BootConfigRetry    `yaml:"retry" json:"retry"`
+	OneShot      bool               `yaml:"oneShot" json:"oneShot"`
+	Targets      []BootConfigPusher `yaml:"targets" json:"targets"`
+	Policy       string             `yaml:"policy" json:"policy"`
+	Auth         AuthConfig         `yaml:"auth" json:"auth"`
+	TLS          TLSConfig          `yaml:"tls" json:"tls"`
+	HTTPClient   HTTPClientConfig   `yaml:"httpClient" json:"httpClient"`
+	Spool        BootConfigSpool    `yaml:"spool" json:"spool"`
+	Filter       FilterConfig       `yaml:"filter" json:"filter"`
+}
+
 // PromEntry which implements rkentry.Entry.
 //
-// 1: Pusher            Periodic pushGateway pusher
+// 1: Exporters        Pushers and exporters provided via code, boot config or registered factories
 // 2: ZapLoggerEntry    rkentry.ZapLoggerEntry
 // 3: EventLoggerEntry  rkentry.EventLoggerEntry
 // 4: Port              Exposed port by prom entry
@@ -92,8 +148,15 @@ type BootConfigProm struct {
 // 7: Registerer        Prometheus registerer
 // 8: Gatherer          Prometheus gatherer
 // 9: CertEntry         rkentry.CertEntry
+// 10: Paths            Additional paths bound to their own gatherers
+// 11: EnableXXXCollector Toggles of default collectors registered while bootstrapping
+// 12: GlobalLabels     Labels applied to every metric of prom entry
+// 13: RelabelConfigs   Relabel configs applied to Gatherer
+// 14: Pusher           Periodic pushGateway pusher provided via WithPusher or boot config, it is held by Exporters as well
 type PromEntry struct {
+	// Deprecated: Pusher is kept for compatibility, use Exporters instead.
 	Pusher           *PushGatewayPusher        `json:"pushGatewayPusher" yaml:"pushGatewayPusher"`
+	Exporters        []Exporter                `json:"exporters" yaml:"exporters"`
 	EntryName        string                    `json:"entryName" yaml:"entryName"`
 	EntryType        string                    `json:"entryType" yaml:"entryType"`
 	EntryDescription string                    `json:"entryDescription" yaml:"entryDescription"`
@@ -105,7 +168,16 @@ type PromEntry struct {
 	Server           *http.Server              `json:"-" yaml:"-"`
 	Registry         *prometheus.Registry      `json:"-" yaml:"-"`
 	Registerer       prometheus.Registerer     `json:"-" yaml:"-"`
+	rootRegisterer   prometheus.Registerer     `json:"-" yaml:"-"`
 	Gatherer         prometheus.Gatherer       `json:"-" yaml:"-"`
+	Paths            []*PromPath               `json:"paths" yaml:"paths"`
+	GlobalLabels     prometheus.Labels         `json:"globalLabels" yaml:"globalLabels"`
+	RelabelConfigs   []*RelabelConfig          `json:"relabelConfigs" yaml:"relabelConfigs"`
+
+	EnableGoCollector        bool `json:"enableGoCollector" yaml:"enableGoCollector"`
+	EnableProcessCollector   bool `json:"enableProcessCollector" yaml:"enableProcessCollector"`
+	EnableBuildInfoCollector bool `json:"enableBuildInfoCollector" yaml:"enableBuildInfoCollector"`
+	EnableAppInfoCollector   bool `json:"enableAppInfoCollector" yaml:"enableAppInfoCollector"`
 }
 
 // PromEntryOption is used while initializing prom entry via code
@@ -153,10 +225,71 @@ func WithEventLoggerEntry(eventLoggerEntry *rkentry.EventLoggerEntry) PromEntryO
 	}
 }
 
-// WithPusher provides pushGateway of prom entry
+// WithMultiPusher provides pusher of several pushGateways of prom entry
+func WithMultiPusher(pusher *MultiPushGatewayPusher) PromEntryOption {
+	return func(entry *PromEntry) {
+		if pusher != nil {
+			entry.Exporters = append(entry.Exporters, pusher)
+		}
+	}
+}
+
+// WithRemoteWriteExporter provides remote write exporter of prom entry
+func WithRemoteWriteExporter(exporter *RemoteWriteExporter) PromEntryOption {
+	return func(entry *PromEntry) {
+		if exporter != nil {
+			entry.Exporters = append(entry.Exporters, exporter)
+		}
+	}
+}
+
+// WithOTLPExporter provides OTLP exporter of prom entry
+func WithOTLPExporter(exporter *OTLPExporter) PromEntryOption {
+	return func(entry *PromEntry) {
+		if exporter != nil {
+			entry.Exporters = append(entry.Exporters, exporter)
+		}
+	}
+}
+
+// WithBridgeExporters provides Graphite and StatsD bridge exporters of prom entry
+func WithBridgeExporters(exporters ...*BridgeExporter) PromEntryOption {
+	return func(entry *PromEntry) {
+		for i := range exporters {
+			if exporters[i] != nil {
+				entry.Exporters = append(entry.Exporters, exporters[i])
+			}
+		}
+	}
+}
+
+// WithInfluxExporter provides InfluxDB exporter of prom entry
+func WithInfluxExporter(exporter *InfluxExporter) PromEntryOption {
+	return func(entry *PromEntry) {
+		if exporter != nil {
+			entry.Exporters = append(entry.Exporters, exporter)
+		}
+	}
+}
+
+// WithExporters provides exporters of prom entry, nil exporter would be ignored
+func WithExporters(exporters ...Exporter) PromEntryOption {
+	return func(entry *PromEntry) {
+		for i := range exporters {
+			if exporters[i] != nil {
+				entry.Exporters = append(entry.Exporters, exporters[i])
+			}
+		}
+	}
+}
+
+// WithPusher provides pushGateway of prom entry, pusher would be assigned to Pusher and appended to Exporters
 func WithPusher(pusher *PushGatewayPusher) PromEntryOption {
 	return func(entry *PromEntry) {
 		entry.Pusher = pusher
+		if pusher != nil {
+			entry.Exporters = append(entry.Exporters, pusher)
+		}
 	}
 }
 
@@ -169,6 +302,73 @@ func WithPromRegistry(registry *prometheus.Registry) PromEntryOption {
 	}
 }
 
+// WithPromPath provides an additional path bound to its own gatherer
+func WithPromPath(promPath *PromPath) PromEntryOption {
+	return func(entry *PromEntry) {
+		if promPath != nil {
+			entry.Paths = append(entry.Paths, promPath)
+		}
+	}
+}
+
+// WithGoCollector enables or disables go collector, enabled by default
+func WithGoCollector(enabled bool) PromEntryOption {
+	return func(entry *PromEntry) {
+		entry.EnableGoCollector = enabled
+	}
+}
+
+// WithProcessCollector enables or disables process collector, enabled by default
+func WithProcessCollector(enabled bool) PromEntryOption {
+	return func(entry *PromEntry) {
+		entry.EnableProcessCollector = enabled
+	}
+}
+
+// WithBuildInfoCollector enables or disables build info collector, disabled by default
+func WithBuildInfoCollector(enabled bool) PromEntryOption {
+	return func(entry *PromEntry) {
+		entry.EnableBuildInfoCollector = enabled
+	}
+}
+
+// WithAppInfoCollector enables or disables app info gauge, disabled by default
+func WithAppInfoCollector(enabled bool) PromEntryOption {
+	return func(entry *PromEntry) {
+		entry.EnableAppInfoCollector = enabled
+	}
+}
+
+// WithGlobalLabels provides labels applied to every metric of prom entry.
+// Values could be expanded from environment variables with form of $VAR or ${VAR}.
+//
+// Labels were applied by wrapping Registerer, so that MetricsSet created with Registerer
+// would inherit them automatically.
+func WithGlobalLabels(labels map[string]string) PromEntryOption {
+	return func(entry *PromEntry) {
+		if entry.GlobalLabels == nil {
+			entry.GlobalLabels = make(prometheus.Labels)
+		}
+
+		for k, v := range labels {
+			entry.GlobalLabels[k] = v
+		}
+	}
+}
+
+// WithRelabelConfigs provides relabel configs applied to every gathered metric of prom entry,
+// both of scrape endpoint and pusher would be affected. Nil config would be ignored.
+// Invalid config would be skipped with an error log, the rest of configs would still be applied.
+func WithRelabelConfigs(configs ...*RelabelConfig) PromEntryOption {
+	return func(entry *PromEntry) {
+		for i := range configs {
+			if configs[i] != nil {
+				entry.RelabelConfigs = append(entry.RelabelConfigs, configs[i])
+			}
+		}
+	}
+}
+
 // WithCertEntry provides cert entry
 func WithCertEntry(certEntry *rkentry.CertEntry) PromEntryOption {
 	return func(entry *PromEntry) {
@@ -197,37 +397,76 @@ func RegisterPromEntriesWithConfig(configFilePath string) map[string]rkentry.Ent
 			eventLoggerEntry = rkentry.GlobalAppCtx.GetEventLoggerEntryDefault()
 		}
 
-		var pusher *PushGatewayPusher
-		if config.Prom.Pusher.Enabled {
-			certEntry := rkentry.GlobalAppCtx.GetCertEntry(config.Prom.Pusher.Cert.Ref)
-			var certStore *rkentry.CertStore
+		// enabled pushers and exporters which could not be constructed would be logged and skipped,
+		// so that the rest of them would keep working
+		pusher, err := newPusherFromConfig(&config.Prom.Pusher, zapLoggerEntry, eventLoggerEntry)
+		logInvalidConfig(zapLoggerEntry, "pusher", err)
 
-			if certEntry != nil {
-				certStore = certEntry.Store
-			}
+		multiPusher, err := newMultiPusherFromConfig(&config.Prom.Pusher, zapLoggerEntry, eventLoggerEntry)
+		logInvalidConfig(zapLoggerEntry, "pusher", err)
+
+		remoteWrite, err := newRemoteWriteFromConfig(&config.Prom.RemoteWrite, zapLoggerEntry, eventLoggerEntry)
+		logInvalidConfig(zapLoggerEntry, "remoteWrite", err)
+
+		otlp, err := newOTLPFromConfig(&config.Prom.OTLP, zapLoggerEntry, eventLoggerEntry)
+		logInvalidConfig(zapLoggerEntry, "otlp", err)
+
+		influx, err := newInfluxFromConfig(&config.Prom.Influx, zapLoggerEntry, eventLoggerEntry)
+		logInvalidConfig(zapLoggerEntry, "influx", err)
 
-			pusher, _ = NewPushGatewayPusher(
-				WithIntervalMSPusher(time.Duration(config.Prom.Pusher.IntervalMs)*time.Millisecond),
-				WithRemoteAddressPusher(config.Prom.Pusher.RemoteAddress),
-				WithJobNamePusher(config.Prom.Pusher.JobName),
-				WithBasicAuthPusher(config.Prom.Pusher.BasicAuth),
-				WithCertStorePusher(certStore),
-				WithZapLoggerEntryPusher(zapLoggerEntry),
-				WithEventLoggerEntryPusher(eventLoggerEntry))
+		bridges := make([]*BridgeExporter, 0)
+		for i := range config.Prom.Bridges {
+			bridge, err := newBridgeFromConfig(&config.Prom.Bridges[i], zapLoggerEntry, eventLoggerEntry)
+			logInvalidConfig(zapLoggerEntry, "bridges", err)
+			bridges = append(bridges, bridge)
 		}
 
 		certEntry := rkentry.GlobalAppCtx.GetCertEntry(config.Prom.Cert.Ref)
 
-		entry := RegisterPromEntry(
+		opts := []PromEntryOption{
 			WithPort(config.Prom.Port),
 			WithPath(config.Prom.Path),
 			WithCertEntry(certEntry),
 			WithZapLoggerEntry(zapLoggerEntry),
 			WithEventLoggerEntry(eventLoggerEntry),
-			WithPusher(pusher))
+			WithPusher(pusher),
+			WithMultiPusher(multiPusher),
+			WithRemoteWriteExporter(remoteWrite),
+			WithOTLPExporter(otlp),
+			WithBridgeExporters(bridges...),
+			WithInfluxExporter(influx),
+			WithExporters(newExportersFromConfig(config.Prom.Exporters, zapLoggerEntry, eventLoggerEntry)...),
+			WithBuildInfoCollector(config.Prom.Collectors.BuildInfo),
+			WithAppInfoCollector(config.Prom.Collectors.AppInfo),
+			WithGlobalLabels(config.Prom.Labels),
+			WithRelabelConfigs(config.Prom.Relabel...),
+		}
+
+		if config.Prom.Collectors.Go != nil {
+			opts = append(opts, WithGoCollector(*config.Prom.Collectors.Go))
+		}
+
+		if config.Prom.Collectors.Process != nil {
+			opts = append(opts, WithProcessCollector(*config.Prom.Collectors.Process))
+		}
+
+		for i := range config.Prom.Paths {
+			pathConfig := &config.Prom.Paths[i]
+			pathPusher, err := newPusherFromConfig(&pathConfig.Pusher, zapLoggerEntry, eventLoggerEntry)
+			logInvalidConfig(zapLoggerEntry, "paths", err)
+
+			opts = append(opts, WithPromPath(NewPromPath(
+				pathConfig.Path,
+				newHandlerOptsFromConfig(&pathConfig.Handler),
+				pathPusher)))
+		}
 
-		if entry.Pusher != nil {
-			entry.Pusher.SetGatherer(entry.Gatherer)
+		entry := RegisterPromEntry(opts...)
+
+		for _, exporter := range entry.exporters() {
+			if setter, ok := exporter.(gathererSetter); ok {
+				setter.SetGatherer(entry.Gatherer)
+			}
 		}
 
 		res[entry.GetName()] = entry
@@ -236,6 +475,264 @@ func RegisterPromEntriesWithConfig(configFilePath string) map[string]rkentry.Ent
 	return res
 }
 
+// logInvalidConfig logs error of enabled section in boot config which could not be constructed
+func logInvalidConfig(zapLoggerEntry *rkentry.ZapLoggerEntry, section string, err error) {
+	if err != nil {
+		zapLoggerEntry.GetLogger().Error("invalid prom config, skipping",
+			zap.String("section", section),
+			zap.Error(err))
+	}
+}
+
+// newPusherFromConfig creates pusher from config, nil would be returned along with nil error if disabled
+func newPusherFromConfig(config *BootConfigPusher,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*PushGatewayPusher, error) {
+	// pushing to several targets, see newMultiPusherFromConfig
+	if !config.Enabled || len(config.Targets) > 0 {
+		return nil, nil
+	}
+
+	certEntry := rkentry.GlobalAppCtx.GetCertEntry(config.Cert.Ref)
+	var certStore *rkentry.CertStore
+
+	if certEntry != nil {
+		certStore = certEntry.Store
+	}
+
+	return NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithRemoteAddressPusher(config.RemoteAddress),
+		WithJobNamePusher(config.JobName),
+		WithBasicAuthPusher(config.BasicAuth),
+		WithAuthPusher(&config.Auth),
+		WithTLSPusher(&config.TLS),
+		WithHTTPClientConfigPusher(&config.HTTPClient),
+		WithSpoolPusher(config.Spool.Dir,
+			config.Spool.MaxBytes,
+			time.Duration(config.Spool.MaxAgeMs)*time.Millisecond),
+		WithCertStorePusher(certStore),
+		WithGroupingPusher(config.Grouping),
+		WithFilterPusher(&config.Filter),
+		WithModePusher(config.Mode),
+		WithFormatPusher(config.Format),
+		WithDeleteOnStopPusher(config.DeleteOnStop),
+		WithJitterPusher(config.Jitter),
+		WithRetryPusher(config.Retry.MaxRetries,
+			time.Duration(config.Retry.InitialBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxElapsedMs)*time.Millisecond),
+		WithOneShotPusher(config.OneShot),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+}
+
+// newRemoteWriteFromConfig creates remote write exporter from config, nil would be returned along with nil error if disabled
+func newRemoteWriteFromConfig(config *BootConfigRemoteWrite,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*RemoteWriteExporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	return NewRemoteWriteExporter(
+		WithURLRemoteWrite(config.URL),
+		WithIntervalMSRemoteWrite(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithBatchSizeRemoteWrite(config.BatchSize),
+		WithExternalLabelsRemoteWrite(config.ExternalLabels),
+		WithJitterRemoteWrite(config.Jitter),
+		WithRetryRemoteWrite(config.Retry.MaxRetries,
+			time.Duration(config.Retry.InitialBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxElapsedMs)*time.Millisecond),
+		WithAuthRemoteWrite(&config.Auth),
+		WithTLSRemoteWrite(&config.TLS),
+		WithHTTPClientConfigRemoteWrite(&config.HTTPClient),
+		WithZapLoggerEntryRemoteWrite(zapLoggerEntry),
+		WithEventLoggerEntryRemoteWrite(eventLoggerEntry))
+}
+
+// newOTLPFromConfig creates OTLP exporter from config, nil would be returned along with nil error if disabled
+func newOTLPFromConfig(config *BootConfigOTLP,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*OTLPExporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	attributes := make(map[string]string, len(config.ResourceAttributes))
+	for _, attribute := range config.ResourceAttributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+
+	return NewOTLPExporter(
+		WithEndpointOTLP(config.Endpoint),
+		WithIntervalMSOTLP(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithResourceAttributesOTLP(attributes),
+		WithJitterOTLP(config.Jitter),
+		WithRetryOTLP(config.Retry.MaxRetries,
+			time.Duration(config.Retry.InitialBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxElapsedMs)*time.Millisecond),
+		WithAuthOTLP(&config.Auth),
+		WithTLSOTLP(&config.TLS),
+		WithHTTPClientConfigOTLP(&config.HTTPClient),
+		WithTemporalityOTLP(config.Temporality),
+		WithZapLoggerEntryOTLP(zapLoggerEntry),
+		WithEventLoggerEntryOTLP(eventLoggerEntry))
+}
+
+// newBridgeFromConfig creates bridge exporter from config, nil would be returned along with nil error if disabled
+func newBridgeFromConfig(config *BootConfigBridge,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*BridgeExporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	return NewBridgeExporter(
+		WithProtocolBridge(config.Protocol),
+		WithAddressBridge(config.Address),
+		WithIntervalMSBridge(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithTemplateBridge(config.Template),
+		WithJitterBridge(config.Jitter),
+		WithTimeoutBridge(time.Duration(config.TimeoutMs)*time.Millisecond),
+		WithMaxPacketSizeBridge(config.MaxPacketSize),
+		WithZapLoggerEntryBridge(zapLoggerEntry),
+		WithEventLoggerEntryBridge(eventLoggerEntry))
+}
+
+// newInfluxFromConfig creates InfluxDB exporter from config, nil would be returned along with nil error if disabled
+func newInfluxFromConfig(config *BootConfigInflux,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*InfluxExporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	return NewInfluxExporter(
+		WithURLInflux(config.URL),
+		WithVersionInflux(config.Version),
+		WithDatabaseInflux(config.Database, config.RetentionPolicy),
+		WithBucketInflux(config.Org, config.Bucket),
+		WithIntervalMSInflux(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithBatchSizeInflux(config.BatchSize),
+		WithJitterInflux(config.Jitter),
+		WithRetryInflux(config.Retry.MaxRetries,
+			time.Duration(config.Retry.InitialBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxBackoffMs)*time.Millisecond,
+			time.Duration(config.Retry.MaxElapsedMs)*time.Millisecond),
+		WithAuthInflux(&config.Auth),
+		WithTLSInflux(&config.TLS),
+		WithHTTPClientConfigInflux(&config.HTTPClient),
+		WithZapLoggerEntryInflux(zapLoggerEntry),
+		WithEventLoggerEntryInflux(eventLoggerEntry))
+}
+
+// Internal use only
+func newExportersFromConfig(configs []BootConfigExporter,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) []Exporter {
+	res := make([]Exporter, 0)
+
+	for i := range configs {
+		config := &configs[i]
+		if !config.Enabled {
+			continue
+		}
+
+		exporter, err := NewExporterFromConfig(config, zapLoggerEntry, eventLoggerEntry)
+		if err != nil {
+			// unknown type or invalid config, keep other exporters working
+			zapLoggerEntry.GetLogger().Warn("failed to create exporter",
+				zap.String("type", config.Type),
+				zap.Error(err))
+			continue
+		}
+
+		res = append(res, exporter)
+	}
+
+	return res
+}
+
+// newMultiPusherFromConfig creates pusher of targets from config, nil would be returned along with nil error
+// if disabled or targets is empty. Error of the first invalid target would be returned.
+func newMultiPusherFromConfig(config *BootConfigPusher,
+	zapLoggerEntry *rkentry.ZapLoggerEntry,
+	eventLoggerEntry *rkentry.EventLoggerEntry) (*MultiPushGatewayPusher, error) {
+	if !config.Enabled || len(config.Targets) < 1 {
+		return nil, nil
+	}
+
+	targets := make([]*PushGatewayPusher, 0, len(config.Targets))
+	for i := range config.Targets {
+		// inherit unset fields from pusher
+		target := config.Targets[i]
+		target.Enabled = true
+		target.Targets = nil
+		target.OneShot = false
+		target.DeleteOnStop = target.DeleteOnStop || config.DeleteOnStop
+
+		if target.IntervalMs < 1 {
+			target.IntervalMs = config.IntervalMs
+		}
+
+		if len(target.JobName) < 1 {
+			target.JobName = config.JobName
+		}
+
+		if target.Grouping == nil {
+			target.Grouping = config.Grouping
+		}
+
+		if len(target.Mode) < 1 {
+			target.Mode = config.Mode
+		}
+
+		if len(target.Format) < 1 {
+			target.Format = config.Format
+		}
+
+		if len(target.BasicAuth) < 1 && target.Auth.isEmpty() {
+			target.BasicAuth = config.BasicAuth
+			target.Auth = config.Auth
+		}
+
+		if target.TLS.isEmpty() && len(target.Cert.Ref) < 1 {
+			target.TLS = config.TLS
+			target.Cert = config.Cert
+		}
+
+		if target.HTTPClient == (HTTPClientConfig{}) {
+			target.HTTPClient = config.HTTPClient
+		}
+
+		if target.Retry == (BootConfigRetry{}) {
+			target.Retry = config.Retry
+		}
+
+		if target.Filter.isEmpty() {
+			target.Filter = config.Filter
+		}
+
+		pusher, err := newPusherFromConfig(&target, zapLoggerEntry, eventLoggerEntry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid target:%s", target.RemoteAddress)
+		}
+		targets = append(targets, pusher)
+	}
+
+	return NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(targets...),
+		WithPolicyMultiPusher(config.Policy),
+		WithIntervalMSMultiPusher(time.Duration(config.IntervalMs)*time.Millisecond),
+		WithJitterMultiPusher(config.Jitter),
+		WithOneShotMultiPusher(config.OneShot),
+		WithZapLoggerEntryMultiPusher(zapLoggerEntry),
+		WithEventLoggerEntryMultiPusher(eventLoggerEntry))
+}
+
 // RegisterPromEntry creates a prom entry with options and add prom entry to rk_ctx.GlobalAppCtx
 func RegisterPromEntry(opts ...PromEntryOption) *PromEntry {
 	entry := &PromEntry{
@@ -248,24 +745,22 @@ func RegisterPromEntry(opts ...PromEntryOption) *PromEntry {
 		EntryDescription: PromEntryDescription,
 		Registerer:       prometheus.DefaultRegisterer,
 		Gatherer:         prometheus.DefaultGatherer,
+
+		EnableGoCollector:      true,
+		EnableProcessCollector: true,
 	}
 
 	for i := range opts {
 		opts[i](entry)
 	}
 
-	// Trim space by default
-	entry.Path = strings.TrimSpace(entry.Path)
+	entry.Path = normalizePath(entry.Path)
 
 	if len(entry.Path) < 1 {
 		// Invalid path, use default one
 		entry.Path = defaultPath
 	}
 
-	if !strings.HasPrefix(entry.Path, "/") {
-		entry.Path = "/" + entry.Path
-	}
-
 	if entry.ZapLoggerEntry == nil {
 		entry.ZapLoggerEntry = rkentry.GlobalAppCtx.GetZapLoggerEntryDefault()
 	}
@@ -279,11 +774,211 @@ func RegisterPromEntry(opts ...PromEntryOption) *PromEntry {
 		entry.Gatherer = entry.Registry
 	}
 
+	// default collectors would be toggled on registerer without global labels, so that the ones registered
+	// by client_golang would be found, global labels would be attached to them by gatherer
+	entry.rootRegisterer = entry.Registerer
+
+	// apply global labels by wrapping registerer and gatherer
+	if len(entry.GlobalLabels) > 0 {
+		entry.GlobalLabels = expandLabels(entry.GlobalLabels)
+		entry.Registerer = prometheus.WrapRegistererWith(entry.GlobalLabels, entry.Registerer)
+		entry.Gatherer = newLabelsGatherer(entry.Gatherer, entry.GlobalLabels)
+	}
+
+	// invalid relabel config would not stop the process while constructing entry, only itself would be skipped
+	var valid []*RelabelConfig
+	for i, config := range entry.RelabelConfigs {
+		if err := ValidateRelabelConfigs(config); err != nil {
+			entry.ZapLoggerEntry.GetLogger().Error("invalid relabel config, skipping",
+				zap.Int("index", i),
+				zap.Error(err))
+			continue
+		}
+		valid = append(valid, config)
+	}
+	entry.RelabelConfigs = valid
+
+	// apply relabel configs at gather time
+	entry.Gatherer = entry.relabel(entry.Gatherer)
+
+	entry.Paths = entry.validatePaths()
+
 	rkentry.GlobalAppCtx.AddEntry(entry)
 
 	return entry
 }
 
+// relabel wraps gatherer with relabel configs which were validated while registering entry
+func (entry *PromEntry) relabel(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	res, err := NewRelabelGatherer(gatherer, entry.RelabelConfigs...)
+	if err != nil {
+		return gatherer
+	}
+
+	return res
+}
+
+// Internal use only
+func (entry *PromEntry) validatePaths() []*PromPath {
+	res := make([]*PromPath, 0, len(entry.Paths))
+	paths := map[string]bool{entry.Path: true}
+
+	for _, promPath := range entry.Paths {
+		promPath.Path = normalizePath(promPath.Path)
+
+		if promPath.Registry != nil {
+			promPath.Registerer = promPath.Registry
+			promPath.Gatherer = promPath.Registry
+		}
+
+		if len(promPath.Path) < 1 || promPath.Gatherer == nil || paths[promPath.Path] {
+			entry.ZapLoggerEntry.GetLogger().Warn("invalid or duplicate prom path, skipping",
+				zap.String("promPath", promPath.Path))
+			continue
+		}
+
+		if len(entry.GlobalLabels) > 0 {
+			if promPath.Registerer != nil {
+				promPath.Registerer = prometheus.WrapRegistererWith(entry.GlobalLabels, promPath.Registerer)
+			}
+			promPath.Gatherer = newLabelsGatherer(promPath.Gatherer, entry.GlobalLabels)
+		}
+
+		promPath.Gatherer = entry.relabel(promPath.Gatherer)
+
+		if promPath.Pusher != nil {
+			promPath.Pusher.SetGatherer(promPath.Gatherer)
+		}
+
+		paths[promPath.Path] = true
+		res = append(res, promPath)
+	}
+
+	return res
+}
+
+// registerDefaultCollectors toggles default collectors in order, the rest of collectors would still be toggled
+// if one of them failed, and the last error would be returned
+func (entry *PromEntry) registerDefaultCollectors() error {
+	collectors := []struct {
+		collector prometheus.Collector
+		enabled   bool
+	}{
+		{prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}), entry.EnableProcessCollector},
+		{prometheus.NewGoCollector(), entry.EnableGoCollector},
+		{prometheus.NewBuildInfoCollector(), entry.EnableBuildInfoCollector},
+		{NewAppInfoCollector(rkentry.GlobalAppCtx.GetAppInfoEntry()), entry.EnableAppInfoCollector},
+	}
+
+	var err error
+	for _, c := range collectors {
+		if innerErr := toggleCollector(entry.rootRegisterer, c.collector, c.enabled); innerErr != nil {
+			err = innerErr
+		}
+	}
+
+	return err
+}
+
+// GetPromPath returns additional path with exposed path, nil would be returned if not exist
+func (entry *PromEntry) GetPromPath(path string) *PromPath {
+	path = normalizePath(path)
+
+	for _, promPath := range entry.Paths {
+		if promPath.Path == path {
+			return promPath
+		}
+	}
+
+	return nil
+}
+
+// metricsPusher is implemented by PushGatewayPusher and MultiPushGatewayPusher
+type metricsPusher interface {
+	Start()
+	Stop()
+	PushNow(ctx context.Context) error
+	registerHealth(registerer prometheus.Registerer) error
+	isOneShot() bool
+}
+
+// startPusher registers health metrics of pusher into registerer, so that we could alert
+// if pusher stopped delivering metrics, and starts periodic job unless pusher is in one-shot mode
+func (entry *PromEntry) startPusher(registerer prometheus.Registerer, pusher metricsPusher) {
+	if err := pusher.registerHealth(registerer); err != nil {
+		entry.ZapLoggerEntry.GetLogger().Warn("failed to register pusher health collector", zap.Error(err))
+	}
+
+	if !pusher.isOneShot() {
+		pusher.Start()
+	}
+}
+
+// stopPusher stops periodic job, or pushes metrics once if pusher is in one-shot mode
+func (entry *PromEntry) stopPusher(ctx context.Context, pusher metricsPusher) {
+	if !pusher.isOneShot() {
+		pusher.Stop()
+		return
+	}
+
+	if err := pusher.PushNow(ctx); err != nil {
+		entry.ZapLoggerEntry.GetLogger().Warn("failed to push metrics to PushGateway", zap.Error(err))
+	}
+}
+
+// startExporter registers health metrics of exporter into registerer if exporter exposes them,
+// pushGateway pusher in one-shot mode would not be started
+func (entry *PromEntry) startExporter(registerer prometheus.Registerer, exporter Exporter) {
+	if pusher, ok := exporter.(metricsPusher); ok {
+		entry.startPusher(registerer, pusher)
+		return
+	}
+
+	if provider, ok := exporter.(healthCollectorProvider); ok {
+		if err := registerCollector(registerer, provider.HealthCollector()); err != nil {
+			entry.ZapLoggerEntry.GetLogger().Warn("failed to register exporter health collector", zap.Error(err))
+		}
+	}
+
+	exporter.Start()
+}
+
+// stopExporter stops exporter, pushGateway pusher in one-shot mode would push metrics once
+func (entry *PromEntry) stopExporter(ctx context.Context, exporter Exporter) {
+	if pusher, ok := exporter.(metricsPusher); ok {
+		entry.stopPusher(ctx, pusher)
+		return
+	}
+
+	exporter.Stop()
+}
+
+// exporters returns Exporters along with Pusher if it was assigned directly instead of via WithPusher
+func (entry *PromEntry) exporters() []Exporter {
+	if entry.Pusher == nil {
+		return entry.Exporters
+	}
+
+	for _, exporter := range entry.Exporters {
+		if exporter == Exporter(entry.Pusher) {
+			return entry.Exporters
+		}
+	}
+
+	return append([]Exporter{entry.Pusher}, entry.Exporters...)
+}
+
+// exporterTargets returns where pushers and exporters deliver metrics to
+func (entry *PromEntry) exporterTargets() []string {
+	exporters := entry.exporters()
+	res := make([]string, 0, len(exporters))
+	for _, exporter := range exporters {
+		res = append(res, exporterTarget(exporter))
+	}
+
+	return res
+}
+
 // Bootstrap will start prometheus client
 func (entry *PromEntry) Bootstrap(context.Context) {
 	event := entry.EventLoggerEntry.GetEventHelper().Start("bootstrap")
@@ -297,14 +992,21 @@ func (entry *PromEntry) Bootstrap(context.Context) {
 
 	httpMux := http.NewServeMux()
 
-	// if registry was provided, then use the one
-	if entry.Registry != nil {
-		// register process collector and go collector
-		entry.Registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
-		entry.Registry.MustRegister(prometheus.NewGoCollector())
-		httpMux.Handle(entry.Path, promhttp.HandlerFor(entry.Registry, promhttp.HandlerOpts{}))
-	} else {
-		httpMux.Handle(entry.Path, promhttp.Handler())
+	// register default collectors, collectors registered previously would be ignored
+	if err := entry.registerDefaultCollectors(); err != nil {
+		entry.ZapLoggerEntry.GetLogger().Warn("failed to register default collectors", zap.Error(err))
+	}
+
+	handler := promhttp.HandlerFor(entry.Gatherer, promhttp.HandlerOpts{})
+	// instrument handler as promhttp.Handler() does if registry was not provided
+	if entry.Registry == nil {
+		handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+	}
+	httpMux.Handle(entry.Path, newMetricsHandler(entry.Gatherer, handler))
+
+	for _, promPath := range entry.Paths {
+		httpMux.Handle(promPath.Path, newMetricsHandler(promPath.Gatherer, promhttp.HandlerFor(promPath.Gatherer, promPath.HandlerOpts)))
+		fields = append(fields, zap.String("extraPromPath", promPath.Path))
 	}
 
 	entry.Server = &http.Server{
@@ -340,21 +1042,27 @@ func (entry *PromEntry) Bootstrap(context.Context) {
 		}
 	}(entry)
 
-	// start pusher
-	if entry.Pusher != nil {
-		fields = append(fields,
-			zap.Bool("pusher", true),
-			zap.String("remoteAddress", entry.Pusher.RemoteAddress),
-			zap.String("jobName", entry.Pusher.JobName),
-			zap.Int64("intervalMs", entry.Pusher.IntervalMs.Milliseconds()))
-		entry.Pusher.Start()
+	// start pushers and exporters
+	if len(entry.exporters()) > 0 {
+		fields = append(fields, zap.Strings("exporters", entry.exporterTargets()))
+	}
+
+	for _, exporter := range entry.exporters() {
+		entry.startExporter(entry.Registerer, exporter)
+	}
+
+	// start pushers of additional paths
+	for _, promPath := range entry.Paths {
+		if promPath.Pusher != nil {
+			entry.startPusher(promPath.Registerer, promPath.Pusher)
+		}
 	}
 
 	event.AddPayloads(fields...)
 }
 
 // Interrupt will Shutdown prometheus client
-func (entry *PromEntry) Interrupt(context.Context) {
+func (entry *PromEntry) Interrupt(ctx context.Context) {
 	event := entry.EventLoggerEntry.GetEventHelper().Start("interrupt")
 
 	fields := []zap.Field{
@@ -362,14 +1070,18 @@ func (entry *PromEntry) Interrupt(context.Context) {
 		zap.Uint64("promPort", entry.Port),
 	}
 
-	if entry.Pusher != nil {
-		fields = append(fields,
-			zap.Bool("pusher", true),
-			zap.String("remoteAddress", entry.Pusher.RemoteAddress),
-			zap.String("jobName", entry.Pusher.JobName),
-			zap.Int64("intervalMs", entry.Pusher.IntervalMs.Milliseconds()))
+	if len(entry.exporters()) > 0 {
+		fields = append(fields, zap.Strings("exporters", entry.exporterTargets()))
+	}
 
-		entry.Pusher.Stop()
+	for _, exporter := range entry.exporters() {
+		entry.stopExporter(ctx, exporter)
+	}
+
+	for _, promPath := range entry.Paths {
+		if promPath.Pusher != nil {
+			entry.stopPusher(ctx, promPath.Pusher)
+		}
 	}
 
 	event.AddPayloads(fields...)
@@ -405,11 +1117,15 @@ func (entry *PromEntry) String() string {
 	}
 
 	if entry.Pusher != nil {
-		m["pusherRemoteAddr"] = entry.Pusher.RemoteAddress
+		m["pusherRemoteAddr"] = entry.Pusher.target()
 		m["pusherIntervalMs"] = entry.Pusher.IntervalMs
 		m["pusherJobName"] = entry.Pusher.JobName
 	}
 
+	if len(entry.exporters()) > 0 {
+		m["exporters"] = entry.exporterTargets()
+	}
+
 	bytes, _ := json.Marshal(m)
 
 	return string(bytes)
@@ -427,10 +1143,12 @@ func (entry *PromEntry) MarshalJSON() ([]byte, error) {
 		"entryType":         entry.EntryType,
 		"entryDescription":  entry.EntryDescription,
 		"pushGateWayPusher": entry.Pusher,
+		"exporters":         entry.Exporters,
 		"eventLoggerEntry":  entry.EventLoggerEntry.GetName(),
 		"zapLoggerEntry":    entry.ZapLoggerEntry.GetName(),
 		"port":              entry.Port,
 		"path":              entry.Path,
+		"paths":             entry.Paths,
 	}
 
 	return json.Marshal(&m)
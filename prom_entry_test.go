@@ -10,8 +10,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rookie-ninja/rk-entry/entry"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
@@ -117,6 +120,7 @@ func TestWithPusher_HappyCase(t *testing.T) {
 
 	entry := RegisterPromEntry(WithPusher(pusher))
 	assert.Equal(t, pusher, entry.Pusher)
+	assert.Equal(t, []Exporter{pusher}, entry.Exporters)
 }
 
 func TestRegisterPromEntriesWithConfig_WithEmptyString(t *testing.T) {
@@ -182,11 +186,13 @@ func TestRegisterPromEntriesWithConfig_WithNilEventFactory(t *testing.T) {
 	assert.Equal(t, "/metrics", entry.Path)
 	assert.NotNil(t, entry.ZapLoggerEntry)
 	assert.NotNil(t, entry.EventLoggerEntry)
-	assert.NotNil(t, entry.Pusher)
-	assert.Equal(t, time.Duration(1000)*time.Millisecond, entry.Pusher.IntervalMs)
-	assert.Equal(t, "rk-job", entry.Pusher.JobName)
-	assert.Equal(t, "localhost:9091", entry.Pusher.RemoteAddress)
-	assert.Equal(t, "user:pass", entry.Pusher.Credential)
+	assert.Len(t, entry.Exporters, 1)
+	pusher := entry.Exporters[0].(*PushGatewayPusher)
+	assert.Equal(t, pusher, entry.Pusher)
+	assert.Equal(t, time.Duration(1000)*time.Millisecond, pusher.IntervalMs)
+	assert.Equal(t, "rk-job", pusher.JobName)
+	assert.Equal(t, "localhost:9091", pusher.RemoteAddress)
+	assert.Equal(t, "user:pass", pusher.Credential)
 }
 
 func TestRegisterPromEntriesWithConfig_WithNilLogger(t *testing.T) {
@@ -224,11 +230,12 @@ func TestRegisterPromEntriesWithConfig_WithNilLogger(t *testing.T) {
 	assert.Equal(t, "/metrics", entry.Path)
 	assert.NotNil(t, entry.ZapLoggerEntry)
 	assert.NotNil(t, entry.EventLoggerEntry)
-	assert.NotNil(t, entry.Pusher)
-	assert.Equal(t, time.Duration(1000)*time.Millisecond, entry.Pusher.IntervalMs)
-	assert.Equal(t, "rk-job", entry.Pusher.JobName)
-	assert.Equal(t, "localhost:9091", entry.Pusher.RemoteAddress)
-	assert.Equal(t, "user:pass", entry.Pusher.Credential)
+	assert.Len(t, entry.Exporters, 1)
+	pusher := entry.Exporters[0].(*PushGatewayPusher)
+	assert.Equal(t, time.Duration(1000)*time.Millisecond, pusher.IntervalMs)
+	assert.Equal(t, "rk-job", pusher.JobName)
+	assert.Equal(t, "localhost:9091", pusher.RemoteAddress)
+	assert.Equal(t, "user:pass", pusher.Credential)
 }
 
 func TestRegisterPromEntriesWithConfig_HappyCase(t *testing.T) {
@@ -266,16 +273,17 @@ func TestRegisterPromEntriesWithConfig_HappyCase(t *testing.T) {
 	assert.Equal(t, "/metrics", entry.Path)
 	assert.NotNil(t, entry.ZapLoggerEntry)
 	assert.NotNil(t, entry.EventLoggerEntry)
-	assert.NotNil(t, entry.Pusher)
-	assert.Equal(t, time.Duration(1000)*time.Millisecond, entry.Pusher.IntervalMs)
-	assert.Equal(t, "rk-job", entry.Pusher.JobName)
-	assert.Equal(t, "localhost:9091", entry.Pusher.RemoteAddress)
-	assert.Equal(t, "user:pass", entry.Pusher.Credential)
+	assert.Len(t, entry.Exporters, 1)
+	pusher := entry.Exporters[0].(*PushGatewayPusher)
+	assert.Equal(t, time.Duration(1000)*time.Millisecond, pusher.IntervalMs)
+	assert.Equal(t, "rk-job", pusher.JobName)
+	assert.Equal(t, "localhost:9091", pusher.RemoteAddress)
+	assert.Equal(t, "user:pass", pusher.Credential)
 }
 
 func TestRegisterPromEntry_WithDefault(t *testing.T) {
 	entry := RegisterPromEntry()
-	assert.Nil(t, entry.Pusher)
+	assert.Empty(t, entry.Exporters)
 	assert.NotNil(t, entry.ZapLoggerEntry)
 	assert.NotNil(t, entry.EventLoggerEntry)
 	assert.Equal(t, defaultPort, entry.Port)
@@ -291,7 +299,7 @@ func TestRegisterPromEntry_HappyCase(t *testing.T) {
 		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()))
 	assert.NotNil(t, entry)
 
-	assert.Nil(t, entry.Pusher)
+	assert.Empty(t, entry.Exporters)
 	assert.Equal(t, defaultPort, entry.Port)
 	assert.Equal(t, defaultPath, entry.Path)
 	assert.Equal(t, PromEntryNameDefault, entry.EntryName)
@@ -307,7 +315,7 @@ func TestRegisterPromEntry_WithPort(t *testing.T) {
 
 	assert.NotNil(t, entry)
 
-	assert.Nil(t, entry.Pusher)
+	assert.Empty(t, entry.Exporters)
 	assert.Equal(t, uint64(2021), entry.Port)
 	assert.Equal(t, defaultPath, entry.Path)
 	assert.Equal(t, PromEntryNameDefault, entry.EntryName)
@@ -322,7 +330,7 @@ func TestRegisterPromEntry_WithPath(t *testing.T) {
 		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()))
 	assert.NotNil(t, entry)
 
-	assert.Nil(t, entry.Pusher)
+	assert.Empty(t, entry.Exporters)
 	assert.Equal(t, defaultPort, entry.Port)
 	assert.Equal(t, "/path", entry.Path)
 	assert.Equal(t, PromEntryNameDefault, entry.EntryName)
@@ -347,7 +355,7 @@ func TestRegisterPromEntry_WithPusher(t *testing.T) {
 		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()))
 	assert.NotNil(t, entry)
 
-	assert.Equal(t, pusher, entry.Pusher)
+	assert.Equal(t, []Exporter{pusher}, entry.Exporters)
 	assert.Equal(t, defaultPort, entry.Port)
 	assert.Equal(t, defaultPath, entry.Path)
 	assert.Equal(t, PromEntryNameDefault, entry.EntryName)
@@ -437,7 +445,7 @@ func TestPromEntry_String_HappyCase(t *testing.T) {
 	entry := entries[PromEntryNameDefault]
 	assert.NotNil(t, entry)
 	assert.NotEmpty(t, entry.String())
-	assert.NotEmpty(t, entry.(*PromEntry).Pusher.String())
+	assert.NotEmpty(t, entry.(*PromEntry).Exporters[0].(*PushGatewayPusher).String())
 }
 
 func TestPromEntry_GetDescription_HappyCase(t *testing.T) {
@@ -494,3 +502,232 @@ func validateServerIsDown(t *testing.T, port uint64) {
 	assert.NotNil(t, err)
 	assert.Nil(t, conn)
 }
+
+func TestPromEntry_Bootstrap_WithPusherHealth(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher("job"),
+		WithZapLoggerEntryPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithPusher(pusher))
+	entry.Bootstrap(context.Background())
+	defer entry.Interrupt(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	names := gatherNames(t, entry.Gatherer)
+	assert.True(t, names["rk_pusher_pushes_total"])
+	assert.True(t, names["rk_pusher_last_success_timestamp_seconds"])
+}
+
+func TestPromEntry_Bootstrap_WithPusherAssigned(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher("job"),
+		WithZapLoggerEntryPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	// pusher assigned directly would be started as well
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()))
+	entry.Pusher = pusher
+	pusher.SetGatherer(entry.Gatherer)
+	assert.Contains(t, entry.String(), gateway.URL)
+
+	entry.Bootstrap(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, pusher.IsRunning())
+	assert.True(t, gatherNames(t, entry.Gatherer)["rk_pusher_pushes_total"])
+	assert.NotEmpty(t, gateway.getRequests())
+
+	entry.Interrupt(context.Background())
+	assert.False(t, pusher.IsRunning())
+}
+
+func TestPromEntry_Bootstrap_WithBuiltInExporters(t *testing.T) {
+	receiver := newRemoteWriteReceiver(t)
+	defer receiver.Close()
+	collector := newOTLPCollectorMock(t)
+	defer collector.Close()
+	influxReceiver := newInfluxReceiver(t)
+	defer influxReceiver.Close()
+	graphite := newGraphiteMock(t)
+	defer graphite.listener.Close()
+
+	remoteWrite, err := NewRemoteWriteExporter(
+		WithURLRemoteWrite(receiver.URL),
+		WithIntervalMSRemoteWrite(time.Second),
+		WithZapLoggerEntryRemoteWrite(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryRemoteWrite(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	otlp, err := NewOTLPExporter(
+		WithEndpointOTLP(collector.URL),
+		WithIntervalMSOTLP(time.Second),
+		WithZapLoggerEntryOTLP(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryOTLP(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	influx, err := NewInfluxExporter(
+		WithURLInflux(influxReceiver.URL),
+		WithBucketInflux("ut-org", "ut-bucket"),
+		WithIntervalMSInflux(time.Second),
+		WithZapLoggerEntryInflux(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryInflux(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	bridge, err := NewBridgeExporter(
+		WithProtocolBridge(BridgeProtocolGraphite),
+		WithAddressBridge(graphite.listener.Addr().String()),
+		WithIntervalMSBridge(time.Second),
+		WithZapLoggerEntryBridge(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryBridge(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithRemoteWriteExporter(remoteWrite),
+		WithOTLPExporter(otlp),
+		WithInfluxExporter(influx),
+		WithBridgeExporters(bridge, nil),
+		// nil exporters would be ignored
+		WithPusher(nil),
+		WithMultiPusher(nil))
+	assert.Len(t, entry.Exporters, 4)
+
+	for _, exporter := range entry.Exporters {
+		exporter.(gathererSetter).SetGatherer(entry.Gatherer)
+	}
+	entry.Bootstrap(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	names := gatherNames(t, entry.Gatherer)
+	for _, name := range []string{"rk_remote_write_pushes_total", "rk_otlp_pushes_total", "rk_influx_pushes_total", "rk_bridge_pushes_total"} {
+		assert.True(t, names[name], name)
+	}
+
+	assert.True(t, remoteWrite.IsRunning())
+	assert.NotNil(t, receiver.find(map[string]string{"__name__": "rk_remote_write_pushes_total"}))
+	assert.NotEmpty(t, collector.getRequests())
+	assert.NotEmpty(t, influxReceiver.getRequests())
+	assert.NotEmpty(t, graphite.getLines())
+
+	entry.Interrupt(context.Background())
+	assert.False(t, remoteWrite.IsRunning())
+	assert.False(t, otlp.IsRunning())
+	assert.False(t, influx.IsRunning())
+	assert.False(t, bridge.IsRunning())
+}
+
+func TestPromEntry_Interrupt_WithOneShotPusher(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(10*time.Millisecond),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher("job"),
+		WithOneShotPusher(true),
+		WithZapLoggerEntryPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithPusher(pusher))
+	entry.Bootstrap(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	// nothing would be pushed periodically
+	assert.False(t, pusher.IsRunning())
+	assert.Empty(t, gateway.getRequests())
+
+	entry.Interrupt(context.Background())
+	assert.Len(t, gateway.getRequests(), 1)
+	assert.Equal(t, http.MethodPut, gateway.getRequests()[0].method)
+}
+
+func TestWithMultiPusher_HappyCase(t *testing.T) {
+	pusher, err := NewMultiPushGatewayPusher(
+		WithTargetsMultiPusher(newTargetPusher(t, remoteAddr)),
+		WithZapLoggerEntryMultiPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryMultiPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	entry := RegisterPromEntry(WithMultiPusher(pusher))
+	assert.Equal(t, []Exporter{pusher}, entry.Exporters)
+	assert.Contains(t, entry.String(), remoteAddr)
+}
+
+func TestRegisterPromEntriesWithConfig_WithInvalidSections(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	rkentry.RegisterZapLoggerEntry(
+		rkentry.WithNameZap("ut-observer"),
+		rkentry.WithLoggerZap(zap.New(core), nil, nil))
+
+	bootFileWithInvalidSections := `
+---
+prom:
+  enabled: true
+  logger:
+    zapLogger:
+      ref: ut-observer
+  pusher:
+    enabled: true
+    intervalMs: 1000
+    jobName: ut-job
+    remoteAddress: localhost:9091
+    tls:
+      caFile: /ut/not/exist/ca.pem
+  remoteWrite:
+    enabled: true
+  otlp:
+    enabled: true
+    endpoint: "http://localhost:4318/v1/metrics"
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithInvalidSections), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	// invalid sections would be skipped and the rest would keep working
+	assert.Len(t, entry.Exporters, 1)
+	assert.IsType(t, &OTLPExporter{}, entry.Exporters[0])
+
+	// causes would be logged
+	logged := logs.FilterMessage("invalid prom config, skipping").AllUntimed()
+	assert.Len(t, logged, 2)
+	assert.Equal(t, "pusher", logged[0].ContextMap()["section"])
+	assert.Contains(t, logged[0].ContextMap()["error"], "failed to read TLS caFile")
+	assert.Equal(t, "remoteWrite", logged[1].ContextMap()["section"])
+	assert.Equal(t, "empty remote write url", logged[1].ContextMap()["error"])
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strings"
+	"time"
+)
+
+// BootConfigHandler is handler options of a path served by prom entry.
+//
+// 1: DisableCompression: Disable gzip compression of response.
+// 2: MaxRequestsInFlight: Maximum number of concurrent scrape requests, 0 means no limit.
+// 3: TimeoutMs: Timeout of gathering metrics in milliseconds, 0 means no timeout.
+// 4: ContinueOnError: Serve metrics which were gathered successfully while error occurs.
+// 5: EnableOpenMetrics: Serve OpenMetrics format if client asked for it.
+type BootConfigHandler struct {
+	DisableCompression  bool  `yaml:"disableCompression" json:"disableCompression"`
+	MaxRequestsInFlight int   `yaml:"maxRequestsInFlight" json:"maxRequestsInFlight"`
+	TimeoutMs           int64 `yaml:"timeoutMs" json:"timeoutMs"`
+	ContinueOnError     bool  `yaml:"continueOnError" json:"continueOnError"`
+	EnableOpenMetrics   bool  `yaml:"enableOpenMetrics" json:"enableOpenMetrics"`
+}
+
+// BootConfigPath is an additional path served by prom entry.
+//
+// 1: Path: Exposed path, required.
+// 2: Handler: Handler options of path.
+// 3: Pusher: Pushgateway pusher of path.
+type BootConfigPath struct {
+	Path    string            `yaml:"path" json:"path"`
+	Handler BootConfigHandler `yaml:"handler" json:"handler"`
+	Pusher  BootConfigPusher  `yaml:"pusher" json:"pusher"`
+}
+
+// PromPath is an additional path served by prom entry which is bound to its own gatherer.
+// Metrics registered into it would not be exposed via main path of prom entry.
+//
+// 1: Path:        Exposed path
+// 2: Registry:    Prometheus registry, Registerer and Gatherer would be assigned with it if provided
+// 3: Registerer:  Prometheus registerer
+// 4: Gatherer:    Prometheus gatherer
+// 5: HandlerOpts: Options of promhttp handler
+// 6: Pusher:      Periodic pushGateway pusher which pushes metrics in Gatherer
+type PromPath struct {
+	Path        string                `json:"path" yaml:"path"`
+	Registry    *prometheus.Registry  `json:"-" yaml:"-"`
+	Registerer  prometheus.Registerer `json:"-" yaml:"-"`
+	Gatherer    prometheus.Gatherer   `json:"-" yaml:"-"`
+	HandlerOpts promhttp.HandlerOpts  `json:"-" yaml:"-"`
+	Pusher      *PushGatewayPusher    `json:"pushGatewayPusher" yaml:"pushGatewayPusher"`
+}
+
+// NewPromPath creates a path with a new prometheus registry.
+// Pusher would be assigned with Gatherer while registering prom entry.
+func NewPromPath(path string, handlerOpts promhttp.HandlerOpts, pusher *PushGatewayPusher) *PromPath {
+	registry := prometheus.NewRegistry()
+
+	promPath := &PromPath{
+		Path:        normalizePath(path),
+		Registry:    registry,
+		Registerer:  registry,
+		Gatherer:    registry,
+		HandlerOpts: handlerOpts,
+		Pusher:      pusher,
+	}
+
+	return promPath
+}
+
+// Internal use only
+func newHandlerOptsFromConfig(config *BootConfigHandler) promhttp.HandlerOpts {
+	opts := promhttp.HandlerOpts{
+		DisableCompression:  config.DisableCompression,
+		MaxRequestsInFlight: config.MaxRequestsInFlight,
+		Timeout:             time.Duration(config.TimeoutMs) * time.Millisecond,
+		EnableOpenMetrics:   config.EnableOpenMetrics,
+	}
+
+	if config.ContinueOnError {
+		opts.ErrorHandling = promhttp.ContinueOnError
+	}
+
+	return opts
+}
+
+// Internal use only
+func normalizePath(path string) string {
+	path = strings.TrimSpace(path)
+
+	if len(path) < 1 {
+		return ""
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const bootFileWithPaths = `
+---
+prom:
+  enabled: true
+  port: 1608
+  path: metrics
+  paths:
+    - path: metrics/debug
+      handler:
+        maxRequestsInFlight: 2
+        timeoutMs: 1000
+        continueOnError: true
+      pusher:
+        enabled: true
+        intervalMS: 1000
+        jobName: "rk-debug-job"
+        remoteAddress: "localhost:9091"
+`
+
+func TestNewPromPath_HappyCase(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher("localhost"),
+		WithJobNamePusher("job"),
+		WithZapLoggerEntryPusher(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntryPusher(rkentry.NoopEventLoggerEntry()))
+	assert.Nil(t, err)
+
+	promPath := NewPromPath("metrics/debug", promhttp.HandlerOpts{}, pusher)
+	assert.Equal(t, "/metrics/debug", promPath.Path)
+	assert.NotNil(t, promPath.Registry)
+	assert.Equal(t, promPath.Registry, promPath.Registerer)
+	assert.Equal(t, promPath.Registry, promPath.Gatherer)
+	assert.Equal(t, pusher, promPath.Pusher)
+}
+
+func TestNewHandlerOptsFromConfig_HappyCase(t *testing.T) {
+	opts := newHandlerOptsFromConfig(&BootConfigHandler{
+		DisableCompression:  true,
+		MaxRequestsInFlight: 2,
+		TimeoutMs:           1000,
+		ContinueOnError:     true,
+	})
+
+	assert.True(t, opts.DisableCompression)
+	assert.Equal(t, 2, opts.MaxRequestsInFlight)
+	assert.Equal(t, time.Second, opts.Timeout)
+	assert.Equal(t, promhttp.ContinueOnError, opts.ErrorHandling)
+}
+
+func TestRegisterPromEntry_WithDuplicatePromPath(t *testing.T) {
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromPath(NewPromPath("metrics", promhttp.HandlerOpts{}, nil)),
+		WithPromPath(NewPromPath("metrics/debug", promhttp.HandlerOpts{}, nil)),
+		WithPromPath(NewPromPath("/metrics/debug", promhttp.HandlerOpts{}, nil)),
+		WithPromPath(&PromPath{Path: "no-gatherer"}))
+
+	assert.Len(t, entry.Paths, 1)
+	assert.NotNil(t, entry.GetPromPath("metrics/debug"))
+	assert.Nil(t, entry.GetPromPath("no-gatherer"))
+}
+
+func TestRegisterPromEntriesWithConfig_WithPaths(t *testing.T) {
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithPaths), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.Paths, 1)
+	promPath := entry.GetPromPath("/metrics/debug")
+	assert.NotNil(t, promPath)
+	assert.Equal(t, 2, promPath.HandlerOpts.MaxRequestsInFlight)
+	assert.Equal(t, time.Second, promPath.HandlerOpts.Timeout)
+	assert.NotNil(t, promPath.Pusher)
+	assert.Equal(t, "rk-debug-job", promPath.Pusher.JobName)
+	assert.Empty(t, entry.Exporters)
+}
+
+func TestPromEntry_Bootstrap_WithPromPath(t *testing.T) {
+	promPath := NewPromPath("metrics/debug", promhttp.HandlerOpts{}, nil)
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(prometheus.NewRegistry()),
+		WithPromPath(promPath))
+
+	set := NewMetricsSet("ut", "debug", promPath.Registerer)
+	assert.Nil(t, set.RegisterCounter("counter"))
+	set.GetCounterWithValues("counter").Inc()
+
+	entry.Bootstrap(context.Background())
+	defer entry.Interrupt(context.Background())
+
+	// wait for 100 milliseconds for prom client start
+	time.Sleep(100 * time.Millisecond)
+
+	debug := scrape(t, entry.Port, "/metrics/debug")
+	assert.Contains(t, debug, "ut_debug_counter 1")
+
+	main := scrape(t, entry.Port, "/metrics")
+	assert.NotContains(t, main, "ut_debug_counter")
+}
+
+func scrape(t *testing.T, port uint64, path string) string {
+	resp, err := http.Get("http://localhost:" + strconv.FormatUint(port, 10) + path)
+	assert.Nil(t, err)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	bytes, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+
+	return string(bytes)
+}
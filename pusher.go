@@ -6,21 +6,51 @@
 package rkprom
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/rookie-ninja/rk-entry/entry"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// PushModePush uses PUT method which replaces all metrics of the group
+	PushModePush = "push"
+	// PushModeAdd uses POST method which only replaces metrics with the same name in the group,
+	// so that several processes could contribute to one group
+	PushModeAdd = "add"
+	// PushFormatProtobuf encodes metrics with delimited protobuf format
+	PushFormatProtobuf = "protobuf"
+	// PushFormatText encodes metrics with text format of version 0.0.4,
+	// which could be used if proxies in front of pushGateway could not handle protobuf
+	PushFormatText = "text"
+	// PusherMetricsPrefix is prefix of health metrics of pusher
+	PusherMetricsPrefix = "rk_pusher"
+)
+
+// pushFormats maps push formats to encoding formats accepted by pushGateway
+var pushFormats = map[string]expfmt.Format{
+	PushFormatProtobuf: expfmt.FmtProtoDelim,
+	PushFormatText:     expfmt.FmtText,
+}
+
 // PushGatewayPusher is a pusher which contains bellow instances
 // thread safe
 //
@@ -32,8 +62,21 @@ import (
 //                     schema in the URL. However, do not include the “/metrics/jobs/…” part.
 // 5: jobName:         job name of periodic job
 // 6: isRunning:       a boolean flag for validating status of periodic job
-// 7: lock:            a mutex lock for thread safety
+// 7: lock:            a mutex lock for thread safety of Start, Stop and Reconfigure, pushes are serialized by pushLock
 // 8: credential:      basic auth credential
+// 9: grouping:        grouping labels attached to the URL while pushing to remote pushGateway
+// 10: mode:           push mode, one of push and add
+// 11: deleteOnStop:   delete the group from remote pushGateway while stopping periodic job
+// 12: jitter:         fraction of interval which would be randomized in every cycle
+// 13: retrier:        retries failed pushes with exponential backoff in every cycle
+// 14: health:         health of pushes which could be exposed as metrics
+// 15: oneShot:        push once while stopping prom entry instead of pushing periodically
+// 16: auth:           basic auth, bearer token and extra headers attached to every request
+// 17: tls:            CA bundle, client certificate, server name and minimum version of TLS
+// 18: httpClient:     timeout, proxy, keep-alive and compression of http client
+// 19: spool:          durable on-disk buffer of failed payloads which would be replayed in order
+// 20: filter:         include and exclude regexes of metric names and label matchers applied to gathered metrics
+// 21: format:         encoding format of pushed metrics, one of protobuf and text
 type PushGatewayPusher struct {
 	ZapLoggerEntry   *rkentry.ZapLoggerEntry   `json:"zapLoggerEntry" yaml:"zapLoggerEntry"`
 	EventLoggerEntry *rkentry.EventLoggerEntry `json:"eventLoggerEntry" yaml:"eventLoggerEntry"`
@@ -44,7 +87,41 @@ type PushGatewayPusher struct {
 	JobName          string                    `json:"jobName" yaml:"jobName"`
 	Running          *atomic.Bool              `json:"running" yaml:"running"`
 	lock             *sync.Mutex               `json:"-" yaml:"-"`
+	pushLock         *sync.Mutex               `json:"-" yaml:"-"`
+	job              *periodicJob              `json:"-" yaml:"-"`
+	doer             *contextDoer              `json:"-" yaml:"-"`
 	Credential       string                    `json:"-" yaml:"-"`
+	Grouping         map[string]string         `json:"grouping" yaml:"grouping"`
+	Mode             string                    `json:"mode" yaml:"mode"`
+	Format           string                    `json:"format" yaml:"format"`
+	DeleteOnStop     bool                      `json:"deleteOnStop" yaml:"deleteOnStop"`
+	Jitter           float64                   `json:"jitter" yaml:"jitter"`
+	retrier          *retrier                  `json:"-" yaml:"-"`
+	health           *healthTracker            `json:"-" yaml:"-"`
+	OneShot          bool                      `json:"oneShot" yaml:"oneShot"`
+	Auth             *AuthConfig               `json:"auth" yaml:"auth"`
+	TLS              *TLSConfig                `json:"tls" yaml:"tls"`
+	HTTPClient       *HTTPClientConfig         `json:"httpClient" yaml:"httpClient"`
+	client           *http.Client              `json:"-" yaml:"-"`
+	roundTripper     http.RoundTripper         `json:"-" yaml:"-"`
+	SpoolConfig      *BootConfigSpool          `json:"spool" yaml:"spool"`
+	spool            *spool                    `json:"-" yaml:"-"`
+	Filter           *FilterConfig             `json:"filter" yaml:"filter"`
+	filter           *metricsFilter            `json:"-" yaml:"-"`
+	gatherer         prometheus.Gatherer       `json:"-" yaml:"-"`
+	published        *swappableGatherer        `json:"-" yaml:"-"`
+	settingsLock     *sync.RWMutex             `json:"-" yaml:"-"`
+	registerers      []prometheus.Registerer   `json:"-" yaml:"-"`
+}
+
+// pusherSettings is a snapshot of settings which could be swapped by Reconfigure while pushing
+type pusherSettings struct {
+	remoteAddress string
+	jobName       string
+	intervalMs    time.Duration
+	jitter        float64
+	retrier       *retrier
+	deleteOnStop  bool
 }
 
 // PushGatewayPusherOption is used while initializing push gateway pusher via code
@@ -71,13 +148,144 @@ func WithJobNamePusher(jobName string) PushGatewayPusherOption {
 	}
 }
 
-// WithBasicAuthPusher provides basic auth of pushgateway
+// WithBasicAuthPusher provides basic auth of pushgateway with form of user:pass,
+// everything after the first colon is the password
 func WithBasicAuthPusher(cred string) PushGatewayPusherOption {
 	return func(pusher *PushGatewayPusher) {
 		pusher.Credential = cred
 	}
 }
 
+// WithAuthPusher provides auth of pushgateway, credentials could be loaded from files or environment variables
+// and would be re-read once rotated. Credential of WithBasicAuthPusher would be used if basic auth was not provided.
+func WithAuthPusher(auth *AuthConfig) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.Auth = auth
+	}
+}
+
+// WithTLSPusher provides TLS config of pushgateway, https:// would be prepended to remote address if scheme is missing
+func WithTLSPusher(config *TLSConfig) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.TLS = config
+	}
+}
+
+// WithHTTPClientConfigPusher provides timeout, proxy, keep-alive and compression of http client
+func WithHTTPClientConfigPusher(config *HTTPClientConfig) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.HTTPClient = config
+	}
+}
+
+// WithHTTPClientPusher provides a custom http client which would be used as it is, auth would still be attached.
+// TLS and HTTPClientConfig except compression would be ignored, TLS could not be used along with custom http client.
+func WithHTTPClientPusher(client *http.Client) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.client = client
+	}
+}
+
+// WithRoundTripperPusher provides a custom round tripper which replaces transport built from HTTPClientConfig,
+// auth would still be attached. TLS could not be used along with custom round tripper.
+func WithRoundTripperPusher(roundTripper http.RoundTripper) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.roundTripper = roundTripper
+	}
+}
+
+// WithSpoolPusher writes failed payloads into directory, payloads would be replayed in order before next push
+// once pushGateway comes back. Oldest payloads would be removed once total size exceeded maxBytes or they are
+// older than maxAge. Every pusher should have its own directory.
+func WithSpoolPusher(dir string, maxBytes int64, maxAge time.Duration) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.SpoolConfig = &BootConfigSpool{
+			Dir:      dir,
+			MaxBytes: maxBytes,
+			MaxAgeMs: maxAge.Milliseconds(),
+		}
+	}
+}
+
+// WithFilterPusher provides filter applied to gathered metrics before pushing,
+// i.e. exclude go_.* and process_.* from a shared pushGateway
+func WithFilterPusher(config *FilterConfig) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.Filter = config
+	}
+}
+
+// WithGroupingPusher provides grouping labels of pushgateway, i.e. instance or pod.
+// Values could be expanded from environment variables with form of $VAR or ${VAR}, and $HOSTNAME
+// would be expanded with os.Hostname() if it was not defined in environment variables.
+func WithGroupingPusher(grouping map[string]string) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		if pusher.Grouping == nil {
+			pusher.Grouping = make(map[string]string)
+		}
+
+		for k, v := range grouping {
+			pusher.Grouping[k] = v
+		}
+	}
+}
+
+// WithModePusher provides push mode, one of push and add, push by default.
+//
+// push: Use PUT method which replaces all metrics of the group.
+// add:  Use POST method which only replaces metrics with the same name in the group.
+func WithModePusher(mode string) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.Mode = mode
+	}
+}
+
+// WithFormatPusher provides encoding format of pushed metrics, one of protobuf and text, protobuf by default.
+//
+// protobuf: Use delimited protobuf format with Content-Type of application/vnd.google.protobuf.
+// text:     Use text format of version 0.0.4 with Content-Type of text/plain.
+func WithFormatPusher(format string) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.Format = format
+	}
+}
+
+// WithDeleteOnStopPusher deletes the group from remote pushGateway while stopping periodic job,
+// so that metrics of finished batch jobs would not be left on pushGateway
+func WithDeleteOnStopPusher(deleteOnStop bool) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.DeleteOnStop = deleteOnStop
+	}
+}
+
+// WithRetryPusher retries failed pushes with exponential backoff and full jitter in every cycle.
+//
+// 1: maxRetries:     maximum retries in every cycle, 0 means no retry
+// 2: initialBackoff: backoff before the first retry, doubled for every retry, 100ms by default
+// 3: maxBackoff:     maximum backoff, 5s by default
+// 4: maxElapsed:     retries would not be scheduled after elapsed, bounded by interval
+func WithRetryPusher(maxRetries int, initialBackoff, maxBackoff, maxElapsed time.Duration) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.retrier = newRetrier(maxRetries, initialBackoff, maxBackoff, maxElapsed)
+	}
+}
+
+// WithJitterPusher randomizes interval of every cycle in range of [interval*(1-jitter), interval*(1+jitter)),
+// so that replicas would not push at the same time. Jitter should be in range of (0, 1].
+func WithJitterPusher(jitter float64) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.Jitter = jitter
+	}
+}
+
+// WithOneShotPusher makes prom entry push metrics once while interrupting instead of starting periodic job,
+// which is useful for batch jobs. DeleteOnStop would be ignored in one-shot mode.
+func WithOneShotPusher(oneShot bool) PushGatewayPusherOption {
+	return func(pusher *PushGatewayPusher) {
+		pusher.OneShot = oneShot
+	}
+}
+
 // WithZapLoggerEntryPusher provides ZapLoggerEntry
 func WithZapLoggerEntryPusher(zapLoggerEntry *rkentry.ZapLoggerEntry) PushGatewayPusherOption {
 	return func(pusher *PushGatewayPusher) {
@@ -92,7 +300,8 @@ func WithEventLoggerEntryPusher(eventLoggerEntry *rkentry.EventLoggerEntry) Push
 	}
 }
 
-// WithCertStorePusher provides EventLoggerEntry
+// WithCertStorePusher provides cert store, ServerCert would be trusted and ClientCert along with ClientKey
+// would be used as client certificate
 func WithCertStorePusher(certStore *rkentry.CertStore) PushGatewayPusherOption {
 	return func(pusher *PushGatewayPusher) {
 		pusher.CertStore = certStore
@@ -110,7 +319,13 @@ func NewPushGatewayPusher(opts ...PushGatewayPusherOption) (*PushGatewayPusher,
 		ZapLoggerEntry:   rkentry.GlobalAppCtx.GetZapLoggerEntryDefault(),
 		EventLoggerEntry: rkentry.GlobalAppCtx.GetEventLoggerEntryDefault(),
 		IntervalMs:       1 * time.Second,
+		Mode:             PushModePush,
+		Format:           PushFormatProtobuf,
+		retrier:          newRetrier(0, 0, 0, 0),
 		lock:             &sync.Mutex{},
+		pushLock:         &sync.Mutex{},
+		settingsLock:     &sync.RWMutex{},
+		job:              &periodicJob{},
 		Running:          atomic.NewBool(false),
 	}
 
@@ -122,13 +337,17 @@ func NewPushGatewayPusher(opts ...PushGatewayPusherOption) (*PushGatewayPusher,
 		return nil, errors.New("invalid intervalMs")
 	}
 
+	if pg.Jitter < 0 || pg.Jitter > 1 {
+		return nil, errors.New(fmt.Sprintf("invalid jitter:%v", pg.Jitter))
+	}
+
 	if len(pg.RemoteAddress) < 1 {
 		return nil, errors.New("empty remoteAddress")
 	}
 
-	// certificate was provided, we need to use https for remote address
-	if pg.CertStore != nil {
-		if !strings.HasPrefix(pg.RemoteAddress, "https://") {
+	// certificate was provided, we need to use https for remote address unless scheme was provided explicitly
+	if pg.CertStore != nil || !pg.TLS.isEmpty() {
+		if !strings.Contains(pg.RemoteAddress, "://") {
 			pg.RemoteAddress = "https://" + pg.RemoteAddress
 		}
 	}
@@ -137,6 +356,26 @@ func NewPushGatewayPusher(opts ...PushGatewayPusherOption) (*PushGatewayPusher,
 		return nil, errors.New("empty job name")
 	}
 
+	pg.Mode = strings.ToLower(strings.TrimSpace(pg.Mode))
+	if len(pg.Mode) < 1 {
+		pg.Mode = PushModePush
+	}
+
+	if pg.Mode != PushModePush && pg.Mode != PushModeAdd {
+		return nil, errors.New(fmt.Sprintf("invalid push mode:%s", pg.Mode))
+	}
+
+	pg.Format = strings.ToLower(strings.TrimSpace(pg.Format))
+	if len(pg.Format) < 1 {
+		pg.Format = PushFormatProtobuf
+	}
+
+	// pushGateway accepts delimited protobuf and text format only
+	format, ok := pushFormats[pg.Format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid push format:%s", pg.Format))
+	}
+
 	if pg.ZapLoggerEntry == nil {
 		pg.ZapLoggerEntry = rkentry.GlobalAppCtx.GetZapLoggerEntryDefault()
 	}
@@ -145,102 +384,450 @@ func NewPushGatewayPusher(opts ...PushGatewayPusherOption) (*PushGatewayPusher,
 		pg.EventLoggerEntry = rkentry.GlobalAppCtx.GetEventLoggerEntryDefault()
 	}
 
-	pg.Pusher = push.New(pg.RemoteAddress, pg.JobName)
+	filter, err := newMetricsFilter(pg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	pg.filter = filter
+
+	// gatherer of push.Pusher could only be appended, register a swappable one so that SetGatherer could replace it
+	pg.published = &swappableGatherer{lock: &sync.RWMutex{}}
+	pg.Pusher = push.New(pg.RemoteAddress, pg.JobName).Format(format).Gatherer(pg.published)
+	constLabels := pg.healthLabels()
+	pg.health = newHealthTracker(PusherMetricsPrefix, constLabels)
 
-	// assign credential of basic auth
-	if len(pg.Credential) > 0 && strings.Contains(pg.Credential, ":") {
-		pg.Credential = strings.TrimSpace(pg.Credential)
-		tokens := strings.Split(pg.Credential, ":")
-		if len(tokens) == 2 {
-			pg.Pusher = pg.Pusher.BasicAuth(tokens[0], tokens[1])
+	if pg.SpoolConfig != nil && len(pg.SpoolConfig.Dir) > 0 {
+		spool, err := newSpool(pg.SpoolConfig.Dir, pg.SpoolConfig.MaxBytes,
+			time.Duration(pg.SpoolConfig.MaxAgeMs)*time.Millisecond, PusherMetricsPrefix, constLabels)
+		if err != nil {
+			return nil, err
 		}
+		pg.spool = spool
 	}
 
-	httpClient := &http.Client{
-		Timeout: rkentry.DefaultTimeout,
+	// assign grouping labels
+	if len(pg.Grouping) > 0 {
+		pg.Grouping = expandLabels(pg.Grouping)
+		for k, v := range pg.Grouping {
+			if !model.LabelName(k).IsValid() || k == model.JobLabel {
+				return nil, errors.New(fmt.Sprintf("invalid grouping label name:%s", k))
+			}
+			pg.Pusher = pg.Pusher.Grouping(k, v)
+		}
 	}
 
-	// deal with tls
-	if pg.CertStore != nil {
-		certPool := x509.NewCertPool()
-
-		certPool.AppendCertsFromPEM(pg.CertStore.ServerCert)
-
-		conf := &tls.Config{RootCAs: certPool}
+	// merge credential of basic auth into auth config
+	auth := &AuthConfig{}
+	if pg.Auth != nil {
+		*auth = *pg.Auth
+	}
 
-		cert, err := tls.X509KeyPair(pg.CertStore.ClientCert, pg.CertStore.ClientKey)
+	pg.Credential = strings.TrimSpace(pg.Credential)
+	if len(pg.Credential) > 0 && len(auth.BasicAuth) < 1 && len(auth.BasicAuthFile) < 1 && len(auth.BasicAuthEnv) < 1 {
+		auth.BasicAuth = pg.Credential
+	}
+	pg.Auth = auth
 
-		if err == nil {
-			conf.Certificates = []tls.Certificate{cert}
-		}
+	clientOpts := &httpClientOptions{
+		config:       pg.HTTPClient,
+		tls:          pg.TLS,
+		certStore:    pg.CertStore,
+		auth:         pg.Auth,
+		client:       pg.client,
+		roundTripper: pg.roundTripper,
+	}
 
-		httpClient.Transport = &http.Transport{TLSClientConfig: conf}
+	httpClient, err := clientOpts.newClient()
+	if err != nil {
+		return nil, err
 	}
 
-	pg.Pusher.Client(httpClient)
+	pg.doer = &contextDoer{
+		doer:    httpClient,
+		gzip:    pg.HTTPClient != nil && pg.HTTPClient.Compression == CompressionGzip,
+		capture: pg.spool != nil,
+	}
+	pg.Pusher.Client(pg.doer)
 
 	return pg, nil
 }
 
-// Start starts a periodic job
+// Start starts a periodic job, metrics would be pushed immediately and then every interval.
+// Start after Stop is safe, a new periodic job would be started.
 func (pub *PushGatewayPusher) Start() {
 	pub.lock.Lock()
 	defer pub.lock.Unlock()
 
 	// periodic job already started
-	// caution, do not call pub.isRunning() function directory, since it will cause dead lock
-	if pub.Running.Load() {
+	if !pub.job.start(pub.nextInterval, pub.publish) {
 		pub.ZapLoggerEntry.GetLogger().Info("pushGateway publisher already started",
 			zap.String("remoteAddress", pub.RemoteAddress),
 			zap.String("jobName", pub.JobName))
 		return
 	}
 
-	pub.Running.CAS(false, true)
+	pub.Running.Store(true)
 
 	pub.ZapLoggerEntry.GetLogger().Info("starting pushGateway publisher",
 		zap.String("remoteAddress", pub.RemoteAddress),
 		zap.String("jobName", pub.JobName))
+}
 
-	go pub.push()
+// settings returns a snapshot of settings which could be swapped by Reconfigure
+func (pub *PushGatewayPusher) settings() *pusherSettings {
+	pub.settingsLock.RLock()
+	defer pub.settingsLock.RUnlock()
+
+	return &pusherSettings{
+		remoteAddress: pub.RemoteAddress,
+		jobName:       pub.JobName,
+		intervalMs:    pub.IntervalMs,
+		jitter:        pub.Jitter,
+		retrier:       pub.retrier,
+		deleteOnStop:  pub.DeleteOnStop,
+	}
 }
 
 // Internal use only
-func (pub *PushGatewayPusher) push() {
-	for pub.Running.Load() {
-		event := pub.EventLoggerEntry.GetEventHelper().Start("publish")
-		event.AddPayloads(
+func (pub *PushGatewayPusher) nextInterval() time.Duration {
+	settings := pub.settings()
+	return jitter(settings.intervalMs, settings.jitter)
+}
+
+// Internal use only
+func (pub *PushGatewayPusher) publish(ctx context.Context) {
+	settings := pub.settings()
+
+	event := pub.EventLoggerEntry.GetEventHelper().Start("publish")
+	event.AddPayloads(
+		zap.String("jobName", settings.jobName),
+		zap.String("remoteAddr", settings.remoteAddress),
+		zap.Duration("intervalMs", settings.intervalMs))
+
+	// retries would not overlap next cycle
+	if err := pub.pushCycle(ctx, settings.intervalMs); err != nil {
+		pub.ZapLoggerEntry.GetLogger().Warn("failed to push metrics to PushGateway",
+			zap.String("remoteAddress", settings.remoteAddress),
+			zap.String("jobName", settings.jobName),
+			zap.Error(err))
+		pub.EventLoggerEntry.GetEventHelper().FinishWithError(event, err)
+	} else {
+		pub.EventLoggerEntry.GetEventHelper().Finish(event)
+	}
+}
+
+// PushNow pushes metrics synchronously with retries and returns the last error.
+// Retries would not be bounded by interval, use deadline of ctx instead.
+// It is safe to call PushNow while periodic job is running, pushes would be serialized.
+func (pub *PushGatewayPusher) PushNow(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	settings := pub.settings()
+
+	event := pub.EventLoggerEntry.GetEventHelper().Start("pushNow")
+	event.AddPayloads(
+		zap.String("jobName", settings.jobName),
+		zap.String("remoteAddr", settings.remoteAddress))
+
+	err := pub.pushCycle(ctx, 0)
+	if err != nil {
+		pub.EventLoggerEntry.GetEventHelper().FinishWithError(event, err)
+	} else {
+		pub.EventLoggerEntry.GetEventHelper().Finish(event)
+	}
+
+	return err
+}
+
+// ExportNow implements Exporter, the same as PushNow
+func (pub *PushGatewayPusher) ExportNow(ctx context.Context) error {
+	return pub.PushNow(ctx)
+}
+
+// pushCycle replays spooled payloads, pushes metrics with retries bounded by bound,
+// and writes the payload into spool if all of attempts failed and payload was not rejected
+func (pub *PushGatewayPusher) pushCycle(ctx context.Context, bound time.Duration) error {
+	if pub.spool != nil {
+		pub.replay(ctx)
+	}
+
+	settings := pub.settings()
+
+	// payload rejected by pushGateway would never be accepted, do not spool it
+	rejected := false
+	err := settings.retrier.do(ctx, bound, func(ctx context.Context) error {
+		err := pub.push(ctx)
+		_, rejected = err.(*permanentError)
+		return err
+	})
+	if err != nil && pub.spool != nil && !rejected {
+		pub.pushLock.Lock()
+		record := pub.doer.last
+		pub.pushLock.Unlock()
+
+		if record != nil {
+			if spoolErr := pub.spool.write(record); spoolErr != nil {
+				pub.ZapLoggerEntry.GetLogger().Warn("failed to write payload into spool",
+					zap.String("remoteAddress", settings.remoteAddress),
+					zap.String("jobName", settings.jobName),
+					zap.Error(spoolErr))
+			}
+		}
+	}
+
+	return err
+}
+
+// replay spooled payloads in order, replay stops at the first failure except rejected payloads which would be dropped
+func (pub *PushGatewayPusher) replay(ctx context.Context) {
+	pub.pushLock.Lock()
+	defer pub.pushLock.Unlock()
+
+	replayed, err := pub.spool.replay(ctx, pub.send)
+	if replayed > 0 {
+		pub.ZapLoggerEntry.GetLogger().Info("replayed spooled payloads to PushGateway",
+			zap.String("remoteAddress", pub.RemoteAddress),
 			zap.String("jobName", pub.JobName),
-			zap.String("remoteAddr", pub.RemoteAddress),
-			zap.Duration("intervalMs", pub.IntervalMs))
+			zap.Int("replayed", replayed))
+	}
 
-		err := pub.Pusher.Push()
+	if err != nil {
+		pub.ZapLoggerEntry.GetLogger().Warn("failed to replay spooled payloads to PushGateway",
+			zap.String("remoteAddress", pub.RemoteAddress),
+			zap.String("jobName", pub.JobName),
+			zap.Error(err))
+	}
+}
 
-		if err != nil {
-			pub.ZapLoggerEntry.GetLogger().Warn("failed to push metrics to PushGateway",
-				zap.String("remoteAddress", pub.RemoteAddress),
-				zap.String("jobName", pub.JobName),
-				zap.Error(err))
-			pub.EventLoggerEntry.GetEventHelper().FinishWithError(event, err)
-		} else {
-			pub.EventLoggerEntry.GetEventHelper().Finish(event)
+// send spooled payload to remote pushGateway, pushLock should be held by caller.
+// Payload would be sent to the group of current remote address, job name and grouping instead of URL of record,
+// so that spooled payloads would follow the pusher after reconfigured.
+func (pub *PushGatewayPusher) send(ctx context.Context, record *spoolRecord) error {
+	req, err := http.NewRequestWithContext(ctx, record.Method, pub.groupURL(), bytes.NewReader(record.Body))
+	if err != nil {
+		return err
+	}
+
+	if len(record.ContentType) > 0 {
+		req.Header.Set("Content-Type", record.ContentType)
+	}
+
+	if len(record.ContentEncoding) > 0 {
+		req.Header.Set("Content-Encoding", record.ContentEncoding)
+	}
+
+	// payload was compressed already, send it via http client directly
+	resp, err := pub.doer.doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		err := errors.New(fmt.Sprintf("unexpected status code %d while replaying spooled payload", resp.StatusCode))
+		if isPermanentStatus(resp.StatusCode) {
+			return &permanentError{err: err}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// groupURL returns URL of group on remote pushGateway built from remote address, job name and grouping
+// in the same way as push.Pusher does, pushLock should be held by caller
+func (pub *PushGatewayPusher) groupURL() string {
+	address := pub.RemoteAddress
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	address = strings.TrimSuffix(address, "/")
+
+	names := make([]string, 0, len(pub.Grouping))
+	for k := range pub.Grouping {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	components := encodeGroupingLabel(model.JobLabel, pub.JobName)
+	for _, name := range names {
+		components = append(components, encodeGroupingLabel(name, pub.Grouping[name])...)
+	}
+
+	return fmt.Sprintf("%s/metrics/%s", address, strings.Join(components, "/"))
+}
+
+// encodeGroupingLabel returns path components of label, value would be encoded with base64 if it is empty
+// or contains slash, otherwise it would be escaped
+func encodeGroupingLabel(name, value string) []string {
+	if len(value) < 1 {
+		return []string{name + "@base64", "="}
+	}
+
+	if strings.Contains(value, "/") {
+		return []string{name + "@base64", base64.RawURLEncoding.EncodeToString([]byte(value))}
+	}
+
+	return []string{name, url.QueryEscape(value)}
+}
+
+// push metrics to remote pushGateway once with mode, request would be canceled along with ctx
+func (pub *PushGatewayPusher) push(ctx context.Context) error {
+	pub.pushLock.Lock()
+	defer pub.pushLock.Unlock()
+
+	pub.doer.ctx = ctx
+	pub.doer.sent = 0
+	pub.doer.last = nil
+	pub.doer.status = 0
+	defer func() {
+		pub.doer.ctx = nil
+	}()
+
+	var err error
+	if pub.Mode == PushModeAdd {
+		err = pub.Pusher.Add()
+	} else {
+		err = pub.Pusher.Push()
+	}
+
+	pub.health.record(err, pub.doer.sent)
+
+	// rejected payload would not be retried
+	if err != nil && isPermanentStatus(pub.doer.status) {
+		return &permanentError{err: err}
+	}
+
+	return err
+}
+
+// delete the group from remote pushGateway, request would be canceled along with ctx
+func (pub *PushGatewayPusher) delete(ctx context.Context) error {
+	pub.pushLock.Lock()
+	defer pub.pushLock.Unlock()
+
+	pub.doer.ctx = ctx
+	defer func() {
+		pub.doer.ctx = nil
+	}()
+
+	return pub.Pusher.Delete()
+}
+
+// Health returns a snapshot of push health
+func (pub *PushGatewayPusher) Health() HealthStatus {
+	pub.settingsLock.RLock()
+	res := pub.health.get()
+	pub.settingsLock.RUnlock()
+
+	if pub.spool != nil {
+		res.SpoolBacklog = pub.spool.backlog()
+	}
+
+	return res
+}
+
+// HealthCollector returns a collector which exposes push health as metrics with prefix of rk_pusher,
+// metrics were labeled with pusher_job and pusher_address so that several pushers could be registered
+// into the same registry.
+func (pub *PushGatewayPusher) HealthCollector() prometheus.Collector {
+	pub.settingsLock.RLock()
+	defer pub.settingsLock.RUnlock()
+
+	return pub.healthCollector()
+}
+
+// Internal use only
+func (pub *PushGatewayPusher) healthCollector() prometheus.Collector {
+	if pub.spool != nil {
+		return collectors{pub.health, pub.spool}
+	}
+
+	return pub.health
+}
+
+// Internal use only
+func (pub *PushGatewayPusher) healthLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"pusher_job":     pub.JobName,
+		"pusher_address": pub.RemoteAddress,
+	}
+}
+
+// registerHealth registers health metrics into registerer, registerer would be remembered
+// so that health metrics could be registered again with new labels after reconfigured
+func (pub *PushGatewayPusher) registerHealth(registerer prometheus.Registerer) error {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+
+	if err := registerCollector(registerer, pub.HealthCollector()); err != nil {
+		return err
+	}
+
+	for i := range pub.registerers {
+		if pub.registerers[i] == registerer {
+			return nil
 		}
+	}
+	pub.registerers = append(pub.registerers, registerer)
+
+	return nil
+}
+
+// relabelHealth replaces health tracker with the one of candidate whose labels follow new job name
+// and remote address. Status would be carried over, and health metrics would be registered again into
+// registerers remembered by registerHealth. lock, pushLock and settingsLock should be held by caller.
+func (pub *PushGatewayPusher) relabelHealth(candidate *PushGatewayPusher) {
+	for i := range pub.registerers {
+		pub.registerers[i].Unregister(pub.healthCollector())
+	}
+
+	candidate.health.status = pub.health.get()
+	pub.health = candidate.health
+	if pub.spool != nil {
+		pub.spool.relabel(PusherMetricsPrefix, candidate.healthLabels())
+	}
 
-		time.Sleep(pub.IntervalMs)
+	for i := range pub.registerers {
+		if err := registerCollector(pub.registerers[i], pub.healthCollector()); err != nil {
+			pub.ZapLoggerEntry.GetLogger().Warn("failed to register pusher health collector", zap.Error(err))
+		}
 	}
 }
 
+// Internal use only
+func (pub *PushGatewayPusher) isOneShot() bool {
+	return pub.OneShot
+}
+
 // IsRunning validate whether periodic job is running or not
 func (pub *PushGatewayPusher) IsRunning() bool {
 	return pub.Running.Load()
 }
 
-// Stop stops periodic job
+// Stop stops periodic job and blocks until the in-flight push is canceled and the goroutine exits.
+// The group would be deleted from remote pushGateway if DeleteOnStop is true.
 func (pub *PushGatewayPusher) Stop() {
 	pub.lock.Lock()
 	defer pub.lock.Unlock()
 
-	pub.Running.CAS(true, false)
+	if !pub.job.stop() {
+		return
+	}
+
+	pub.Running.Store(false)
+
+	if pub.DeleteOnStop {
+		if err := pub.delete(context.Background()); err != nil {
+			pub.ZapLoggerEntry.GetLogger().Warn("failed to delete metrics from PushGateway",
+				zap.String("remoteAddress", pub.RemoteAddress),
+				zap.String("jobName", pub.JobName),
+				zap.Error(err))
+		}
+	}
 }
 
 // GetPusher simply call pusher.Gatherer()
@@ -253,6 +840,14 @@ func (pub *PushGatewayPusher) GetPusher() *push.Pusher {
 	return pub.Pusher
 }
 
+// Internal use only
+func (pub *PushGatewayPusher) target() string {
+	pub.settingsLock.RLock()
+	defer pub.settingsLock.RUnlock()
+
+	return pub.RemoteAddress
+}
+
 // String returns string value of PushGatewayPusher
 func (pub *PushGatewayPusher) String() string {
 	bytes, err := json.Marshal(pub)
@@ -264,9 +859,218 @@ func (pub *PushGatewayPusher) String() string {
 	return string(bytes)
 }
 
-// SetGatherer sets gatherer of prometheus
+// contextDoer attaches context of current push to outgoing requests since push.Pusher does not
+// accept context. Pushes are serialized by pushLock, so ctx would not be overridden concurrently.
+// Request bodies would be compressed if gzip is true, and size of them would be accumulated into sent.
+// The last request with body would be captured into last if capture is true, so that it could be spooled.
+type contextDoer struct {
+	doer    push.HTTPDoer
+	ctx     context.Context
+	gzip    bool
+	capture bool
+	sent    int64
+	last    *spoolRecord
+	status  int
+}
+
+// Do implements push.HTTPDoer
+func (d *contextDoer) Do(req *http.Request) (*http.Response, error) {
+	if d.ctx != nil {
+		req = req.WithContext(d.ctx)
+	}
+
+	if d.gzip {
+		var err error
+		if req, err = gzipRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.ContentLength > 0 {
+		d.sent += req.ContentLength
+	}
+
+	if d.capture && req.Body != nil && req.Body != http.NoBody {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		d.last = &spoolRecord{
+			Timestamp:       time.Now(),
+			Method:          req.Method,
+			URL:             req.URL.String(),
+			ContentType:     req.Header.Get("Content-Type"),
+			ContentEncoding: req.Header.Get("Content-Encoding"),
+			Body:            body,
+		}
+	}
+
+	resp, err := d.doer.Do(req)
+	if err == nil {
+		d.status = resp.StatusCode
+	}
+
+	return resp, err
+}
+
+// swappableGatherer delegates to gatherer which could be replaced, nothing would be gathered if it is nil
+type swappableGatherer struct {
+	gatherer prometheus.Gatherer
+	lock     *sync.RWMutex
+}
+
+// set replaces gatherer
+func (g *swappableGatherer) set(gatherer prometheus.Gatherer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.gatherer = gatherer
+}
+
+// Gather implements prometheus.Gatherer
+func (g *swappableGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.lock.RLock()
+	gatherer := g.gatherer
+	g.lock.RUnlock()
+
+	if gatherer == nil {
+		return nil, nil
+	}
+
+	return gatherer.Gather()
+}
+
+// SetGatherer sets gatherer of prometheus, filter would be applied to it.
+// Gatherer set previously would be replaced.
 func (pub *PushGatewayPusher) SetGatherer(gatherer prometheus.Gatherer) {
-	if pub.Pusher != nil {
-		pub.Pusher.Gatherer(gatherer)
+	pub.pushLock.Lock()
+	defer pub.pushLock.Unlock()
+
+	pub.gatherer = gatherer
+	if pub.published != nil {
+		pub.published.set(pub.filter.wrap(gatherer))
+	}
+}
+
+// Reconfigure swaps remote address, interval, job name, grouping, mode, format, auth, TLS, http client, retry and filter
+// of pusher with options atomically, it is safe to call Reconfigure while periodic job is running.
+// Settings which were not provided with options would be kept, and the pusher would keep the old settings
+// if the new ones were rejected, in which case the error would be returned.
+//
+// Reconfigure blocks until the in-flight push finished, and the new interval would take effect from the next cycle.
+// Spool, one-shot mode and loggers would not be changed, spooled payloads would be replayed to the new group.
+// Health would be kept, and health metrics would be labeled with the new job name and remote address.
+// Collectors added via GetPusher() would not be carried over, use SetGatherer instead.
+func (pub *PushGatewayPusher) Reconfigure(opts ...PushGatewayPusherOption) error {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+
+	// find out which settings were provided, since grouping and credential would be merged instead of replaced
+	probe := &PushGatewayPusher{}
+	for i := range opts {
+		opts[i](probe)
+	}
+
+	candidate, err := NewPushGatewayPusher(append(pub.currentOptions(probe), opts...)...)
+	if err != nil {
+		pub.ZapLoggerEntry.GetLogger().Warn("rejected pushGateway publisher config, keep the old one",
+			zap.String("remoteAddress", pub.RemoteAddress),
+			zap.String("jobName", pub.JobName),
+			zap.Error(err))
+		return err
+	}
+
+	pub.pushLock.Lock()
+	defer pub.pushLock.Unlock()
+
+	candidate.published.set(candidate.filter.wrap(pub.gatherer))
+	candidate.doer.capture = pub.spool != nil
+
+	pub.settingsLock.Lock()
+	defer pub.settingsLock.Unlock()
+
+	if candidate.JobName != pub.JobName || candidate.RemoteAddress != pub.RemoteAddress {
+		pub.relabelHealth(candidate)
+	}
+
+	pub.Pusher = candidate.Pusher
+	pub.published = candidate.published
+	pub.doer = candidate.doer
+	pub.IntervalMs = candidate.IntervalMs
+	pub.RemoteAddress = candidate.RemoteAddress
+	pub.JobName = candidate.JobName
+	pub.Credential = candidate.Credential
+	pub.Grouping = candidate.Grouping
+	pub.Mode = candidate.Mode
+	pub.Format = candidate.Format
+	pub.DeleteOnStop = candidate.DeleteOnStop
+	pub.Jitter = candidate.Jitter
+	pub.retrier = candidate.retrier
+	pub.CertStore = candidate.CertStore
+	pub.Auth = candidate.Auth
+	pub.TLS = candidate.TLS
+	pub.HTTPClient = candidate.HTTPClient
+	pub.client = candidate.client
+	pub.roundTripper = candidate.roundTripper
+	pub.Filter = candidate.Filter
+	pub.filter = candidate.filter
+
+	pub.ZapLoggerEntry.GetLogger().Info("reconfigured pushGateway publisher",
+		zap.String("remoteAddress", pub.RemoteAddress),
+		zap.String("jobName", pub.JobName),
+		zap.Duration("intervalMs", pub.IntervalMs))
+
+	return nil
+}
+
+// currentOptions returns options which reproduce current settings, spool would not be included since it is kept.
+// Credential was merged into auth while creating pusher, it would be split from auth so that it could be replaced.
+// Grouping and credential would be excluded if probe provided auth or grouping, so that they would be replaced.
+func (pub *PushGatewayPusher) currentOptions(probe *PushGatewayPusher) []PushGatewayPusherOption {
+	auth := &AuthConfig{}
+	if pub.Auth != nil {
+		*auth = *pub.Auth
+	}
+
+	credential := pub.Credential
+	if len(credential) > 0 && auth.BasicAuth == credential {
+		auth.BasicAuth = ""
+	}
+
+	if probe.Auth != nil {
+		credential = ""
+	}
+
+	grouping := pub.Grouping
+	if probe.Grouping != nil {
+		grouping = nil
+	}
+
+	return []PushGatewayPusherOption{
+		WithIntervalMSPusher(pub.IntervalMs),
+		WithRemoteAddressPusher(pub.RemoteAddress),
+		WithJobNamePusher(pub.JobName),
+		WithBasicAuthPusher(credential),
+		WithAuthPusher(auth),
+		WithTLSPusher(pub.TLS),
+		WithHTTPClientConfigPusher(pub.HTTPClient),
+		WithHTTPClientPusher(pub.client),
+		WithRoundTripperPusher(pub.roundTripper),
+		WithCertStorePusher(pub.CertStore),
+		WithGroupingPusher(grouping),
+		WithModePusher(pub.Mode),
+		WithFormatPusher(pub.Format),
+		WithDeleteOnStopPusher(pub.DeleteOnStop),
+		WithJitterPusher(pub.Jitter),
+		WithOneShotPusher(pub.OneShot),
+		WithFilterPusher(pub.Filter),
+		WithZapLoggerEntryPusher(pub.ZapLoggerEntry),
+		WithEventLoggerEntryPusher(pub.EventLoggerEntry),
+		func(pusher *PushGatewayPusher) {
+			pusher.retrier = pub.retrier
+		},
 	}
 }
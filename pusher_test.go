@@ -6,13 +6,23 @@
 package rkprom
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/rookie-ninja/rk-entry/entry"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/atomic"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -205,16 +215,8 @@ func TestNewPushGatewayPusher_WithInvalidCert(t *testing.T) {
 		WithZapLoggerEntryPusher(zapLoggerEntry),
 		WithEventLoggerEntryPusher(eventLoggerEntry))
 
-	assert.NotNil(t, pusher, "pusher should not be nil")
-	assert.Nil(t, err, "error should be nil")
-
-	assert.Equal(t, intervalMs, pusher.IntervalMs)
-	assert.NotNil(t, pusher.Pusher, "pusher should not be nil")
-	assert.Equal(t, jobName, pusher.JobName)
-	assert.Equal(t, basicAuth, pusher.Credential)
-	assert.NotNil(t, pusher.lock, "lock should not be nil")
-	assert.False(t, pusher.Running.Load(), "isRunning should be false")
-	assert.Contains(t, pusher.RemoteAddress, "http")
+	assert.Nil(t, pusher, "pusher should be nil")
+	assert.NotNil(t, err, "error should not be nil")
 }
 
 func TestNewPushGatewayPusher_HappyCase(t *testing.T) {
@@ -312,12 +314,8 @@ func TestPushGatewayPusher_push(t *testing.T) {
 	assert.NotNil(t, pusher, "pusher should not be nil")
 	assert.Nil(t, err, "error should be nil")
 
-	// make state of pusher as running first
-	pusher.Running.CAS(false, true)
-	// run with extra go routine since push() method was an infinite loop
-	go pusher.push()
-	// sleep one seconds to make sure http request was called at least once
-	time.Sleep(1 * time.Second)
+	// push once
+	assert.Nil(t, pusher.push(context.Background()))
 	assert.True(t, doer.Load().(*HTTPDoerMock).called.Load(), "supposed to be called at least once")
 }
 
@@ -393,3 +391,955 @@ func TestPushGatewayPusher_GetPusher(t *testing.T) {
 	assert.Nil(t, err, "error should be nil")
 	assert.NotNil(t, pusher.GetPusher())
 }
+
+func TestNewPushGatewayPusher_WithGrouping(t *testing.T) {
+	assert.Nil(t, os.Setenv("RK_PROM_UT_POD", "pod-1"))
+	defer os.Unsetenv("RK_PROM_UT_POD")
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithGroupingPusher(map[string]string{
+			"pod":      "${RK_PROM_UT_POD}",
+			"instance": "$HOSTNAME",
+		}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "pod-1", pusher.Grouping["pod"])
+	assert.NotEmpty(t, pusher.Grouping["instance"])
+
+	// grouping labels should be attached to URL
+	urls := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		urls <- request.URL.Path
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(server.URL),
+		WithJobNamePusher(jobName),
+		WithGroupingPusher(map[string]string{"pod": "${RK_PROM_UT_POD}"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.GetPusher().Push())
+	assert.Equal(t, "/metrics/job/"+jobName+"/pod/pod-1", <-urls)
+}
+
+func TestNewPushGatewayPusher_WithInvalidGrouping(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithGroupingPusher(map[string]string{"invalid-name": "value"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+type gatewayRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+type gatewayMock struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []*gatewayRequest
+	status   *atomic.Int32
+}
+
+func newGatewayMock() *gatewayMock {
+	mock := &gatewayMock{
+		requests: make([]*gatewayRequest, 0),
+		status:   atomic.NewInt32(http.StatusOK),
+	}
+
+	mock.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+
+		mock.lock.Lock()
+		mock.requests = append(mock.requests, &gatewayRequest{
+			method: request.Method,
+			path:   request.URL.Path,
+			header: request.Header.Clone(),
+			body:   body,
+		})
+		mock.lock.Unlock()
+
+		status := int(mock.status.Load())
+		if request.Method == http.MethodDelete && status == http.StatusOK {
+			status = http.StatusAccepted
+		}
+		writer.WriteHeader(status)
+	}))
+
+	return mock
+}
+
+func (mock *gatewayMock) getRequests() []*gatewayRequest {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+
+	return append([]*gatewayRequest{}, mock.requests...)
+}
+
+func TestNewPushGatewayPusher_WithInvalidMode(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithModePusher("invalid"),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestPushGatewayPusher_WithAddModeAndDeleteOnStop(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithModePusher("ADD"),
+		WithDeleteOnStopPusher(true),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, PushModeAdd, pusher.Mode)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	pusher.Start()
+	time.Sleep(100 * time.Millisecond)
+	pusher.Stop()
+
+	requests := gateway.getRequests()
+	assert.True(t, len(requests) > 1)
+	assert.Equal(t, http.MethodPost, requests[0].method)
+	assert.Equal(t, http.MethodDelete, requests[len(requests)-1].method)
+}
+
+func TestNewPushGatewayPusher_WithInvalidFormat(t *testing.T) {
+	// openMetrics is not accepted by pushGateway
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithFormatPusher("openmetrics"),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestPushGatewayPusher_PushNow_WithFormat(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "ut_counter"})
+	counter.Add(3)
+	registry.MustRegister(counter)
+
+	// protobuf by default
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, PushFormatProtobuf, pusher.Format)
+	pusher.SetGatherer(registry)
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	requests := gateway.getRequests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, string(expfmt.FmtProtoDelim), requests[0].header.Get("Content-Type"))
+	assertPushedCounter(t, requests[0], expfmt.FmtProtoDelim)
+
+	// text
+	gateway = newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithFormatPusher("TEXT"),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, PushFormatText, pusher.Format)
+	pusher.SetGatherer(registry)
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	requests = gateway.getRequests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, string(expfmt.FmtText), requests[0].header.Get("Content-Type"))
+	assert.True(t, bytes.Contains(requests[0].body, []byte("ut_counter 3")))
+	assertPushedCounter(t, requests[0], expfmt.FmtText)
+}
+
+// assertPushedCounter decodes body of request with format and validates ut_counter in it
+func assertPushedCounter(t *testing.T, request *gatewayRequest, format expfmt.Format) {
+	decoder := expfmt.NewDecoder(bytes.NewReader(request.body), format)
+
+	found := false
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+
+		if family.GetName() == "ut_counter" {
+			found = true
+			assert.Equal(t, float64(3), family.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+
+	assert.True(t, found)
+}
+
+func TestPushGatewayPusher_Stop_WithInFlightPush(t *testing.T) {
+	// gateway which blocks until request was canceled
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	}))
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Hour),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	pusher.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	// stop should cancel in-flight push and return immediately instead of waiting for timeout
+	start := time.Now()
+	pusher.Stop()
+	assert.True(t, time.Since(start) < time.Second)
+	assert.False(t, pusher.IsRunning())
+	assert.False(t, pusher.job.isRunning())
+}
+
+func TestPushGatewayPusher_Start_AfterStop(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(10*time.Millisecond),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	for i := 0; i < 3; i++ {
+		pusher.Start()
+		pusher.Start()
+		time.Sleep(50 * time.Millisecond)
+		pusher.Stop()
+		pusher.Stop()
+	}
+
+	// no more pushes after Stop returns, canceled requests may still be recorded by gateway for a moment
+	time.Sleep(20 * time.Millisecond)
+	count := len(gateway.getRequests())
+	assert.True(t, count > 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, len(gateway.getRequests()))
+}
+
+func TestNewPushGatewayPusher_WithInvalidJitter(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithJitterPusher(1.5),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestPushGatewayPusher_publish_WithRetry(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+	gateway.status.Store(http.StatusServiceUnavailable)
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithRetryPusher(2, time.Millisecond, time.Millisecond, 0),
+		WithJitterPusher(0.1),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	pusher.publish(context.Background())
+	assert.Len(t, gateway.getRequests(), 3)
+
+	next := pusher.nextInterval()
+	assert.True(t, next >= 900*time.Millisecond && next < 1100*time.Millisecond)
+}
+
+func TestPushGatewayPusher_Health(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+	gateway.status.Store(http.StatusServiceUnavailable)
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithRetryPusher(1, time.Millisecond, time.Millisecond, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "counter"})
+	counter.Inc()
+	registry.MustRegister(counter)
+	pusher.SetGatherer(registry)
+
+	// failed with retry
+	pusher.publish(context.Background())
+	health := pusher.Health()
+	assert.Equal(t, int64(2), health.TotalPushes)
+	assert.Equal(t, int64(2), health.TotalFailures)
+	assert.Equal(t, int64(2), health.ConsecutiveFailures)
+	assert.NotEmpty(t, health.LastError)
+	assert.False(t, health.LastPushTime.IsZero())
+	assert.True(t, health.LastSuccessTime.IsZero())
+	assert.True(t, health.TotalBytes > 0)
+
+	// succeeded
+	gateway.status.Store(http.StatusOK)
+	pusher.publish(context.Background())
+	health = pusher.Health()
+	assert.Equal(t, int64(3), health.TotalPushes)
+	assert.Equal(t, int64(2), health.TotalFailures)
+	assert.Equal(t, int64(0), health.ConsecutiveFailures)
+	assert.Empty(t, health.LastError)
+	assert.False(t, health.LastSuccessTime.IsZero())
+
+	var bytes int64
+	for _, req := range gateway.getRequests() {
+		bytes += int64(len(req.body))
+	}
+	assert.Equal(t, bytes, health.TotalBytes)
+
+	// exposed as metrics
+	registry.MustRegister(pusher.HealthCollector())
+	names := gatherNames(t, registry)
+	assert.True(t, names["rk_pusher_pushes_total"])
+	assert.True(t, names["rk_pusher_failures_total"])
+	assert.True(t, names["rk_pusher_sent_bytes_total"])
+	assert.True(t, names["rk_pusher_consecutive_failures"])
+	assert.True(t, names["rk_pusher_last_push_timestamp_seconds"])
+	assert.True(t, names["rk_pusher_last_success_timestamp_seconds"])
+}
+
+func TestPushGatewayPusher_PushNow(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.PushNow(context.Background()))
+	assert.Len(t, gateway.getRequests(), 1)
+	assert.False(t, pusher.IsRunning())
+}
+
+func TestPushGatewayPusher_ExportNow(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	var exporter Exporter
+	exporter, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	exporter.(*PushGatewayPusher).SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	assert.Len(t, gateway.getRequests(), 1)
+	assert.Equal(t, int64(1), exporter.Health().TotalPushes)
+}
+
+func TestPushGatewayPusher_PushNow_WithRetry(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+	gateway.status.Store(http.StatusServiceUnavailable)
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Millisecond),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithRetryPusher(2, time.Millisecond, time.Millisecond, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	// retries would not be bounded by interval
+	assert.NotNil(t, pusher.PushNow(context.Background()))
+	assert.Len(t, gateway.getRequests(), 3)
+}
+
+func TestPushGatewayPusher_PushNow_WithCanceledContext(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithRetryPusher(2, time.Millisecond, time.Millisecond, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NotNil(t, pusher.PushNow(ctx))
+	assert.Empty(t, gateway.getRequests())
+}
+
+func TestNewPushGatewayPusher_WithBasicAuthWithoutColon(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithBasicAuthPusher("user"),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestPushGatewayPusher_push_WithAuth(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	// password contains colon
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithBasicAuthPusher("user:pass:word"),
+		WithAuthPusher(&AuthConfig{Headers: map[string]string{"X-Tenant": "ut"}}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.push(context.Background()))
+	req := &http.Request{Header: gateway.getRequests()[0].header}
+	user, pass, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass:word", pass)
+	assert.Equal(t, "ut", req.Header.Get("X-Tenant"))
+
+	// secrets would not be marshaled
+	bytes, err := json.Marshal(pusher.Auth)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bytes), "pass:word")
+
+	// bearer token
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithAuthPusher(&AuthConfig{BearerToken: "token"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.push(context.Background()))
+	assert.Equal(t, "Bearer token", gateway.getRequests()[1].header.Get("Authorization"))
+}
+
+func TestPushGatewayPusher_push_WithTLS(t *testing.T) {
+	gateway := newGatewayMock()
+	gateway.Close()
+	gateway.Server = httptest.NewTLSServer(gateway.Config.Handler)
+	defer gateway.Close()
+
+	// trusted CA with server name matching certificate
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(strings.TrimPrefix(gateway.URL, "https://")),
+		WithJobNamePusher(jobName),
+		WithTLSPusher(&TLSConfig{CAFile: writeCAFile(t, gateway.Server), ServerName: "example.com"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, gateway.URL, pusher.RemoteAddress)
+	pusher.SetGatherer(prometheus.NewRegistry())
+	assert.Nil(t, pusher.push(context.Background()))
+
+	// untrusted server
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithTLSPusher(&TLSConfig{MinVersion: "1.2"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+	assert.NotNil(t, pusher.push(context.Background()))
+
+	// skip verify
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithTLSPusher(&TLSConfig{InsecureSkipVerify: true}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+	assert.Nil(t, pusher.push(context.Background()))
+	assert.Len(t, gateway.getRequests(), 2)
+}
+
+func TestNewPushGatewayPusher_WithExplicitHTTPScheme(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher("http://"+remoteAddr),
+		WithJobNamePusher(jobName),
+		WithTLSPusher(&TLSConfig{InsecureSkipVerify: true}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, "http://"+remoteAddr, pusher.RemoteAddress)
+}
+
+func TestPushGatewayPusher_push_WithGzip(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithHTTPClientConfigPusher(&HTTPClientConfig{Compression: CompressionGzip, TimeoutMs: 1000}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "counter"})
+	registry.MustRegister(counter)
+	pusher.SetGatherer(registry)
+
+	assert.Nil(t, pusher.push(context.Background()))
+	req := gateway.getRequests()[0]
+	assert.Equal(t, CompressionGzip, req.header.Get("Content-Encoding"))
+
+	// compressed bytes would be recorded
+	assert.Equal(t, int64(len(req.body)), pusher.Health().TotalBytes)
+}
+
+func TestPushGatewayPusher_push_WithRoundTripper(t *testing.T) {
+	next := &roundTripperMock{}
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithBasicAuthPusher(basicAuth),
+		WithRoundTripperPusher(next),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.push(context.Background()))
+	assert.Equal(t, http.MethodPut, next.req.Method)
+	_, _, ok := next.req.BasicAuth()
+	assert.True(t, ok)
+}
+
+func TestPushGatewayPusher_push_WithHTTPClient(t *testing.T) {
+	next := &roundTripperMock{}
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithHTTPClientPusher(&http.Client{Transport: next}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	assert.Nil(t, pusher.push(context.Background()))
+	assert.NotNil(t, next.req)
+
+	// TLS could not be used along with custom client
+	pusher, err = NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher(remoteAddr),
+		WithJobNamePusher(jobName),
+		WithTLSPusher(&TLSConfig{InsecureSkipVerify: true}),
+		WithHTTPClientPusher(&http.Client{Transport: next}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, pusher)
+	assert.NotNil(t, err)
+}
+
+func TestPushGatewayPusher_SetGatherer_Twice(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithFormatPusher(PushFormatText),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	// nothing would be pushed without gatherer
+	pusher.SetGatherer(nil)
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	oldRegistry := prometheus.NewRegistry()
+	oldRegistry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_old"}))
+	newRegistry := prometheus.NewRegistry()
+	newRegistry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "ut_new"}))
+
+	// gatherer set previously would be replaced instead of appended
+	pusher.SetGatherer(oldRegistry)
+	pusher.SetGatherer(newRegistry)
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	requests := gateway.getRequests()
+	assert.Len(t, requests, 2)
+	assert.Contains(t, string(requests[1].body), "ut_new")
+	assert.NotContains(t, string(requests[1].body), "ut_old")
+}
+
+func TestPushGatewayPusher_publish_WithSpool(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+	gateway.status.Store(http.StatusServiceUnavailable)
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithBasicAuthPusher(basicAuth),
+		WithSpoolPusher(t.TempDir(), 0, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "counter"})
+	registry.MustRegister(counter)
+	pusher.SetGatherer(registry)
+
+	// gateway is down
+	pusher.publish(context.Background())
+	counter.Inc()
+	pusher.publish(context.Background())
+	assert.Equal(t, 2, pusher.Health().SpoolBacklog)
+
+	names := gatherNames(t, prometheus.Gatherers{registry, collectorGatherer(t, pusher.HealthCollector())})
+	assert.True(t, names["rk_pusher_spool_backlog"])
+
+	// gateway comes back, payloads would be replayed in order before pushing
+	gateway.status.Store(http.StatusOK)
+	counter.Inc()
+	pusher.publish(context.Background())
+	assert.Equal(t, 0, pusher.Health().SpoolBacklog)
+
+	// push, replay of the first payload and push while gateway is down, then replays and push
+	requests := gateway.getRequests()
+	assert.Len(t, requests, 6)
+	assert.Equal(t, requests[0].body, requests[3].body)
+	assert.Equal(t, requests[2].body, requests[4].body)
+	assert.NotEqual(t, requests[3].body, requests[4].body)
+	assert.NotEqual(t, requests[4].body, requests[5].body)
+
+	// auth would be attached while replaying
+	for _, req := range requests {
+		assert.NotEmpty(t, req.header.Get("Authorization"))
+	}
+}
+
+func TestPushGatewayPusher_publish_WithRejectedPayload(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithRetryPusher(3, time.Millisecond, time.Millisecond, 0),
+		WithSpoolPusher(t.TempDir(), 0, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "counter"}))
+	pusher.SetGatherer(registry)
+
+	// gateway is down, payload would be spooled
+	gateway.status.Store(http.StatusServiceUnavailable)
+	pusher.publish(context.Background())
+	assert.Equal(t, 1, pusher.Health().SpoolBacklog)
+	assert.Len(t, gateway.getRequests(), 4)
+
+	// rejected payload would be neither retried nor spooled, spooled payload would be dropped
+	gateway.status.Store(http.StatusBadRequest)
+	pusher.publish(context.Background())
+	assert.Equal(t, 0, pusher.Health().SpoolBacklog)
+	assert.Len(t, gateway.getRequests(), 6)
+	assert.NotEmpty(t, pusher.Health().LastError)
+}
+
+func collectorGatherer(t *testing.T, collector prometheus.Collector) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(collector))
+
+	return registry
+}
+
+func TestPushGatewayPusher_Reconfigure_WhileRunning(t *testing.T) {
+	oldGateway := newGatewayMock()
+	defer oldGateway.Close()
+	newGateway := newGatewayMock()
+	defer newGateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(50*time.Millisecond),
+		WithRemoteAddressPusher(oldGateway.URL),
+		WithJobNamePusher(jobName),
+		WithGroupingPusher(map[string]string{"instance": "ut-old"}),
+		WithBasicAuthPusher("user:pass"),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	pusher.Start()
+	defer pusher.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NotEmpty(t, oldGateway.getRequests())
+
+	assert.Nil(t, pusher.Reconfigure(
+		WithRemoteAddressPusher(newGateway.URL),
+		WithJobNamePusher("ut-new-job"),
+		WithIntervalMSPusher(20*time.Millisecond),
+		WithGroupingPusher(map[string]string{"pod": "ut-pod"}),
+		WithAuthPusher(&AuthConfig{BearerToken: "ut-token"})))
+	assert.True(t, pusher.IsRunning())
+
+	pushedToOld := len(oldGateway.getRequests())
+	time.Sleep(100 * time.Millisecond)
+
+	// old gateway would not receive any push after reconfiguring
+	assert.Len(t, oldGateway.getRequests(), pushedToOld)
+	requests := newGateway.getRequests()
+	assert.NotEmpty(t, requests)
+	// grouping and credential were replaced
+	assert.Equal(t, "/metrics/job/ut-new-job/pod/ut-pod", requests[0].path)
+	assert.Equal(t, "Bearer ut-token", requests[0].header.Get("Authorization"))
+
+	assert.Equal(t, newGateway.URL, pusher.RemoteAddress)
+	assert.Equal(t, 20*time.Millisecond, pusher.IntervalMs)
+}
+
+func TestPushGatewayPusher_Reconfigure_WithSpool(t *testing.T) {
+	oldGateway := newGatewayMock()
+	defer oldGateway.Close()
+	oldGateway.status.Store(http.StatusServiceUnavailable)
+	newGateway := newGatewayMock()
+	defer newGateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(oldGateway.URL),
+		WithJobNamePusher(jobName),
+		WithSpoolPusher(t.TempDir(), 0, 0),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(newRegistryWith(prometheus.NewCounter(prometheus.CounterOpts{Name: "counter"})))
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, pusher.registerHealth(registry))
+
+	// old gateway is down
+	pusher.publish(context.Background())
+	assert.Equal(t, 1, pusher.Health().SpoolBacklog)
+
+	assert.Nil(t, pusher.Reconfigure(
+		WithRemoteAddressPusher(newGateway.URL),
+		WithJobNamePusher("ut-new-job"),
+		WithGroupingPusher(map[string]string{"pod": "ut-pod"})))
+	oldGateway.Close()
+
+	// spooled payload would be replayed to the new group
+	pusher.publish(context.Background())
+	assert.Equal(t, 0, pusher.Health().SpoolBacklog)
+
+	requests := newGateway.getRequests()
+	assert.Len(t, requests, 2)
+	for _, req := range requests {
+		assert.Equal(t, "/metrics/job/ut-new-job/pod/ut-pod", req.path)
+	}
+
+	// health would be kept and labeled with the new job name and remote address
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		assert.Len(t, family.GetMetric(), 1)
+		labels := make(map[string]string)
+		for _, pair := range family.GetMetric()[0].GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		assert.Equal(t, newGateway.URL, labels["pusher_address"], family.GetName())
+		assert.Equal(t, "ut-new-job", labels["pusher_job"], family.GetName())
+
+		if family.GetName() == "rk_pusher_pushes_total" {
+			assert.Equal(t, float64(2), family.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+}
+
+func TestPushGatewayPusher_GroupURL(t *testing.T) {
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(intervalMs),
+		WithRemoteAddressPusher("localhost:9091/"),
+		WithJobNamePusher("ut job"),
+		WithGroupingPusher(map[string]string{"path": "/ut/path", "empty": "", "instance": "ut"}),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "http://localhost:9091/metrics/job/ut+job/empty@base64/=/instance/ut/path@base64/L3V0L3BhdGg",
+		pusher.groupURL())
+}
+
+func TestPushGatewayPusher_Reconfigure_WithPartialOptions(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithGroupingPusher(map[string]string{"instance": "ut-instance"}),
+		WithBasicAuthPusher("user:pass"),
+		WithModePusher(PushModeAdd),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	// the rest of settings would be kept
+	assert.Nil(t, pusher.Reconfigure(WithJobNamePusher("ut-new-job")))
+	assert.Nil(t, pusher.PushNow(context.Background()))
+
+	requests := gateway.getRequests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].method)
+	assert.Equal(t, "/metrics/job/ut-new-job/instance/ut-instance", requests[0].path)
+	user, pass, ok := (&http.Request{Header: requests[0].header}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	// credential could be replaced as well
+	assert.Nil(t, pusher.Reconfigure(WithBasicAuthPusher("user2:pass2")))
+	assert.Nil(t, pusher.PushNow(context.Background()))
+	user, _, _ = (&http.Request{Header: gateway.getRequests()[1].header}).BasicAuth()
+	assert.Equal(t, "user2", user)
+
+	// format would be kept while reconfiguring others and could be replaced as well
+	assert.Nil(t, pusher.Reconfigure(WithFormatPusher(PushFormatText)))
+	assert.Nil(t, pusher.Reconfigure(WithJitterPusher(0.1)))
+	assert.Nil(t, pusher.PushNow(context.Background()))
+	assert.Equal(t, string(expfmt.FmtText), gateway.getRequests()[2].header.Get("Content-Type"))
+	assert.NotNil(t, pusher.Reconfigure(WithFormatPusher("invalid")))
+	assert.Equal(t, PushFormatText, pusher.Format)
+}
+
+func TestPushGatewayPusher_Reconfigure_WithRejectedConfig(t *testing.T) {
+	gateway := newGatewayMock()
+	defer gateway.Close()
+
+	pusher, err := NewPushGatewayPusher(
+		WithIntervalMSPusher(time.Second),
+		WithRemoteAddressPusher(gateway.URL),
+		WithJobNamePusher(jobName),
+		WithZapLoggerEntryPusher(zapLoggerEntry),
+		WithEventLoggerEntryPusher(eventLoggerEntry))
+	assert.Nil(t, err)
+	pusher.SetGatherer(prometheus.NewRegistry())
+
+	// invalid interval, mode, grouping and TLS would be rejected
+	assert.NotNil(t, pusher.Reconfigure(WithRemoteAddressPusher("localhost:1"), WithIntervalMSPusher(-1)))
+	assert.NotNil(t, pusher.Reconfigure(WithModePusher("invalid")))
+	assert.NotNil(t, pusher.Reconfigure(WithGroupingPusher(map[string]string{"job": "ut"})))
+	assert.NotNil(t, pusher.Reconfigure(WithTLSPusher(&TLSConfig{CAFile: "non-exist"})))
+
+	// old settings were kept
+	assert.Equal(t, gateway.URL, pusher.RemoteAddress)
+	assert.Equal(t, time.Second, pusher.IntervalMs)
+	assert.Nil(t, pusher.PushNow(context.Background()))
+	assert.Len(t, gateway.getRequests(), 1)
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// RelabelActionReplace matches regex against concatenated source labels and sets target label
+	// with replacement, in which case $1, ${1} and ${name} would be expanded with capture groups
+	RelabelActionReplace = "replace"
+	// RelabelActionKeep drops metrics whose concatenated source labels do not match regex
+	RelabelActionKeep = "keep"
+	// RelabelActionDrop drops metrics whose concatenated source labels match regex
+	RelabelActionDrop = "drop"
+	// RelabelActionLabelDrop removes labels whose name match regex
+	RelabelActionLabelDrop = "labeldrop"
+	// RelabelActionLabelKeep removes labels whose name do not match regex
+	RelabelActionLabelKeep = "labelkeep"
+
+	relabelSeparatorDefault   = ";"
+	relabelRegexDefault       = "(.*)"
+	relabelReplacementDefault = "$1"
+)
+
+// RelabelConfig is modeled on relabel_config of prometheus, and applied to every gathered metric.
+// The name of metric could be accessed and replaced via __name__ label.
+//
+// 1: SourceLabels: Labels whose values would be concatenated with separator and matched against regex.
+// 2: Separator:    Separator of concatenated source labels, ";" by default.
+// 3: Regex:        Regular expression which is anchored on both ends, "(.*)" by default.
+// 4: TargetLabel:  Label to which the replacement would be written, required by replace action.
+// 5: Replacement:  Replacement of replace action, "$1" by default.
+// 6: Action:       One of replace, keep, drop, labeldrop and labelkeep, replace by default.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"sourceLabels" json:"sourceLabels"`
+	Separator    string   `yaml:"separator" json:"separator"`
+	Regex        string   `yaml:"regex" json:"regex"`
+	TargetLabel  string   `yaml:"targetLabel" json:"targetLabel"`
+	Replacement  string   `yaml:"replacement" json:"replacement"`
+	Action       string   `yaml:"action" json:"action"`
+}
+
+// relabelRule is a compiled RelabelConfig
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// Internal use only
+func newRelabelRule(config *RelabelConfig) (*relabelRule, error) {
+	rule := &relabelRule{
+		sourceLabels: config.SourceLabels,
+		separator:    config.Separator,
+		targetLabel:  config.TargetLabel,
+		replacement:  config.Replacement,
+		action:       strings.ToLower(strings.TrimSpace(config.Action)),
+	}
+
+	if len(rule.separator) < 1 {
+		rule.separator = relabelSeparatorDefault
+	}
+
+	if len(rule.replacement) < 1 {
+		rule.replacement = relabelReplacementDefault
+	}
+
+	if len(rule.action) < 1 {
+		rule.action = RelabelActionReplace
+	}
+
+	regex := config.Regex
+	if len(regex) < 1 {
+		regex = relabelRegexDefault
+	}
+
+	var err error
+	if rule.regex, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+		return nil, errors.Wrapf(err, "invalid relabel regex:%s", regex)
+	}
+
+	switch rule.action {
+	case RelabelActionReplace:
+		if len(rule.targetLabel) < 1 {
+			return nil, errors.New("empty target label of replace action")
+		}
+	case RelabelActionKeep, RelabelActionDrop:
+		if len(rule.sourceLabels) < 1 {
+			return nil, errors.New(fmt.Sprintf("empty source labels of %s action", rule.action))
+		}
+	case RelabelActionLabelDrop, RelabelActionLabelKeep:
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown relabel action:%s", rule.action))
+	}
+
+	return rule, nil
+}
+
+// apply rule to labels, false would be returned if metric should be dropped
+func (rule *relabelRule) apply(labels map[string]string) bool {
+	switch rule.action {
+	case RelabelActionLabelDrop, RelabelActionLabelKeep:
+		for name := range labels {
+			if name == model.MetricNameLabel {
+				continue
+			}
+
+			if rule.regex.MatchString(name) == (rule.action == RelabelActionLabelDrop) {
+				delete(labels, name)
+			}
+		}
+		return true
+	}
+
+	values := make([]string, 0, len(rule.sourceLabels))
+	for _, name := range rule.sourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, rule.separator)
+
+	switch rule.action {
+	case RelabelActionKeep:
+		return rule.regex.MatchString(value)
+	case RelabelActionDrop:
+		return !rule.regex.MatchString(value)
+	}
+
+	// replace action
+	indexes := rule.regex.FindStringSubmatchIndex(value)
+	if indexes == nil {
+		return true
+	}
+
+	res := string(rule.regex.ExpandString([]byte{}, rule.replacement, value, indexes))
+	if len(res) < 1 {
+		delete(labels, rule.targetLabel)
+	} else {
+		labels[rule.targetLabel] = res
+	}
+
+	return true
+}
+
+// ValidateRelabelConfigs compiles configs and returns the first error, so that configs parsed from
+// boot config could be validated before applied
+func ValidateRelabelConfigs(configs ...*RelabelConfig) error {
+	_, err := NewRelabelGatherer(prometheus.NewRegistry(), configs...)
+	return err
+}
+
+// relabelGatherer applies relabel rules to every gathered metric
+type relabelGatherer struct {
+	gatherer prometheus.Gatherer
+	rules    []*relabelRule
+}
+
+// NewRelabelGatherer wraps gatherer with relabel configs which would be applied at gather time.
+// Gatherer itself would be returned if configs is empty.
+func NewRelabelGatherer(gatherer prometheus.Gatherer, configs ...*RelabelConfig) (prometheus.Gatherer, error) {
+	if len(configs) < 1 {
+		return gatherer, nil
+	}
+
+	res := &relabelGatherer{
+		gatherer: gatherer,
+		rules:    make([]*relabelRule, 0, len(configs)),
+	}
+
+	for i := range configs {
+		if configs[i] == nil {
+			return nil, errors.New("nil relabel config")
+		}
+
+		rule, err := newRelabelRule(configs[i])
+		if err != nil {
+			return nil, err
+		}
+		res.rules = append(res.rules, rule)
+	}
+
+	return res, nil
+}
+
+// Gather implements prometheus.Gatherer
+func (g *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	res := make([]*dto.MetricFamily, 0, len(families))
+	byName := make(map[string]*dto.MetricFamily)
+	errs := prometheus.MultiError{}
+	if err != nil {
+		errs.Append(err)
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{model.MetricNameLabel: family.GetName()}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			if !g.relabel(labels) {
+				continue
+			}
+
+			name := labels[model.MetricNameLabel]
+			if !model.IsValidMetricName(model.LabelValue(name)) {
+				errs.Append(errors.New(fmt.Sprintf("invalid metric name after relabeling:%s", name)))
+				continue
+			}
+
+			target, ok := byName[name]
+			if !ok {
+				target = &dto.MetricFamily{
+					Name: proto.String(name),
+					Help: family.Help,
+					Type: family.Type,
+				}
+				byName[name] = target
+				res = append(res, target)
+			} else if target.GetType() != family.GetType() {
+				errs.Append(errors.New(fmt.Sprintf("conflict metric type after relabeling:%s", name)))
+				continue
+			}
+
+			metric.Label = toLabelPairs(labels)
+			target.Metric = append(target.Metric, metric)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+
+	return res, errs.MaybeUnwrap()
+}
+
+// Internal use only
+func (g *relabelGatherer) relabel(labels map[string]string) bool {
+	for _, rule := range g.rules {
+		if !rule.apply(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toLabelPairs converts labels into sorted label pairs, labels with reserved prefix "__" and
+// labels with empty value would be excluded.
+func toLabelPairs(labels map[string]string) []*dto.LabelPair {
+	res := make([]*dto.LabelPair, 0, len(labels))
+
+	for k, v := range labels {
+		if strings.HasPrefix(k, model.ReservedLabelPrefix) || len(v) < 1 {
+			continue
+		}
+
+		res = append(res, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+
+	return res
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newRelabelTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	set := NewMetricsSet("ut", "relabel", registry)
+
+	set.RegisterCounter("counter", "method", "path")
+	set.GetCounterWithValues("counter", "GET", "/v1/users").Inc()
+	set.GetCounterWithValues("counter", "POST", "/v1/users").Inc()
+
+	set.RegisterGauge("gauge", "instance")
+	set.GetGaugeWithValues("gauge", "localhost:8080").Set(1)
+
+	return registry
+}
+
+func TestNewRelabelGatherer_WithEmptyConfigs(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gatherer, err := NewRelabelGatherer(registry)
+	assert.Nil(t, err)
+	assert.Equal(t, registry, gatherer)
+}
+
+func TestNewRelabelGatherer_WithInvalidConfigs(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	_, err := NewRelabelGatherer(registry, &RelabelConfig{Regex: "(", TargetLabel: "a"})
+	assert.NotNil(t, err)
+
+	_, err = NewRelabelGatherer(registry, &RelabelConfig{Action: "replace"})
+	assert.NotNil(t, err)
+
+	_, err = NewRelabelGatherer(registry, &RelabelConfig{Action: "keep"})
+	assert.NotNil(t, err)
+
+	_, err = NewRelabelGatherer(registry, &RelabelConfig{Action: "unknown"})
+	assert.NotNil(t, err)
+
+	_, err = NewRelabelGatherer(registry, nil)
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, ValidateRelabelConfigs(&RelabelConfig{Action: "unknown"}))
+	assert.Nil(t, ValidateRelabelConfigs(&RelabelConfig{Regex: "path", Action: RelabelActionLabelDrop}))
+}
+
+func TestRelabelGatherer_WithKeepAndDrop(t *testing.T) {
+	gatherer, err := NewRelabelGatherer(newRelabelTestRegistry(),
+		&RelabelConfig{SourceLabels: []string{"__name__"}, Regex: "ut_relabel_counter", Action: RelabelActionKeep},
+		&RelabelConfig{SourceLabels: []string{"method"}, Regex: "POST", Action: RelabelActionDrop})
+	assert.Nil(t, err)
+
+	families, err := gatherer.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, families, 1)
+	assert.Len(t, families[0].GetMetric(), 1)
+	assert.Equal(t, "GET", families[0].GetMetric()[0].GetLabel()[0].GetValue())
+}
+
+func TestRelabelGatherer_WithReplace(t *testing.T) {
+	gatherer, err := NewRelabelGatherer(newRelabelTestRegistry(),
+		&RelabelConfig{SourceLabels: []string{"instance"}, Regex: "(.*):.*", TargetLabel: "host"},
+		&RelabelConfig{SourceLabels: []string{"__name__"}, Regex: "ut_relabel_(.*)", Replacement: "renamed_$1", TargetLabel: "__name__"})
+	assert.Nil(t, err)
+
+	labels := labelsOf(t, gatherer, "renamed_gauge")
+	assert.Equal(t, "localhost", labels["host"])
+	assert.Equal(t, "localhost:8080", labels["instance"])
+	assert.NotNil(t, labelsOf(t, gatherer, "renamed_counter"))
+}
+
+func TestRelabelGatherer_WithLabelDropAndLabelKeep(t *testing.T) {
+	gatherer, err := NewRelabelGatherer(newRelabelTestRegistry(),
+		&RelabelConfig{Regex: "path", Action: RelabelActionLabelDrop})
+	assert.Nil(t, err)
+
+	labels := labelsOf(t, gatherer, "ut_relabel_counter")
+	assert.NotContains(t, labels, "path")
+	assert.Contains(t, labels, "method")
+
+	gatherer, err = NewRelabelGatherer(newRelabelTestRegistry(),
+		&RelabelConfig{Regex: "path", Action: RelabelActionLabelKeep})
+	assert.Nil(t, err)
+
+	labels = labelsOf(t, gatherer, "ut_relabel_counter")
+	assert.Contains(t, labels, "path")
+	assert.NotContains(t, labels, "method")
+}
+
+func TestRelabelGatherer_WithConflictType(t *testing.T) {
+	gatherer, err := NewRelabelGatherer(newRelabelTestRegistry(),
+		&RelabelConfig{SourceLabels: []string{"__name__"}, Replacement: "same_name", TargetLabel: "__name__"})
+	assert.Nil(t, err)
+
+	families, err := gatherer.Gather()
+	assert.NotNil(t, err)
+	assert.Len(t, families, 1)
+}
+
+func TestRegisterPromEntriesWithConfig_WithRelabel(t *testing.T) {
+	bootFileWithRelabel := `
+---
+prom:
+  enabled: true
+  relabel:
+    - sourceLabels: ["__name__"]
+      regex: "go_.*"
+      action: drop
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithRelabel), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.RelabelConfigs, 1)
+	assert.Equal(t, RelabelActionDrop, entry.RelabelConfigs[0].Action)
+	for name := range gatherNames(t, entry.Gatherer) {
+		assert.NotRegexp(t, "^go_", name)
+	}
+}
+
+func TestRegisterPromEntry_WithRelabelConfigs(t *testing.T) {
+	registry := newRelabelTestRegistry()
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(registry),
+		WithRelabelConfigs(&RelabelConfig{SourceLabels: []string{"__name__"}, Regex: ".*gauge", Action: RelabelActionDrop}))
+
+	names := gatherNames(t, entry.Gatherer)
+	assert.False(t, names["ut_relabel_gauge"])
+	assert.True(t, names["ut_relabel_counter"])
+}
+
+func TestRegisterPromEntry_WithInvalidRelabelConfigs(t *testing.T) {
+	// invalid config would be skipped instead of exiting the process
+	entry := RegisterPromEntry(
+		WithZapLoggerEntry(rkentry.NoopZapLoggerEntry()),
+		WithEventLoggerEntry(rkentry.NoopEventLoggerEntry()),
+		WithPromRegistry(newRelabelTestRegistry()),
+		WithRelabelConfigs(
+			&RelabelConfig{SourceLabels: []string{"__name__"}, Regex: ".*gauge", Action: RelabelActionDrop},
+			&RelabelConfig{Action: "unknown"}))
+
+	// valid config would still be applied
+	assert.Len(t, entry.RelabelConfigs, 1)
+	assert.Equal(t, RelabelActionDrop, entry.RelabelConfigs[0].Action)
+
+	names := gatherNames(t, entry.Gatherer)
+	assert.False(t, names["ut_relabel_gauge"])
+	assert.True(t, names["ut_relabel_counter"])
+}
@@ -0,0 +1,405 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/rookie-ninja/rk-entry/entry"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// RemoteWriteMetricsPrefix is prefix of health metrics of remote write exporter
+	RemoteWriteMetricsPrefix = "rk_remote_write"
+	// RemoteWriteVersion is version of remote write protocol
+	RemoteWriteVersion = "0.1.0"
+
+	remoteWriteBatchSizeDefault = 500
+)
+
+// BootConfigRemoteWrite is remote write exporter config of prom entry.
+//
+// 1: Enabled: Enable remote write exporter.
+// 2: URL: Remote write endpoint, i.e. http://localhost:9009/api/v1/push
+// 3: IntervalMs: Interval of gathering and sending metrics in milliseconds.
+// 4: BatchSize: Maximum number of samples in every request, 500 by default.
+// 5: ExternalLabels: Labels attached to every series which does not contain them, values could be expanded from environment variables.
+// 6: Jitter: Fraction of interval which would be randomized in every cycle.
+// 7: Retry: Retry failed requests with exponential backoff in every cycle.
+// 8: Auth: Basic auth, bearer token and extra headers.
+// 9: TLS: CA bundle, client certificate, server name, minimum version and insecureSkipVerify.
+// 10: HTTPClient: Timeout, proxy and keep-alive of http client.
+type BootConfigRemoteWrite struct {
+	Enabled        bool              `yaml:"enabled" json:"enabled"`
+	URL            string            `yaml:"url" json:"url"`
+	IntervalMs     int64             `yaml:"intervalMs" json:"intervalMs"`
+	BatchSize      int               `yaml:"batchSize" json:"batchSize"`
+	ExternalLabels map[string]string `yaml:"externalLabels" json:"externalLabels"`
+	Jitter         float64           `yaml:"jitter" json:"jitter"`
+	Retry          BootConfigRetry   `yaml:"retry" json:"retry"`
+	Auth           AuthConfig        `yaml:"auth" json:"auth"`
+	TLS            TLSConfig         `yaml:"tls" json:"tls"`
+	HTTPClient     HTTPClientConfig  `yaml:"httpClient" json:"httpClient"`
+}
+
+// RemoteWriteExporter gathers metrics periodically and sends them to remote write endpoint, i.e. Mimir,
+// Thanos receive or VictoriaMetrics, with snappy compressed protobuf.
+// thread safe
+//
+// 1: url:            remote write endpoint
+// 2: batchSize:      maximum number of samples in every request
+// 3: externalLabels: labels attached to every series which does not contain them
+// 4: auth:           basic auth, bearer token and extra headers attached to every request
+// 5: tls:            CA bundle, client certificate, server name and minimum version of TLS
+// 6: httpClient:     timeout, proxy and keep-alive of http client
+type RemoteWriteExporter struct {
+	periodicExporter
+	URL            string            `json:"url" yaml:"url"`
+	BatchSize      int               `json:"batchSize" yaml:"batchSize"`
+	ExternalLabels map[string]string `json:"externalLabels" yaml:"externalLabels"`
+	Auth           *AuthConfig       `json:"auth" yaml:"auth"`
+	TLS            *TLSConfig        `json:"tls" yaml:"tls"`
+	HTTPClient     *HTTPClientConfig `json:"httpClient" yaml:"httpClient"`
+	client         *http.Client      `json:"-" yaml:"-"`
+	roundTripper   http.RoundTripper `json:"-" yaml:"-"`
+}
+
+// RemoteWriteExporterOption is used while initializing remote write exporter via code
+type RemoteWriteExporterOption func(*RemoteWriteExporter)
+
+// WithURLRemoteWrite provides remote write endpoint
+func WithURLRemoteWrite(url string) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.URL = url
+	}
+}
+
+// WithIntervalMSRemoteWrite provides interval in milliseconds
+func WithIntervalMSRemoteWrite(intervalMs time.Duration) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.IntervalMs = intervalMs
+	}
+}
+
+// WithBatchSizeRemoteWrite provides maximum number of samples in every request
+func WithBatchSizeRemoteWrite(batchSize int) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.BatchSize = batchSize
+	}
+}
+
+// WithExternalLabelsRemoteWrite provides labels attached to every series which does not contain them,
+// values could be expanded from environment variables with form of $VAR or ${VAR}
+func WithExternalLabelsRemoteWrite(labels map[string]string) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		if exporter.ExternalLabels == nil {
+			exporter.ExternalLabels = make(map[string]string)
+		}
+
+		for k, v := range labels {
+			exporter.ExternalLabels[k] = v
+		}
+	}
+}
+
+// WithJitterRemoteWrite randomizes interval of every cycle, jitter should be in range of (0, 1]
+func WithJitterRemoteWrite(jitter float64) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.Jitter = jitter
+	}
+}
+
+// WithRetryRemoteWrite retries failed requests with exponential backoff and full jitter in every cycle,
+// requests rejected with 4xx status code except 429 would not be retried
+func WithRetryRemoteWrite(maxRetries int, initialBackoff, maxBackoff, maxElapsed time.Duration) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.retrier = newRetrier(maxRetries, initialBackoff, maxBackoff, maxElapsed)
+	}
+}
+
+// WithAuthRemoteWrite provides auth of remote write endpoint
+func WithAuthRemoteWrite(auth *AuthConfig) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.Auth = auth
+	}
+}
+
+// WithTLSRemoteWrite provides TLS config of remote write endpoint
+func WithTLSRemoteWrite(config *TLSConfig) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.TLS = config
+	}
+}
+
+// WithHTTPClientConfigRemoteWrite provides timeout, proxy and keep-alive of http client,
+// compression would be ignored since requests were always compressed with snappy
+func WithHTTPClientConfigRemoteWrite(config *HTTPClientConfig) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.HTTPClient = config
+	}
+}
+
+// WithHTTPClientRemoteWrite provides a custom http client which would be used as it is, auth would still be attached
+func WithHTTPClientRemoteWrite(client *http.Client) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.client = client
+	}
+}
+
+// WithRoundTripperRemoteWrite provides a custom round tripper, auth would still be attached
+func WithRoundTripperRemoteWrite(roundTripper http.RoundTripper) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.roundTripper = roundTripper
+	}
+}
+
+// WithGathererRemoteWrite provides gatherer, prometheus.DefaultGatherer would be used by default
+func WithGathererRemoteWrite(gatherer prometheus.Gatherer) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.gatherer = gatherer
+	}
+}
+
+// WithZapLoggerEntryRemoteWrite provides ZapLoggerEntry
+func WithZapLoggerEntryRemoteWrite(zapLoggerEntry *rkentry.ZapLoggerEntry) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.ZapLoggerEntry = zapLoggerEntry
+	}
+}
+
+// WithEventLoggerEntryRemoteWrite provides EventLoggerEntry
+func WithEventLoggerEntryRemoteWrite(eventLoggerEntry *rkentry.EventLoggerEntry) RemoteWriteExporterOption {
+	return func(exporter *RemoteWriteExporter) {
+		exporter.EventLoggerEntry = eventLoggerEntry
+	}
+}
+
+// NewRemoteWriteExporter creates a new remote write exporter
+// 1: url:        should be a non empty url
+// 2: batchSize:  500 would be used if not positive
+func NewRemoteWriteExporter(opts ...RemoteWriteExporterOption) (*RemoteWriteExporter, error) {
+	exporter := &RemoteWriteExporter{
+		periodicExporter: newPeriodicExporter(),
+		BatchSize:        remoteWriteBatchSizeDefault,
+	}
+
+	for i := range opts {
+		opts[i](exporter)
+	}
+
+	if len(exporter.URL) < 1 {
+		return nil, errors.New("empty remote write url")
+	}
+
+	if exporter.BatchSize < 1 {
+		exporter.BatchSize = remoteWriteBatchSizeDefault
+	}
+
+	exporter.ExternalLabels = expandLabels(exporter.ExternalLabels)
+	for k := range exporter.ExternalLabels {
+		if !model.LabelName(k).IsValid() {
+			return nil, errors.New(fmt.Sprintf("invalid external label name:%s", k))
+		}
+	}
+
+	clientOpts := &httpClientOptions{
+		config:       exporter.HTTPClient,
+		tls:          exporter.TLS,
+		auth:         exporter.Auth,
+		client:       exporter.client,
+		roundTripper: exporter.roundTripper,
+	}
+
+	client, err := clientOpts.newClient()
+	if err != nil {
+		return nil, err
+	}
+	exporter.client = client
+
+	if err := exporter.init("remote write exporter", exporter.URL, exporter, RemoteWriteMetricsPrefix, prometheus.Labels{
+		"remote_write_url": exporter.URL,
+	}); err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// encode series into snappy compressed write requests, every request contains at most batchSize samples
+func (exporter *RemoteWriteExporter) encode(families []*dto.MetricFamily, now time.Time) ([]*exportPayload, error) {
+	series := toTimeSeries(families, exporter.ExternalLabels, now)
+	res := make([]*exportPayload, 0, len(series)/exporter.BatchSize+1)
+
+	for start := 0; start < len(series); start += exporter.BatchSize {
+		end := start + exporter.BatchSize
+		if end > len(series) {
+			end = len(series)
+		}
+
+		res = append(res, &exportPayload{body: snappy.Encode(nil, marshalWriteRequest(series[start:end]))})
+	}
+
+	return res, nil
+}
+
+// send a write request to remote write endpoint
+func (exporter *RemoteWriteExporter) send(ctx context.Context, payload []byte) error {
+	return sendRequest(ctx, exporter.client, http.MethodPost, exporter.URL, payload, http.Header{
+		"Content-Type":                      {"application/x-protobuf"},
+		"Content-Encoding":                  {"snappy"},
+		"User-Agent":                        {"rk-prom"},
+		"X-Prometheus-Remote-Write-Version": {RemoteWriteVersion},
+	})
+}
+
+// String returns string value of RemoteWriteExporter
+func (exporter *RemoteWriteExporter) String() string {
+	bytes, err := json.Marshal(exporter)
+	if err != nil {
+		// failed to marshal, just return empty string
+		return "{}"
+	}
+
+	return string(bytes)
+}
+
+// timeSeries is a series of remote write protocol with only one sample,
+// cumulative is true for counters and _bucket, _sum and _count series of histograms and summaries
+type timeSeries struct {
+	labels      []*dto.LabelPair
+	value       float64
+	timestampMs int64
+	cumulative  bool
+}
+
+// toTimeSeries converts metric families into time series of remote write protocol.
+// Summaries and histograms were expanded into _sum, _count, quantile and _bucket series as scraping does.
+// External labels would be attached to series which does not contain them, labels of series were sorted.
+func toTimeSeries(families []*dto.MetricFamily, externalLabels map[string]string, now time.Time) []*timeSeries {
+	res := make([]*timeSeries, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	for _, family := range families {
+		name := family.GetName()
+
+		for _, metric := range family.GetMetric() {
+			timestampMs := nowMs
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, cumulative bool, extraName, extraValue string) {
+				labels := make(map[string]string, len(metric.GetLabel())+len(externalLabels)+2)
+				for k, v := range externalLabels {
+					labels[k] = v
+				}
+				for _, pair := range metric.GetLabel() {
+					labels[pair.GetName()] = pair.GetValue()
+				}
+				if len(extraName) > 0 {
+					labels[extraName] = extraValue
+				}
+
+				pairs := toLabelPairs(labels)
+				pairs = append(pairs, &dto.LabelPair{Name: proto.String(model.MetricNameLabel), Value: proto.String(name)})
+				sort.Slice(pairs, func(i, j int) bool {
+					return pairs[i].GetName() < pairs[j].GetName()
+				})
+
+				res = append(res, &timeSeries{labels: pairs, value: value, timestampMs: timestampMs, cumulative: cumulative})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue(), true, "", "")
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue(), false, "", "")
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue(), false, "", "")
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, quantile.GetValue(), false, model.QuantileLabel, formatBound(quantile.GetQuantile()))
+				}
+				add(name+"_sum", summary.GetSampleSum(), true, "", "")
+				add(name+"_count", float64(summary.GetSampleCount()), true, "", "")
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infSeen := false
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), true, model.BucketLabel, formatBound(bucket.GetUpperBound()))
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(histogram.GetSampleCount()), true, model.BucketLabel, formatBound(math.Inf(1)))
+				}
+				add(name+"_sum", histogram.GetSampleSum(), true, "", "")
+				add(name+"_count", float64(histogram.GetSampleCount()), true, "", "")
+			}
+		}
+	}
+
+	return res
+}
+
+// marshalWriteRequest encodes series as prometheus.WriteRequest protobuf message
+//
+// message WriteRequest { repeated TimeSeries timeseries = 1; }
+// message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+// message Label        { string name = 1; string value = 2; }
+// message Sample       { double value = 1; int64 timestamp = 2; }
+func marshalWriteRequest(series []*timeSeries) []byte {
+	res := make([]byte, 0)
+
+	for _, ts := range series {
+		msg := make([]byte, 0)
+
+		for _, pair := range ts.labels {
+			label := make([]byte, 0, len(pair.GetName())+len(pair.GetValue())+4)
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, pair.GetName())
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, pair.GetValue())
+
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, label)
+		}
+
+		sample := make([]byte, 0, 20)
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(ts.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts.timestampMs))
+
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, sample)
+
+		res = protowire.AppendTag(res, 1, protowire.BytesType)
+		res = protowire.AppendBytes(res, msg)
+	}
+
+	return res
+}
+
+// formatBound formats upper bound of bucket and quantile as exposition format does
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
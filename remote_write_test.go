@@ -0,0 +1,305 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedSeries is a decoded series of remote write request
+type receivedSeries struct {
+	labels      map[string]string
+	value       float64
+	timestampMs int64
+}
+
+// remoteWriteReceiver is a local stand-in of remote write endpoint which decodes requests
+type remoteWriteReceiver struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests [][]*receivedSeries
+	headers  []http.Header
+}
+
+func newRemoteWriteReceiver(t *testing.T) *remoteWriteReceiver {
+	receiver := &remoteWriteReceiver{status: http.StatusNoContent}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.lock.Lock()
+		defer receiver.lock.Unlock()
+
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		assert.Nil(t, err)
+
+		receiver.requests = append(receiver.requests, decodeWriteRequest(t, body))
+		receiver.headers = append(receiver.headers, r.Header)
+		w.WriteHeader(receiver.status)
+	}))
+
+	return receiver
+}
+
+func (receiver *remoteWriteReceiver) setStatus(status int) {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	receiver.status = status
+}
+
+func (receiver *remoteWriteReceiver) getRequests() [][]*receivedSeries {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	return append([][]*receivedSeries{}, receiver.requests...)
+}
+
+// find series in all requests with labels including __name__
+func (receiver *remoteWriteReceiver) find(labels map[string]string) *receivedSeries {
+	for _, req := range receiver.getRequests() {
+		for _, series := range req {
+			matched := true
+			for k, v := range labels {
+				if series.labels[k] != v {
+					matched = false
+				}
+			}
+
+			if matched {
+				return series
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeWriteRequest decodes prometheus.WriteRequest
+func decodeWriteRequest(t *testing.T, b []byte) []*receivedSeries {
+	res := make([]*receivedSeries, 0)
+
+	forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		series := &receivedSeries{labels: make(map[string]string)}
+
+		forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, value string
+				forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				series.labels[name] = value
+			case 2:
+				forEachField(t, v, func(num protowire.Number, _ []byte, n uint64) {
+					if num == 1 {
+						series.value = math.Float64frombits(n)
+					} else {
+						series.timestampMs = int64(n)
+					}
+				})
+			}
+		})
+
+		res = append(res, series)
+	})
+
+	return res
+}
+
+// forEachField calls fn with bytes of length delimited fields and numbers of fixed64 and varint fields
+func forEachField(t *testing.T, b []byte, fn func(protowire.Number, []byte, uint64)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			assert.True(t, n > 0)
+			fn(num, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			assert.True(t, n > 0)
+			fn(num, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			assert.True(t, n > 0)
+			fn(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type:%v", typ)
+		}
+	}
+}
+
+func TestNewRemoteWriteExporter_WithInvalidArgs(t *testing.T) {
+	// without url
+	exporter, err := NewRemoteWriteExporter(
+		WithIntervalMSRemoteWrite(intervalMs))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+
+	// with invalid external label
+	exporter, err = NewRemoteWriteExporter(
+		WithURLRemoteWrite("http://localhost:9009/api/v1/push"),
+		WithExternalLabelsRemoteWrite(map[string]string{"invalid-label": "value"}))
+	assert.Nil(t, exporter)
+	assert.NotNil(t, err)
+}
+
+func TestNewRemoteWriteExporter_HappyCase(t *testing.T) {
+	assert.Nil(t, os.Setenv("UT_REGION", "us-east-1"))
+	defer os.Unsetenv("UT_REGION")
+
+	exporter, err := NewRemoteWriteExporter(
+		WithURLRemoteWrite("http://localhost:9009/api/v1/push"),
+		WithBatchSizeRemoteWrite(-1),
+		WithExternalLabelsRemoteWrite(map[string]string{"region": "$UT_REGION"}),
+		WithZapLoggerEntryRemoteWrite(zapLoggerEntry),
+		WithEventLoggerEntryRemoteWrite(eventLoggerEntry))
+	assert.Nil(t, err)
+	assert.Equal(t, remoteWriteBatchSizeDefault, exporter.BatchSize)
+	assert.Equal(t, 15*time.Second, exporter.IntervalMs)
+	assert.Equal(t, "us-east-1", exporter.ExternalLabels["region"])
+	assert.False(t, exporter.IsRunning())
+}
+
+func TestRemoteWriteExporter_ExportNow(t *testing.T) {
+	receiver := newRemoteWriteReceiver(t)
+	defer receiver.Close()
+
+	exporter, err := NewRemoteWriteExporter(
+		WithURLRemoteWrite(receiver.URL),
+		WithExternalLabelsRemoteWrite(map[string]string{"cluster": "ut-cluster", "instance": "ut-external"}),
+		WithAuthRemoteWrite(&AuthConfig{BearerToken: "ut-token"}),
+		WithZapLoggerEntryRemoteWrite(zapLoggerEntry),
+		WithEventLoggerEntryRemoteWrite(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ut_counter"}, []string{"instance"})
+	counter.WithLabelValues("ut-instance").Add(3)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ut_histogram", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.7)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "ut_summary", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(2)
+	registry.MustRegister(counter, histogram, summary)
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+	assert.Len(t, receiver.getRequests(), 1)
+
+	header := receiver.headers[0]
+	assert.Equal(t, "snappy", header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", header.Get("Content-Type"))
+	assert.Equal(t, RemoteWriteVersion, header.Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, "Bearer ut-token", header.Get("Authorization"))
+
+	// labels of metric would not be overridden by external labels
+	series := receiver.find(map[string]string{"__name__": "ut_counter"})
+	assert.NotNil(t, series)
+	assert.Equal(t, float64(3), series.value)
+	assert.Equal(t, "ut-instance", series.labels["instance"])
+	assert.Equal(t, "ut-cluster", series.labels["cluster"])
+	assert.True(t, series.timestampMs > 0)
+
+	series = receiver.find(map[string]string{"__name__": "ut_histogram_bucket", "le": "0.5"})
+	assert.Equal(t, float64(0), series.value)
+	series = receiver.find(map[string]string{"__name__": "ut_histogram_bucket", "le": "+Inf"})
+	assert.Equal(t, float64(1), series.value)
+	series = receiver.find(map[string]string{"__name__": "ut_histogram_count"})
+	assert.Equal(t, float64(1), series.value)
+
+	series = receiver.find(map[string]string{"__name__": "ut_summary", "quantile": "0.5"})
+	assert.Equal(t, float64(2), series.value)
+	series = receiver.find(map[string]string{"__name__": "ut_summary_sum"})
+	assert.Equal(t, float64(2), series.value)
+}
+
+func TestRemoteWriteExporter_ExportNow_WithBatches(t *testing.T) {
+	receiver := newRemoteWriteReceiver(t)
+	defer receiver.Close()
+
+	exporter, err := NewRemoteWriteExporter(
+		WithURLRemoteWrite(receiver.URL),
+		WithBatchSizeRemoteWrite(2),
+		WithZapLoggerEntryRemoteWrite(zapLoggerEntry),
+		WithEventLoggerEntryRemoteWrite(eventLoggerEntry))
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ut_gauge"}, []string{"index"})
+	for _, index := range []string{"0", "1", "2", "3", "4"} {
+		gauge.WithLabelValues(index).Set(1)
+	}
+	registry.MustRegister(gauge)
+	exporter.SetGatherer(registry)
+
+	assert.Nil(t, exporter.ExportNow(context.Background()))
+
+	requests := receiver.getRequests()
+	assert.Len(t, requests, 3)
+	assert.Len(t, requests[0], 2)
+	assert.Len(t, requests[2], 1)
+}
+
+func TestRegisterPromEntriesWithConfig_WithRemoteWrite(t *testing.T) {
+	bootFileWithRemoteWrite := `
+---
+prom:
+  enabled: true
+  remoteWrite:
+    enabled: true
+    url: "http://localhost:9009/api/v1/push"
+    intervalMs: 2000
+    batchSize: 100
+    externalLabels:
+      cluster: ut-cluster
+    retry:
+      maxRetries: 2
+    auth:
+      bearerToken: ut-token
+`
+	configFilePath := path.Join(t.TempDir(), "boot.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFilePath, []byte(bootFileWithRemoteWrite), os.ModePerm))
+	entries := RegisterPromEntriesWithConfig(configFilePath)
+	entry := entries[PromEntryNameDefault].(*PromEntry)
+
+	assert.Len(t, entry.Exporters, 1)
+	remoteWrite := entry.Exporters[0].(*RemoteWriteExporter)
+	assert.Equal(t, "http://localhost:9009/api/v1/push", remoteWrite.URL)
+	assert.Equal(t, 2*time.Second, remoteWrite.IntervalMs)
+	assert.Equal(t, 100, remoteWrite.BatchSize)
+	assert.Equal(t, "ut-cluster", remoteWrite.ExternalLabels["cluster"])
+	assert.Equal(t, 2, remoteWrite.retrier.maxRetries)
+	assert.Equal(t, "ut-token", remoteWrite.Auth.BearerToken)
+	assert.Contains(t, entry.String(), "http://localhost:9009/api/v1/push")
+}
+
+func TestFormatBound(t *testing.T) {
+	assert.Equal(t, "0.005", formatBound(0.005))
+	assert.Equal(t, "1", formatBound(1))
+	assert.Equal(t, "1e+06", formatBound(1e6))
+	assert.Equal(t, "+Inf", formatBound(math.Inf(1)))
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	initialBackoffDefault = 100 * time.Millisecond
+	maxBackoffDefault     = 5 * time.Second
+	backoffMultiplier     = 2
+)
+
+// random is a goroutine safe random source seeded with current time,
+// so that jitters of replicas would be different
+var random = &lockedRandom{
+	rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+}
+
+// Internal use only
+type lockedRandom struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// float64 returns a random number in [0.0, 1.0)
+func (r *lockedRandom) float64() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.rand.Float64()
+}
+
+// BootConfigRetry is retry config of pusher.
+//
+// 1: MaxRetries: Maximum retries in every cycle, 0 means no retry.
+// 2: InitialBackoffMs: Backoff before the first retry in milliseconds, doubled for every retry.
+// 3: MaxBackoffMs: Maximum backoff in milliseconds.
+// 4: MaxElapsedMs: Retries would not be scheduled after elapsed in milliseconds, interval by default.
+type BootConfigRetry struct {
+	MaxRetries       int   `yaml:"maxRetries" json:"maxRetries"`
+	InitialBackoffMs int64 `yaml:"initialBackoffMs" json:"initialBackoffMs"`
+	MaxBackoffMs     int64 `yaml:"maxBackoffMs" json:"maxBackoffMs"`
+	MaxElapsedMs     int64 `yaml:"maxElapsedMs" json:"maxElapsedMs"`
+}
+
+// retrier retries a function with exponential backoff and full jitter.
+//
+// 1: maxRetries:     maximum retries, 0 means no retry
+// 2: initialBackoff: backoff before the first retry, doubled for every retry
+// 3: maxBackoff:     maximum backoff
+// 4: maxElapsed:     retries would not be scheduled if the next attempt would start after it
+type retrier struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxElapsed     time.Duration
+}
+
+// Internal use only
+func newRetrier(maxRetries int, initialBackoff, maxBackoff, maxElapsed time.Duration) *retrier {
+	res := &retrier{
+		maxRetries:     maxRetries,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		maxElapsed:     maxElapsed,
+	}
+
+	if res.initialBackoff <= 0 {
+		res.initialBackoff = initialBackoffDefault
+	}
+
+	if res.maxBackoff <= 0 {
+		res.maxBackoff = maxBackoffDefault
+	}
+
+	if res.maxBackoff < res.initialBackoff {
+		res.maxBackoff = res.initialBackoff
+	}
+
+	return res
+}
+
+// do calls fn until it succeeds, retries were exhausted, ctx was canceled or the next attempt would
+// start after maxElapsed. bound would be used as maxElapsed if maxElapsed is not set or larger than it,
+// so that retries would not overlap next cycle. ctx passed to fn would be canceled once maxElapsed was reached,
+// so that time spent in fn is bounded as well. The last error would be returned.
+func (r *retrier) do(ctx context.Context, bound time.Duration, fn func(context.Context) error) error {
+	maxElapsed := r.maxElapsed
+	if bound > 0 && (maxElapsed <= 0 || maxElapsed > bound) {
+		maxElapsed = bound
+	}
+
+	start := time.Now()
+	backoff := r.initialBackoff
+
+	if maxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(maxElapsed))
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= r.maxRetries || ctx.Err() != nil {
+			return unwrapPermanent(err)
+		}
+
+		if _, ok := err.(*permanentError); ok {
+			return unwrapPermanent(err)
+		}
+
+		// full jitter
+		sleep := time.Duration(random.float64() * float64(backoff))
+		if maxElapsed > 0 && time.Since(start)+sleep >= maxElapsed {
+			return err
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= backoffMultiplier
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// permanentError would not be retried, i.e. request was rejected with 4xx status code
+type permanentError struct {
+	err error
+}
+
+// Error implements error
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// unwrapPermanent returns the original error if err is a permanentError
+func unwrapPermanent(err error) error {
+	if perm, ok := err.(*permanentError); ok {
+		return perm.err
+	}
+
+	return err
+}
+
+// jitter randomizes duration in range of [d*(1-fraction), d*(1+fraction)).
+// d would be returned if fraction is not in range of (0, 1].
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || fraction > 1 {
+		return d
+	}
+
+	res := time.Duration(float64(d) * (1 + fraction*(2*random.float64()-1)))
+	if res < time.Millisecond {
+		res = time.Millisecond
+	}
+
+	return res
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewRetrier_WithDefault(t *testing.T) {
+	r := newRetrier(1, 0, 0, 0)
+	assert.Equal(t, initialBackoffDefault, r.initialBackoff)
+	assert.Equal(t, maxBackoffDefault, r.maxBackoff)
+
+	r = newRetrier(1, time.Second, time.Millisecond, 0)
+	assert.Equal(t, time.Second, r.maxBackoff)
+}
+
+func TestRetrier_Do_WithSuccessAfterRetries(t *testing.T) {
+	r := newRetrier(3, time.Millisecond, 2*time.Millisecond, 0)
+
+	attempts := 0
+	err := r.do(context.Background(), time.Second, func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("ut-error")
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetrier_Do_WithRetriesExhausted(t *testing.T) {
+	r := newRetrier(2, time.Millisecond, time.Millisecond, 0)
+
+	attempts := 0
+	err := r.do(context.Background(), time.Second, func(context.Context) error {
+		attempts++
+		return errors.New("ut-error")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetrier_Do_WithMaxElapsed(t *testing.T) {
+	r := newRetrier(100, 20*time.Millisecond, 20*time.Millisecond, time.Hour)
+
+	attempts := 0
+	start := time.Now()
+	err := r.do(context.Background(), 50*time.Millisecond, func(context.Context) error {
+		attempts++
+		return errors.New("ut-error")
+	})
+
+	assert.NotNil(t, err)
+	// bounded by interval instead of retries
+	assert.True(t, attempts > 1 && attempts < 101)
+	assert.True(t, time.Since(start) < 250*time.Millisecond)
+}
+
+func TestRetrier_Do_WithSlowAttempt(t *testing.T) {
+	r := newRetrier(100, time.Millisecond, time.Millisecond, 0)
+
+	attempts := 0
+	start := time.Now()
+	err := r.do(context.Background(), 50*time.Millisecond, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// attempt would be canceled once bound was reached
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, time.Since(start) < 250*time.Millisecond)
+}
+
+func TestRetrier_Do_WithCanceledContext(t *testing.T) {
+	r := newRetrier(100, time.Hour, time.Hour, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	attempts := 0
+	err := r.do(ctx, 0, func(context.Context) error {
+		attempts++
+		return errors.New("ut-error")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetrier_Do_WithPermanentError(t *testing.T) {
+	r := newRetrier(3, time.Millisecond, time.Millisecond, 0)
+
+	attempts := 0
+	err := r.do(context.Background(), 0, func(context.Context) error {
+		attempts++
+		return &permanentError{err: errors.New("ut-error")}
+	})
+
+	// permanent error would not be retried and should be unwrapped
+	assert.Equal(t, "ut-error", err.Error())
+	_, ok := err.(*permanentError)
+	assert.False(t, ok)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestJitter_HappyCase(t *testing.T) {
+	assert.Equal(t, time.Second, jitter(time.Second, 0))
+	assert.Equal(t, time.Second, jitter(time.Second, 2))
+
+	for i := 0; i < 100; i++ {
+		res := jitter(time.Second, 0.5)
+		assert.True(t, res >= 500*time.Millisecond)
+		assert.True(t, res < 1500*time.Millisecond)
+	}
+}
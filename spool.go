@@ -0,0 +1,255 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolMaxBytesDefault = 64 * 1024 * 1024
+	spoolMaxAgeDefault   = time.Hour
+	spoolFileSuffix      = ".json"
+)
+
+// BootConfigSpool is spool config of pusher.
+//
+// 1: Dir: Directory where failed payloads would be written, spool is disabled if empty.
+// 2: MaxBytes: Maximum total size of spooled payloads, oldest ones would be removed first, 64MB by default.
+// 3: MaxAgeMs: Payloads older than it would be removed without replaying, one hour by default.
+type BootConfigSpool struct {
+	Dir      string `yaml:"dir" json:"dir"`
+	MaxBytes int64  `yaml:"maxBytes" json:"maxBytes"`
+	MaxAgeMs int64  `yaml:"maxAgeMs" json:"maxAgeMs"`
+}
+
+// spoolRecord is a failed request written into spool.
+// Auth headers would not be written, they would be attached again while replaying.
+// URL was kept for reference, records would be replayed to the group of current settings of pusher.
+type spoolRecord struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Method          string    `json:"method"`
+	URL             string    `json:"url"`
+	ContentType     string    `json:"contentType"`
+	ContentEncoding string    `json:"contentEncoding"`
+	Body            []byte    `json:"body"`
+}
+
+// spool is a durable on-disk buffer of failed requests, records were named by timestamp so that they could be
+// replayed in order, and capped by total size and age.
+// thread safe
+type spool struct {
+	dir         string
+	maxBytes    int64
+	maxAge      time.Duration
+	lock        sync.Mutex
+	seq         uint64
+	backlogDesc *prometheus.Desc
+}
+
+// newSpool creates spool directory if missing, metrics would be named with prefix and labeled with constLabels
+func newSpool(dir string, maxBytes int64, maxAge time.Duration, prefix string, constLabels prometheus.Labels) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create spool directory")
+	}
+
+	res := &spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		backlogDesc: newBacklogDesc(prefix, constLabels),
+	}
+
+	if res.maxBytes <= 0 {
+		res.maxBytes = spoolMaxBytesDefault
+	}
+
+	if res.maxAge <= 0 {
+		res.maxAge = spoolMaxAgeDefault
+	}
+
+	return res, nil
+}
+
+// write record into spool and prune records exceeded size or age
+func (s *spool) write(record *spoolRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// write into temporary file and rename, so that partial records would never be replayed
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", record.Timestamp.UnixNano(), s.seq%1000000, spoolFileSuffix)
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return errors.Wrap(err, "failed to write spool record")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to write spool record")
+	}
+
+	s.prune()
+
+	return nil
+}
+
+// list records in order of timestamp, lock should be held by caller
+func (s *spool) list() []os.FileInfo {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+
+	res := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), spoolFileSuffix) && !strings.HasPrefix(info.Name(), ".") {
+			res = append(res, info)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+
+	return res
+}
+
+// prune removes records older than maxAge and oldest records until total size is under maxBytes,
+// lock should be held by caller
+func (s *spool) prune() {
+	infos := s.list()
+
+	total := int64(0)
+	for _, info := range infos {
+		total += info.Size()
+	}
+
+	now := time.Now()
+	for _, info := range infos {
+		if total <= s.maxBytes && now.Sub(recordTime(info.Name())) <= s.maxAge {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+// replay sends records in order and removes them once sent, replay stops at the first failure
+// so that order would be kept. Record failed with permanentError would be dropped and replay continues,
+// the last of such errors would be returned if no other failure occurred. Number of replayed records would be returned.
+func (s *spool) replay(ctx context.Context, send func(context.Context, *spoolRecord) error) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune()
+
+	replayed := 0
+	var rejected error
+	for _, info := range s.list() {
+		if ctx.Err() != nil {
+			return replayed, ctx.Err()
+		}
+
+		path := filepath.Join(s.dir, info.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return replayed, errors.Wrap(err, "failed to read spool record")
+		}
+
+		record := &spoolRecord{}
+		if err := json.Unmarshal(content, record); err != nil {
+			// corrupted record could never be replayed
+			os.Remove(path)
+			continue
+		}
+
+		if err := send(ctx, record); err != nil {
+			if _, ok := err.(*permanentError); !ok {
+				return replayed, err
+			}
+
+			// rejected record could never be replayed
+			os.Remove(path)
+			rejected = unwrapPermanent(err)
+			continue
+		}
+
+		os.Remove(path)
+		replayed++
+	}
+
+	return replayed, rejected
+}
+
+// backlog returns number of records in spool
+func (s *spool) backlog() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.list())
+}
+
+// relabel rebuilds metrics with constLabels, spool should be unregistered from registerers before
+// and registered again afterwards
+func (s *spool) relabel(prefix string, constLabels prometheus.Labels) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.backlogDesc = newBacklogDesc(prefix, constLabels)
+}
+
+// Internal use only
+func (s *spool) desc() *prometheus.Desc {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.backlogDesc
+}
+
+// Describe implements prometheus.Collector
+func (s *spool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc()
+}
+
+// Collect implements prometheus.Collector
+func (s *spool) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(s.desc(), prometheus.GaugeValue, float64(s.backlog()))
+}
+
+// newBacklogDesc creates descriptor of backlog named with prefix and labeled with constLabels
+func newBacklogDesc(prefix string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prefix+"_spool_backlog",
+		"Number of failed payloads waiting in spool to be replayed.", nil, constLabels)
+}
+
+// recordTime parses timestamp from name of record
+func recordTime(name string) time.Time {
+	var nanos int64
+	if _, err := fmt.Sscanf(name, "%d-", &nanos); err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2020 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkprom
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newSpoolRecord(body string, timestamp time.Time) *spoolRecord {
+	return &spoolRecord{
+		Timestamp: timestamp,
+		Method:    "PUT",
+		URL:       "http://localhost:9091/metrics/job/ut",
+		Body:      []byte(body),
+	}
+}
+
+func TestNewSpool_WithDefault(t *testing.T) {
+	dir := path.Join(t.TempDir(), "spool")
+	s, err := newSpool(dir, 0, 0, "ut", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(spoolMaxBytesDefault), s.maxBytes)
+	assert.Equal(t, spoolMaxAgeDefault, s.maxAge)
+
+	// directory created
+	info, err := os.Stat(dir)
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestNewSpool_WithInvalidDir(t *testing.T) {
+	file := path.Join(t.TempDir(), "file")
+	assert.Nil(t, ioutil.WriteFile(file, []byte{}, os.ModePerm))
+
+	s, err := newSpool(path.Join(file, "spool"), 0, 0, "ut", nil)
+	assert.Nil(t, s)
+	assert.NotNil(t, err)
+}
+
+func TestSpool_replay_InOrder(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 0, 0, "ut", nil)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, s.write(newSpoolRecord("second", now)))
+	assert.Nil(t, s.write(newSpoolRecord("first", now.Add(-time.Second))))
+	assert.Nil(t, s.write(newSpoolRecord("third", now.Add(time.Second))))
+	assert.Equal(t, 3, s.backlog())
+
+	// stop at the first failure
+	sent := make([]string, 0)
+	replayed, err := s.replay(context.Background(), func(ctx context.Context, record *spoolRecord) error {
+		if string(record.Body) == "third" {
+			return errors.New("ut-error")
+		}
+		sent = append(sent, string(record.Body))
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Equal(t, []string{"first", "second"}, sent)
+	assert.Equal(t, 1, s.backlog())
+
+	replayed, err = s.replay(context.Background(), func(ctx context.Context, record *spoolRecord) error {
+		assert.Equal(t, "third", string(record.Body))
+		assert.Equal(t, "PUT", record.Method)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 0, s.backlog())
+}
+
+func TestSpool_replay_WithRejectedRecord(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 0, 0, "ut", nil)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, s.write(newSpoolRecord("rejected", now.Add(-time.Second))))
+	assert.Nil(t, s.write(newSpoolRecord("valid", now)))
+
+	// rejected record would be dropped and replay continues
+	sent := make([]string, 0)
+	replayed, err := s.replay(context.Background(), func(ctx context.Context, record *spoolRecord) error {
+		if string(record.Body) == "rejected" {
+			return &permanentError{err: errors.New("ut-error")}
+		}
+		sent = append(sent, string(record.Body))
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "ut-error", err.Error())
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, []string{"valid"}, sent)
+	assert.Equal(t, 0, s.backlog())
+}
+
+func TestSpool_replay_WithCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 0, 0, "ut", nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.write(newSpoolRecord("valid", time.Now())))
+	corrupted := path.Join(dir, "00000000000000000001-000000"+spoolFileSuffix)
+	assert.Nil(t, ioutil.WriteFile(corrupted, []byte("invalid"), os.ModePerm))
+	assert.Nil(t, os.Chtimes(corrupted, time.Now(), time.Now()))
+
+	replayed, err := s.replay(context.Background(), func(ctx context.Context, record *spoolRecord) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 0, s.backlog())
+}
+
+func TestSpool_prune(t *testing.T) {
+	// by age
+	s, err := newSpool(t.TempDir(), 0, time.Minute, "ut", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.write(newSpoolRecord("expired", time.Now().Add(-time.Hour))))
+	assert.Nil(t, s.write(newSpoolRecord("valid", time.Now())))
+	assert.Equal(t, 1, s.backlog())
+
+	// by size
+	s, err = newSpool(t.TempDir(), 500, 0, "ut", nil)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, s.write(newSpoolRecord("payload", time.Now())))
+	}
+
+	total := int64(0)
+	for _, info := range s.list() {
+		total += info.Size()
+	}
+	assert.True(t, total <= 500)
+	assert.True(t, s.backlog() > 0 && s.backlog() < 10)
+}
+
+func TestSpool_Collect(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 0, 0, "ut", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.write(newSpoolRecord("payload", time.Now())))
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(s))
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, families, 1)
+	assert.Equal(t, "ut_spool_backlog", families[0].GetName())
+	assert.Equal(t, float64(1), families[0].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestRecordTime(t *testing.T) {
+	assert.Equal(t, time.Unix(0, 100), recordTime("00000000000000000100-000001.json"))
+	assert.True(t, recordTime("invalid").IsZero())
+}